@@ -2,8 +2,14 @@ package gdrive // nolint: golint
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	log "github.com/fclairamb/go-log"
 	"google.golang.org/api/drive/v3"
@@ -14,11 +20,24 @@ import (
 
 // APIWrapper allows to wrap some GDrive API calls to perform some caching
 type APIWrapper struct {
-	UseCache bool
-	srv      *drive.Service
-	cache    *cache.Cache
-	logger   log.Logger
-	calls    map[string]*int32
+	UseCache      bool
+	srv           *drive.Service
+	httpClient    *http.Client
+	sharedDriveID string
+	cache         *cache.Cache
+	logger        log.Logger
+	calls         map[string]*int32
+	noDescription bool // noDescription prevents from setting the default description of the created files
+	recentMu      *sync.Mutex
+	recent        map[string]time.Time // recent contains the files created recently, by folder and name
+	limiter       *rateLimiter         // limiter throttles all the calls performed through httpClient
+	lookupsMu     *sync.Mutex
+	lookups       map[string]*folderLookups // lookups counts the recent lookups, by folder
+	// coalesceLookups makes the lookups in a folder be served by listing all its children at once when many
+	// of them are performed in a short time
+	coalesceLookups bool
+	// notFoundTTL is how long the lookups that didn't find any file are cached, they aren't cached if it is 0
+	notFoundTTL time.Duration
 }
 
 // NewAPIWrapper instantiates a new APIWrapper
@@ -28,15 +47,70 @@ func NewAPIWrapper(srv *drive.Service, logger log.Logger) *APIWrapper {
 		cache:  cache.NewCache(),
 		logger: logger,
 		calls: map[string]*int32{
-			"Files.Create": new(int32),
-			"Files.Update": new(int32),
-			"Files.Delete": new(int32),
-			"Files.List":   new(int32),
+			"Files.Create":     new(int32),
+			"Files.Update":     new(int32),
+			"Files.Delete":     new(int32),
+			"Files.EmptyTrash": new(int32),
+			"Files.List":       new(int32),
+			"Batch":            new(int32),
+			"Drives.List":      new(int32),
 		},
-		UseCache: true,
+		recentMu:    &sync.Mutex{},
+		recent:      make(map[string]time.Time),
+		lookupsMu:   &sync.Mutex{},
+		lookups:     make(map[string]*folderLookups),
+		notFoundTTL: notFoundTTLDefault,
+		UseCache:    true,
 	}
 }
 
+// createdDescription is the default description of the created files and directories
+const createdDescription = "Created by https://github.com/fclairamb/afero-gdrive"
+
+// recentCreateWindow is the duration during which a created file is considered as recently created
+const recentCreateWindow = time.Minute
+
+func recentKey(folderID, fileName string) string {
+	return folderID + "/" + sanitizeName(fileName)
+}
+
+// created records the creation of a file and forgets the files that are no longer recent
+func (a *APIWrapper) created(folderID, fileName string) {
+	a.recentMu.Lock()
+	defer a.recentMu.Unlock()
+
+	now := time.Now()
+
+	for key, t := range a.recent {
+		if now.Sub(t) > recentCreateWindow {
+			delete(a.recent, key)
+		}
+	}
+
+	a.recent[recentKey(folderID, fileName)] = now
+}
+
+// recentlyCreated returns true if a file was created with that name in this folder recently
+func (a *APIWrapper) recentlyCreated(folderID, fileName string) bool {
+	a.recentMu.Lock()
+	defer a.recentMu.Unlock()
+
+	t, ok := a.recent[recentKey(folderID, fileName)]
+
+	return ok && time.Since(t) <= recentCreateWindow
+}
+
+// filesList prepares a Files.List call, restricted to the shared drive if one is used
+func (a *APIWrapper) filesList() *drive.FilesListCall {
+	call := a.srv.Files.List().SupportsAllDrives(true)
+
+	if a.sharedDriveID != "" {
+		call = call.Corpora("drive").DriveId(a.sharedDriveID).IncludeItemsFromAllDrives(true)
+	}
+
+	return call
+}
+
 func (a *APIWrapper) calling(apiName string) {
 	atomic.AddInt32(a.calls[apiName], 1)
 }
@@ -44,13 +118,24 @@ func (a *APIWrapper) calling(apiName string) {
 // TotalNbCalls returns the total number of calls performed to the API
 func (a *APIWrapper) TotalNbCalls() int {
 	nb := int32(0)
-	for _, c := range a.calls {
-		nb += *c
+	for _, c := range a.Counts() {
+		nb += c
 	}
 
 	return int(nb)
 }
 
+// Counts returns a snapshot of the number of calls performed to the API through the APIWrapper, by API method
+// like "Files.List"
+func (a *APIWrapper) Counts() map[string]int32 {
+	counts := make(map[string]int32, len(a.calls))
+	for name, c := range a.calls {
+		counts[name] = atomic.LoadInt32(c)
+	}
+
+	return counts
+}
+
 // createFile wraps a call to the Files.Create
 func (a *APIWrapper) createFile(
 	folderID string,
@@ -60,14 +145,19 @@ func (a *APIWrapper) createFile(
 ) (*drive.File, error) {
 	a.calling("Files.Create")
 
-	call := a.srv.Files.Create(&drive.File{
-		Name:        sanitizeName(fileName),
-		MimeType:    mimeType,
-		Description: "Created by https://github.com/fclairamb/afero-gdrive",
+	metadata := &drive.File{
+		Name:     sanitizeName(fileName),
+		MimeType: mimeType,
 		Parents: []string{
 			folderID,
 		},
-	}).Fields(fields...)
+	}
+
+	if !a.noDescription {
+		metadata.Description = createdDescription
+	}
+
+	call := a.srv.Files.Create(metadata).Fields(fields...).SupportsAllDrives(true)
 
 	if mimeType != mimeTypeFolder {
 		call.Media(bytes.NewReader([]byte{}))
@@ -76,6 +166,7 @@ func (a *APIWrapper) createFile(
 	file, err := call.Do()
 
 	if err == nil {
+		a.created(folderID, fileName)
 		a.cache.CleanupByPrefix(fmt.Sprintf("%s-", folderID))
 	} else {
 		err = &DriveAPICallError{Err: err}
@@ -84,6 +175,68 @@ func (a *APIWrapper) createFile(
 	return file, err
 }
 
+// createFolder creates a folder, its mode is stored as a property unless perm is 0
+func (a *APIWrapper) createFolder(
+	folderID string,
+	folderName string,
+	perm os.FileMode,
+	fields ...googleapi.Field,
+) (*drive.File, error) {
+	if perm == 0 {
+		return a.createFile(folderID, folderName, mimeTypeFolder, fields...)
+	}
+
+	metadata := &drive.File{
+		Name:     sanitizeName(folderName),
+		MimeType: mimeTypeFolder,
+		Parents:  []string{folderID},
+		Properties: map[string]string{
+			fileModeProperty: fmt.Sprintf("%d", perm.Perm()),
+		},
+	}
+
+	if !a.noDescription {
+		metadata.Description = createdDescription
+	}
+
+	return a.createFileFrom(metadata, nil, fields...)
+}
+
+// createFileFrom wraps a call to Files.Create for a fully specified file, the content is uploaded from
+// media if it isn't nil
+func (a *APIWrapper) createFileFrom(file *drive.File, media io.Reader, fields ...googleapi.Field) (*drive.File, error) {
+	return a.createFileContext(context.Background(), file, media, nil, fields...)
+}
+
+// createFileContext behaves like createFileFrom, with a context for the call and options for the upload
+func (a *APIWrapper) createFileContext(
+	ctx context.Context,
+	file *drive.File,
+	media io.Reader,
+	options []googleapi.MediaOption,
+	fields ...googleapi.Field,
+) (*drive.File, error) {
+	a.calling("Files.Create")
+
+	call := a.srv.Files.Create(file).Fields(fields...).SupportsAllDrives(true).Context(ctx)
+
+	if media != nil {
+		call.Media(media, options...)
+	}
+
+	created, err := call.Do()
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	for _, p := range file.Parents {
+		a.created(p, file.Name)
+		a.cache.CleanupByPrefix(fmt.Sprintf("%s-", p))
+	}
+
+	return created, nil
+}
+
 // nolint: unused
 func (a *APIWrapper) renameFile(file *drive.File, targetFolder *drive.File, targetName string) error {
 	a.calling("Files.Update")
@@ -93,7 +246,7 @@ func (a *APIWrapper) renameFile(file *drive.File, targetFolder *drive.File, targ
 		&drive.File{
 			Name: sanitizeName(targetName),
 		},
-	)
+	).SupportsAllDrives(true)
 
 	if file.Parents[0] != targetFolder.Id {
 		call = call.
@@ -121,10 +274,10 @@ func (a *APIWrapper) deleteFile(file *drive.File, trash bool) error {
 
 	if trash {
 		a.calling("Files.Update")
-		_, err = a.srv.Files.Update(file.Id, &drive.File{Trashed: true}).Do()
+		_, err = a.srv.Files.Update(file.Id, &drive.File{Trashed: true}).SupportsAllDrives(true).Do()
 	} else {
 		a.calling("Files.Delete")
-		err = a.srv.Files.Delete(file.Id).Do()
+		err = a.srv.Files.Delete(file.Id).SupportsAllDrives(true).Do()
 	}
 
 	if err != nil {
@@ -142,32 +295,101 @@ func (a *APIWrapper) deleteFile(file *drive.File, trash bool) error {
 	return nil
 }
 
+// emptyTrash wraps a call to Files.EmptyTrash, for the shared drive if there is one
+func (a *APIWrapper) emptyTrash() error {
+	a.calling("Files.EmptyTrash")
+
+	call := a.srv.Files.EmptyTrash()
+	if a.sharedDriveID != "" {
+		call = call.DriveId(a.sharedDriveID)
+	}
+
+	if err := call.Do(); err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	a.cache.CleanupEverything()
+
+	return nil
+}
+
+// notFoundTTLDefault is how long the lookups that didn't find any file are cached by default
+const notFoundTTLDefault = 5 * time.Second
+
+// lookupMiss is the cached result of a lookup that didn't find any file. Unlike the other lookups, it expires
+// as the file can be created by someone else at any time.
+type lookupMiss struct {
+	fileList *drive.FileList // fileList is the empty result of the lookup
+	expires  time.Time       // expires is when the lookup has to be performed again
+}
+
+// lookupFieldsDefault are the fields fetched when no specific field was requested for a lookup
+const lookupFieldsDefault = "files(id,mimeType,parents,shortcutDetails)"
+
+func lookupCacheKey(folderID, fileName, queryFields string) string {
+	return fmt.Sprintf("%s-getFileByFolderAndName-%s-%s", folderID, fileName, queryFields)
+}
+
+// lookupQueryFields returns the fields of a lookup, as used in its cache key
+func lookupQueryFields(fields []googleapi.Field) string {
+	queryFields := googleapi.CombineFields(fields)
+	if queryFields == "" {
+		queryFields = lookupFieldsDefault
+	}
+
+	return queryFields
+}
+
 func (a *APIWrapper) getFileByFolderAndName(
 	folderID string,
 	fileName string,
 	fields ...googleapi.Field,
 ) (*drive.FileList, error) {
-	queryFields := googleapi.CombineFields(fields)
-	if queryFields == "" {
-		queryFields = "files(id,mimeType,parents)"
-	}
+	queryFields := lookupQueryFields(fields)
 
-	cacheKey := fmt.Sprintf("%s-getFileByFolderAndName-%s-%s", folderID, fileName, queryFields)
+	cacheKey := lookupCacheKey(folderID, fileName, queryFields)
 	value, ok := a.cache.Get(cacheKey)
 
 	if ok {
-		return value.(*drive.FileList), nil
+		miss, isMiss := value.(*lookupMiss)
+		if !isMiss {
+			return value.(*drive.FileList), nil
+		}
+
+		if time.Now().Before(miss.expires) {
+			return miss.fileList, nil
+		}
+	}
+
+	if fileList, ok := a.lookupInChildren(folderID, fileName, queryFields); ok {
+		return fileList, nil
 	}
 
 	fileList, err := a._getFileByFolderAndName(folderID, fileName, googleapi.Field(queryFields))
 
 	if err == nil && a.UseCache {
-		a.cache.Set(cacheKey, fileList)
+		switch {
+		case len(fileList.Files) > 0:
+			a.cache.Set(cacheKey, fileList)
+		case a.notFoundTTL > 0:
+			a.cache.Set(cacheKey, &lookupMiss{fileList: fileList, expires: time.Now().Add(a.notFoundTTL)})
+		default:
+			a.cache.Delete(cacheKey)
+		}
 	}
 
 	return fileList, err
 }
 
+// forgetFileByFolderAndName removes the cached result of a lookup, and the cached children it could be
+// served from
+func (a *APIWrapper) forgetFileByFolderAndName(folderID string, fileName string, fields ...googleapi.Field) {
+	queryFields := lookupQueryFields(fields)
+
+	a.cache.Delete(lookupCacheKey(folderID, fileName, queryFields))
+	a.cache.Delete(childrenCacheKey(folderID, queryFields))
+}
+
 func (a *APIWrapper) _getFileByFolderAndName(
 	folderID string,
 	fileName string,
@@ -176,7 +398,181 @@ func (a *APIWrapper) _getFileByFolderAndName(
 	a.calling("Files.List")
 
 	query := fmt.Sprintf("'%s' in parents and name='%s' and trashed = false", folderID, sanitizeName(fileName))
-	call := a.srv.Files.List().Q(query).Fields(fields)
+	call := a.filesList().Q(query).Fields(fields)
 
 	return call.Do()
 }
+
+// getTrashedFileByFolderAndName looks up the trashed files of a folder with a given name. Results aren't
+// cached as they are only used to explain why a file couldn't be found.
+func (a *APIWrapper) getTrashedFileByFolderAndName(folderID string, fileName string) (*drive.FileList, error) {
+	a.calling("Files.List")
+
+	query := fmt.Sprintf("'%s' in parents and name='%s' and trashed = true", folderID, sanitizeName(fileName))
+
+	return a.filesList().Q(query).Fields("files(id)").Do()
+}
+
+// listAllChildren lists all the non-trashed children of a folder, going through all the pages
+func (a *APIWrapper) listAllChildren(folderID string, fields ...googleapi.Field) ([]*drive.File, error) {
+	return a.listAll(fmt.Sprintf("'%s' in parents and trashed = false", folderID), fields...)
+}
+
+// listAll lists all the files matching a query, going through all the pages
+func (a *APIWrapper) listAll(query string, fields ...googleapi.Field) ([]*drive.File, error) {
+	var files []*drive.File
+
+	pageToken := ""
+
+	for {
+		a.calling("Files.List")
+
+		call := a.filesList().
+			Q(query).
+			Fields(append(fields, "nextPageToken")...).
+			PageSize(filesListPageSizeMax)
+
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		list, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, list.Files...)
+		pageToken = list.NextPageToken
+
+		if pageToken == "" {
+			return files, nil
+		}
+	}
+}
+
+// primeFolderCache stores the children of a folder in the cache as if each of them had been looked up
+// by name with each of the provided query fields.
+func (a *APIWrapper) primeFolderCache(folderID string, children []*drive.File, queryFields ...string) {
+	byName := make(map[string][]*drive.File)
+
+	for _, child := range children {
+		// Names that can't be expressed in a lookup query would never be looked up as-is
+		if sanitizeName(child.Name) != child.Name {
+			continue
+		}
+
+		byName[child.Name] = append(byName[child.Name], child)
+	}
+
+	for name, files := range byName {
+		for _, fields := range queryFields {
+			a.cache.Set(lookupCacheKey(folderID, name, fields), &drive.FileList{Files: files})
+		}
+	}
+}
+
+const (
+	// lookupCoalesceThreshold is the number of lookups in a folder within lookupCoalesceWindow after which the
+	// following lookups are served by listing all the children of the folder at once
+	lookupCoalesceThreshold = 3
+
+	// lookupCoalesceWindow is the duration during which the lookups in a folder are counted
+	lookupCoalesceWindow = time.Second
+)
+
+// folderLookups counts the lookups performed in a folder since a given time
+type folderLookups struct {
+	count int       // count is the number of lookups
+	since time.Time // since is when the first lookup was performed
+}
+
+func childrenCacheKey(folderID, queryFields string) string {
+	return fmt.Sprintf("%s-listChildren-%s", folderID, queryFields)
+}
+
+// childrenList is the cached list of all the children of a folder
+type childrenList struct {
+	files  []*drive.File // files are the children of the folder
+	listed time.Time     // listed is when the children were listed, the names they lack expire like lookupMiss
+}
+
+// setChildren stores all the children of a folder in the cache, so that the lookups in the folder with the
+// same query fields are served from them. Like the lookups, they are invalidated when the folder changes.
+func (a *APIWrapper) setChildren(folderID, queryFields string, children []*drive.File) {
+	if a.UseCache {
+		a.cache.Set(childrenCacheKey(folderID, queryFields), &childrenList{files: children, listed: time.Now()})
+	}
+}
+
+// lookupInChildren serves a lookup from the cached children of the folder, as stored by a complete listing.
+// When there are none, coalesceLookups is set and many lookups were recently performed in the folder, the
+// children are listed at once so that the next lookups don't need any call. A name missing from the children
+// is only served for notFoundTTL after the listing, like a lookupMiss. It returns false if the lookup has
+// to be performed.
+func (a *APIWrapper) lookupInChildren(folderID, fileName, queryFields string) (*drive.FileList, bool) {
+	// Names that can't be expressed in a lookup query don't match the children by name
+	if !a.UseCache || sanitizeName(fileName) != fileName {
+		return nil, false
+	}
+
+	value, ok := a.cache.Get(childrenCacheKey(folderID, queryFields))
+
+	if !ok {
+		if !a.coalesceLookups || !a.lookingUpMany(folderID) {
+			return nil, false
+		}
+
+		children, err := a.listAllChildren(folderID, googleapi.Field(queryFields))
+		if err != nil {
+			a.logger.Debug("Couldn't list the children of the folder, looking up the file alone",
+				"folderID", folderID,
+				"err", err,
+			)
+
+			return nil, false
+		}
+
+		a.setChildren(folderID, queryFields, children)
+		value = &childrenList{files: children, listed: time.Now()}
+	}
+
+	list := value.(*childrenList)
+	fileList := &drive.FileList{}
+
+	for _, child := range list.files {
+		if child.Name == fileName {
+			fileList.Files = append(fileList.Files, child)
+		}
+	}
+
+	if len(fileList.Files) == 0 && time.Since(list.listed) >= a.notFoundTTL {
+		return nil, false
+	}
+
+	return fileList, true
+}
+
+// lookingUpMany records a lookup in a folder and returns true if the folder had enough recent lookups to be
+// listed at once. The folders without recent lookups are forgotten.
+func (a *APIWrapper) lookingUpMany(folderID string) bool {
+	a.lookupsMu.Lock()
+	defer a.lookupsMu.Unlock()
+
+	now := time.Now()
+
+	lookups, ok := a.lookups[folderID]
+	if !ok || now.Sub(lookups.since) > lookupCoalesceWindow {
+		for id, l := range a.lookups {
+			if now.Sub(l.since) > lookupCoalesceWindow {
+				delete(a.lookups, id)
+			}
+		}
+
+		lookups = &folderLookups{since: now}
+		a.lookups[folderID] = lookups
+	}
+
+	lookups.count++
+
+	return lookups.count > lookupCoalesceThreshold
+}
@@ -0,0 +1,466 @@
+package gdrive // nolint: golint
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// batchMaxRequests is the maximum number of calls the API accepts in a single batch
+const batchMaxRequests = 100
+
+// batchRequest is a single API call sent within a batch
+type batchRequest struct {
+	method string      // method is the HTTP method of the call
+	path   string      // path is relative to the API base path, like "files/<id>"
+	query  url.Values  // query contains the URL parameters of the call
+	body   interface{} // body is sent JSON-encoded when not nil
+}
+
+// batchResponse is the result of a single API call of a batch
+type batchResponse struct {
+	body []byte // body is the raw response body
+	err  error  // err is the error returned by the API for this call
+}
+
+func (r *batchRequest) write(w io.Writer, basePath string) error {
+	uri := basePath + r.path
+	if len(r.query) > 0 {
+		uri += "?" + r.query.Encode()
+	}
+
+	var body []byte
+
+	if r.body != nil {
+		var err error
+		if body, err = json.Marshal(r.body); err != nil {
+			return fmt.Errorf("couldn't encode batch request: %w", err)
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", r.method, uri); err != nil {
+		return err
+	}
+
+	if body != nil {
+		if _, err := fmt.Fprintf(w, "Content-Type: application/json\r\nContent-Length: %d\r\n", len(body)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	_, err := w.Write(body)
+
+	return err
+}
+
+// batch performs the requests through the batch endpoint, splitting them in as many batches as needed.
+// The responses are in the same order as the requests.
+func (a *APIWrapper) batch(requests []*batchRequest) ([]*batchResponse, error) {
+	responses := make([]*batchResponse, len(requests))
+
+	for start := 0; start < len(requests); start += batchMaxRequests {
+		end := start + batchMaxRequests
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		if err := a.batchChunk(requests[start:end], responses[start:end]); err != nil {
+			return nil, err
+		}
+	}
+
+	return responses, nil
+}
+
+func (a *APIWrapper) batchChunk(requests []*batchRequest, responses []*batchResponse) error {
+	a.calling("Batch")
+
+	base, err := url.Parse(a.srv.BasePath)
+	if err != nil {
+		return fmt.Errorf("couldn't parse API base path: %w", err)
+	}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+
+	for i, req := range requests {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<item-%d>", i))
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		if err := req.write(part, base.Path); err != nil {
+			return err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	batchURL := fmt.Sprintf("%s://%s/batch%s", base.Scheme, base.Host, strings.TrimSuffix(base.Path, "/"))
+
+	httpReq, err := http.NewRequest(http.MethodPost, batchURL, body)
+	if err != nil {
+		return err
+	}
+
+	httpReq.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+	resp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+
+	if err := readBatchResponses(resp, responses); err != nil {
+		return err
+	}
+
+	for i := range responses {
+		if responses[i] == nil {
+			responses[i] = &batchResponse{err: ErrBatchResponseMissing}
+		}
+	}
+
+	return nil
+}
+
+func readBatchResponses(resp *http.Response, responses []*batchResponse) error {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("couldn't parse batch response type: %w", err)
+	}
+
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("couldn't read batch response: %w", err)
+		}
+
+		contentID := strings.Trim(part.Header.Get("Content-ID"), "<>")
+
+		index, err := strconv.Atoi(strings.TrimPrefix(contentID, "response-item-"))
+		if err != nil || index < 0 || index >= len(responses) {
+			continue
+		}
+
+		partResp, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return fmt.Errorf("couldn't read batch response: %w", err)
+		}
+
+		data, err := io.ReadAll(partResp.Body)
+		_ = partResp.Body.Close()
+
+		if err != nil {
+			return fmt.Errorf("couldn't read batch response: %w", err)
+		}
+
+		partResp.Body = io.NopCloser(bytes.NewReader(data))
+
+		responses[index] = &batchResponse{
+			body: data,
+			err:  googleapi.CheckResponse(partResp),
+		}
+	}
+}
+
+// getFiles fetches the metadata of many files through the batch endpoint. Files that can't be fetched are
+// nil, with the corresponding error set.
+func (a *APIWrapper) getFiles(ids []string, fields ...googleapi.Field) ([]*drive.File, []error, error) {
+	query := url.Values{}
+	query.Set("fields", googleapi.CombineFields(fields))
+	query.Set("supportsAllDrives", "true")
+
+	requests := make([]*batchRequest, len(ids))
+	for i, id := range ids {
+		requests[i] = &batchRequest{
+			method: http.MethodGet,
+			path:   "files/" + url.PathEscape(id),
+			query:  query,
+		}
+	}
+
+	responses, err := a.batch(requests)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make([]*drive.File, len(ids))
+	errs := make([]error, len(ids))
+
+	for i, resp := range responses {
+		if resp.err != nil {
+			errs[i] = &DriveAPICallError{Err: resp.err}
+
+			continue
+		}
+
+		var file drive.File
+		if err := json.Unmarshal(resp.body, &file); err != nil {
+			errs[i] = fmt.Errorf("couldn't decode batch response: %w", err)
+
+			continue
+		}
+
+		files[i] = &file
+	}
+
+	return files, errs, nil
+}
+
+// updateFiles applies the same patch to many files through the batch endpoint
+func (a *APIWrapper) updateFiles(ids []string, patch *drive.File, fields ...googleapi.Field) ([]error, error) {
+	query := url.Values{}
+	query.Set("fields", googleapi.CombineFields(append([]googleapi.Field{"id", "parents", "mimeType"}, fields...)))
+	query.Set("supportsAllDrives", "true")
+
+	requests := make([]*batchRequest, len(ids))
+	for i, id := range ids {
+		requests[i] = &batchRequest{
+			method: http.MethodPatch,
+			path:   "files/" + url.PathEscape(id),
+			query:  query,
+			body:   patch,
+		}
+	}
+
+	responses, err := a.batch(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(ids))
+
+	for i, resp := range responses {
+		if resp.err != nil {
+			errs[i] = &DriveAPICallError{Err: resp.err}
+
+			continue
+		}
+
+		var file drive.File
+		if err := json.Unmarshal(resp.body, &file); err != nil {
+			errs[i] = fmt.Errorf("couldn't decode batch response: %w", err)
+
+			continue
+		}
+
+		a.invalidateFile(&file)
+	}
+
+	return errs, nil
+}
+
+// moveFiles moves many files into a folder through the batch endpoint. Files are removed from all their
+// other parents, so that files having multiple parents end up only in the target folder.
+func (a *APIWrapper) moveFiles(files []*drive.File, folderID string) ([]error, error) {
+	requests := make([]*batchRequest, len(files))
+
+	for i, file := range files {
+		var removedParents []string
+
+		for _, p := range file.Parents {
+			if p != folderID {
+				removedParents = append(removedParents, p)
+			}
+		}
+
+		query := url.Values{}
+		query.Set("fields", "id,parents,mimeType")
+		query.Set("supportsAllDrives", "true")
+		query.Set("addParents", folderID)
+
+		if len(removedParents) > 0 {
+			query.Set("removeParents", strings.Join(removedParents, ","))
+		}
+
+		requests[i] = &batchRequest{
+			method: http.MethodPatch,
+			path:   "files/" + url.PathEscape(file.Id),
+			query:  query,
+			body:   &drive.File{},
+		}
+	}
+
+	responses, err := a.batch(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(files))
+
+	for i, resp := range responses {
+		if resp.err != nil {
+			errs[i] = &DriveAPICallError{Err: resp.err}
+
+			continue
+		}
+
+		// The previous parents are invalidated as well as the new ones
+		a.invalidateFile(files[i])
+
+		var file drive.File
+		if err := json.Unmarshal(resp.body, &file); err != nil {
+			errs[i] = fmt.Errorf("couldn't decode batch response: %w", err)
+
+			continue
+		}
+
+		a.invalidateFile(&file)
+	}
+
+	return errs, nil
+}
+
+// invalidateFile removes the cache entries that might reference a file that was just modified.
+// When a folder is modified, the entire cache is trashed.
+func (a *APIWrapper) invalidateFile(file *drive.File) {
+	if file.MimeType == mimeTypeFolder {
+		a.cache.CleanupEverything()
+
+		return
+	}
+
+	for _, p := range file.Parents {
+		a.cache.CleanupByPrefix(fmt.Sprintf("%s-", p))
+	}
+}
+
+// BatchUpdate applies the same metadata patch to many files, grouping the calls into batches. The
+// returned map contains an entry for each file ID, with a nil error if the update succeeded. The fields
+// are the ones requested in each file's update response.
+func (d *GDriver) BatchUpdate(ids []string, patch *drive.File, fields ...googleapi.Field) (map[string]error, error) {
+	errs, err := d.srvWrapper.updateFiles(ids, patch, fields...)
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	results := make(map[string]error, len(ids))
+	for i, id := range ids {
+		results[id] = errs[i]
+	}
+
+	return results, nil
+}
+
+// MoveMany moves many files into a directory, which is created if it doesn't exist. The directory is only
+// resolved once and the moves are grouped into batches, which is much faster than renaming each file. The
+// returned map contains an entry for each path, with a nil error if the file was moved.
+func (d *GDriver) MoveMany(paths []string, destDir string) (map[string]error, error) {
+	destParts, err := splitPath(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := d.makeDirectoryByParts(destParts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dest.IsDir() {
+		return nil, &FileIsNotDirectoryError{Fi: dest}
+	}
+
+	results := make(map[string]error, len(paths))
+	files := make([]*drive.File, 0, len(paths))
+	movedPaths := make([]string, 0, len(paths))
+
+	for _, p := range paths {
+		fi, err := d.getFile(p, "files(id,parents,mimeType)")
+		if err != nil {
+			results[p] = err
+
+			continue
+		}
+
+		if d.isRoot(fi) {
+			results[p] = ErrForbiddenOnRoot
+
+			continue
+		}
+
+		files = append(files, fi.file)
+		movedPaths = append(movedPaths, p)
+	}
+
+	errs, err := d.srvWrapper.moveFiles(files, dest.folderID())
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	for i, p := range movedPaths {
+		results[p] = errs[i]
+	}
+
+	return results, nil
+}
+
+// TrashChildren moves all the content of a directory to the trash, while keeping the directory itself along with
+// its sharing settings. The calls are grouped into batches. The returned map contains an entry for each direct
+// child, by file ID as siblings can share the same name, with a nil error if it was trashed.
+func (d *GDriver) TrashChildren(dirPath string) (map[string]error, error) {
+	dir, err := d.getFile(dirPath, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dir.IsDir() {
+		return nil, FileIsNotDirectoryError{Fi: dir}
+	}
+
+	children, err := d.srvWrapper.listAllChildren(dir.folderID(), "files(id)")
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	ids := make([]string, len(children))
+	for i, child := range children {
+		ids[i] = child.Id
+	}
+
+	errs, err := d.srvWrapper.updateFiles(ids, &drive.File{Trashed: true})
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	results := make(map[string]error, len(ids))
+	for i, id := range ids {
+		results[id] = errs[i]
+	}
+
+	return results, nil
+}
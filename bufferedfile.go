@@ -0,0 +1,238 @@
+package gdrive // nolint: golint
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// bufferedReadMaxSizeDefault is the default maximum size of a file opened with OpenBuffered
+const bufferedReadMaxSizeDefault = 32 * 1024 * 1024
+
+// BufferedFile is a read-only file whose whole content was downloaded in memory, it allows cheap
+// random access.
+type BufferedFile struct {
+	*bytes.Reader           // Reader provides access to the content
+	fileInfo      *FileInfo // fileInfo is the info of the file at the time it was downloaded
+	path          string    // path is the path used to open the file
+	closed        bool      // closed is set once the file has been closed
+}
+
+// OpenBuffered opens a file for reading by downloading its whole content in memory. This is much faster
+// than ranged reads for small files accessed randomly. The file can't be bigger than BufferedReadMaxSize,
+// unless BufferedSpillFs is set, in which case the files bigger than BufferedSpillThreshold are downloaded to
+// a temporary file of BufferedSpillFs instead, which is removed when the file is closed.
+func (d *GDriver) OpenBuffered(path string) (afero.File, error) {
+	path, err := normalizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := d.getFile(path, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, FileIsDirectoryError{Path: fi.Path()}
+	}
+
+	if d.BufferedSpillFs != nil && fi.Size() > d.BufferedSpillThreshold {
+		return d.openSpilled(fi, path)
+	}
+
+	content, err := d.downloadContent(context.Background(), fi, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BufferedFile{
+		Reader:   bytes.NewReader(content),
+		fileInfo: fi,
+		path:     path,
+	}, nil
+}
+
+// downloadContent downloads the whole content of a file in memory, it can't be bigger than BufferedReadMaxSize
+func (d *GDriver) downloadContent(ctx context.Context, fi *FileInfo, path string) ([]byte, error) {
+	if fi.Size() > d.BufferedReadMaxSize {
+		return nil, &FileTooLargeError{Path: path, MaxSize: d.BufferedReadMaxSize}
+	}
+
+	reader, err := d.getFileReaderContext(ctx, fi, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	// The size is checked again as the file might have been modified in the meantime
+	content, err := io.ReadAll(io.LimitReader(reader, d.BufferedReadMaxSize+1))
+	if err != nil {
+		return nil, &DriveStreamError{Err: err}
+	}
+
+	if int64(len(content)) > d.BufferedReadMaxSize {
+		return nil, &FileTooLargeError{Path: path, MaxSize: d.BufferedReadMaxSize}
+	}
+
+	return content, nil
+}
+
+// Close releases the file content. Closing the file again has no effect.
+func (f *BufferedFile) Close() error {
+	if f.closed {
+		return nil
+	}
+
+	f.closed = true
+	f.Reader = bytes.NewReader(nil)
+
+	return nil
+}
+
+// Name returns the path used to open the file
+func (f *BufferedFile) Name() string {
+	return f.path
+}
+
+// Readdir isn't possible on a file
+func (f *BufferedFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, FileIsNotDirectoryError{Fi: f.fileInfo}
+}
+
+// Readdirnames isn't possible on a file
+func (f *BufferedFile) Readdirnames(int) ([]string, error) {
+	return nil, FileIsNotDirectoryError{Fi: f.fileInfo}
+}
+
+// Stat provides the file information
+func (f *BufferedFile) Stat() (os.FileInfo, error) {
+	return f.fileInfo, nil
+}
+
+// Sync has no effect here
+func (f *BufferedFile) Sync() error {
+	return nil
+}
+
+// Truncate isn't possible on a read-only file
+func (f *BufferedFile) Truncate(int64) error {
+	return ErrReadOnly
+}
+
+// Write isn't possible on a read-only file
+func (f *BufferedFile) Write([]byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// WriteAt isn't possible on a read-only file
+func (f *BufferedFile) WriteAt([]byte, int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// WriteString isn't possible on a read-only file
+func (f *BufferedFile) WriteString(string) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// spilledFile is a read-only file whose whole content was downloaded to a temporary file
+type spilledFile struct {
+	afero.File           // File is the temporary file
+	fs         afero.Fs  // fs is the filesystem of the temporary file
+	fileInfo   *FileInfo // fileInfo is the info of the file at the time it was downloaded
+	path       string    // path is the path used to open the file
+	closed     bool      // closed is set once the file has been closed
+	closeErr   error     // closeErr is the result of the first Close
+}
+
+// openSpilled downloads a file to a temporary file of BufferedSpillFs
+func (d *GDriver) openSpilled(fi *FileInfo, path string) (afero.File, error) {
+	reader, err := d.getFileReader(fi, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	tmp, err := afero.TempFile(d.BufferedSpillFs, "", "gdrive-")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &spilledFile{File: tmp, fs: d.BufferedSpillFs, fileInfo: fi, path: path}
+
+	if _, err = io.Copy(tmp, reader); err != nil {
+		_ = f.Close()
+
+		return nil, &DriveStreamError{Err: err}
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		_ = f.Close()
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Close closes and removes the temporary file. Closing the file again has no effect and returns the result of
+// the first call.
+func (f *spilledFile) Close() error {
+	if f.closed {
+		return f.closeErr
+	}
+
+	f.closed = true
+	f.closeErr = f.File.Close()
+
+	if errRemove := f.fs.Remove(f.File.Name()); f.closeErr == nil {
+		f.closeErr = errRemove
+	}
+
+	return f.closeErr
+}
+
+// Name returns the path used to open the file
+func (f *spilledFile) Name() string {
+	return f.path
+}
+
+// Readdir isn't possible on a file
+func (f *spilledFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, FileIsNotDirectoryError{Fi: f.fileInfo}
+}
+
+// Readdirnames isn't possible on a file
+func (f *spilledFile) Readdirnames(int) ([]string, error) {
+	return nil, FileIsNotDirectoryError{Fi: f.fileInfo}
+}
+
+// Stat provides the file information
+func (f *spilledFile) Stat() (os.FileInfo, error) {
+	return f.fileInfo, nil
+}
+
+// Truncate isn't possible on a read-only file
+func (f *spilledFile) Truncate(int64) error {
+	return ErrReadOnly
+}
+
+// Write isn't possible on a read-only file
+func (f *spilledFile) Write([]byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// WriteAt isn't possible on a read-only file
+func (f *spilledFile) WriteAt([]byte, int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// WriteString isn't possible on a read-only file
+func (f *spilledFile) WriteString(string) (int, error) {
+	return 0, ErrReadOnly
+}
@@ -0,0 +1,69 @@
+package gdrive // nolint: golint
+
+import (
+	"path"
+
+	"github.com/spf13/afero"
+)
+
+// StatByID returns the FileInfo of a file or directory from its ID, without resolving its path segment by
+// segment, so it also works when several files have the same name. Its path is computed from its parents,
+// which must lead to the root directory, a PathOutsideRootError is returned otherwise. A FileTrashedError
+// is returned if the file is in the trash.
+func (d *GDriver) StatByID(id string) (*FileInfo, error) {
+	file, err := d.srv.Files.Get(id).
+		Fields(d.fileFieldList("parents", "trashed")...).
+		SupportsAllDrives(true).
+		Do()
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, &FileNotExistError{Path: id}
+		}
+
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	if d.isRoot(&FileInfo{file: file}) {
+		return d.rootNode, nil
+	}
+
+	inRoot, parentPath, err := isInRoot(d.srv, d.rootNode.file.Id, file, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if !inRoot {
+		return nil, &PathOutsideRootError{Path: file.Name}
+	}
+
+	if file.Trashed {
+		return nil, &FileTrashedError{Path: path.Join(parentPath, file.Name), ID: file.Id}
+	}
+
+	return d.newFileInfo(file, parentPath), nil
+}
+
+// OpenByID opens a file or directory for reading from its ID, see StatByID
+func (d *GDriver) OpenByID(id string) (afero.File, error) {
+	fi, err := d.StatByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return &File{
+			driver:   d,
+			Path:     fi.Path(),
+			FileInfo: fi,
+		}, nil
+	}
+
+	f, err := d.openFileRead(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	f.(*File).Path = fi.Path()
+
+	return f, nil
+}
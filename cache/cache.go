@@ -2,18 +2,33 @@
 package cache
 
 import (
+	"container/list"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type item struct {
-	value interface{}
+	value   interface{}
+	element *list.Element // element is the position of the key in the recency order of a bounded cache
+}
+
+// Stats contains the counters of a cache since its creation
+type Stats struct {
+	Hits      int64 // Hits is the number of reads that found a value
+	Misses    int64 // Misses is the number of reads that didn't find any value
+	Evictions int64 // Evictions is the number of values removed to make room for new ones
 }
 
 // Cache management
 type Cache struct {
-	mutex sync.RWMutex
-	items map[string]*item
+	hits       int64 // hits counts the reads that found a value, it is updated atomically
+	misses     int64 // misses counts the reads that didn't find any value, it is updated atomically
+	evictions  int64 // evictions counts the values evicted from a full cache, it is updated with the mutex held
+	mutex      sync.RWMutex
+	items      map[string]*item
+	maxEntries int        // maxEntries is the maximum number of items, 0 if the cache is unbounded
+	order      *list.List // order contains the keys of a bounded cache, the most recently used first
 }
 
 // NewCache creates a new cache instance
@@ -23,22 +38,77 @@ func NewCache() *Cache {
 	}
 }
 
+// NewCacheWithMaxEntries creates a new cache instance holding at most maxEntries values. Once it is full,
+// setting a new value evicts the least recently set or read one. A maxEntries of 0 or less doesn't bound
+// the cache.
+func NewCacheWithMaxEntries(maxEntries int) *Cache {
+	if maxEntries <= 0 {
+		return NewCache()
+	}
+
+	return &Cache{
+		items:      make(map[string]*item),
+		maxEntries: maxEntries,
+		order:      list.New(),
+	}
+}
+
 // Set sets a value in the cache
 func (c *Cache) Set(key string, value interface{}) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.items[key] = &item{value: value}
+
+	if c.order == nil {
+		c.items[key] = &item{value: value}
+
+		return
+	}
+
+	if existing, found := c.items[key]; found {
+		existing.value = value
+		c.order.MoveToFront(existing.element)
+
+		return
+	}
+
+	c.items[key] = &item{value: value, element: c.order.PushFront(key)}
+
+	for len(c.items) > c.maxEntries {
+		c.remove(c.order.Back().Value.(string))
+		c.evictions++
+	}
 }
 
 // Get gets a value from the cache
 func (c *Cache) Get(key string) (interface{}, bool) {
+	if c.order != nil {
+		// Reading a value of a bounded cache changes the recency order
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if item, found := c.items[key]; found {
+			c.order.MoveToFront(item.element)
+			atomic.AddInt64(&c.hits, 1)
+
+			return item.value, found
+		}
+
+		atomic.AddInt64(&c.misses, 1)
+
+		return nil, false
+	}
+
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
 	if item, found := c.items[key]; found {
+		atomic.AddInt64(&c.hits, 1)
+
 		return item.value, found
 	}
 
+	atomic.AddInt64(&c.misses, 1)
+
 	return nil, false
 }
 
@@ -54,7 +124,7 @@ func (c *Cache) Delete(key string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	delete(c.items, key)
+	c.remove(key)
 }
 
 // CleanupByPrefix deletes all cache values with a given key prefix
@@ -66,7 +136,7 @@ func (c *Cache) CleanupByPrefix(prefix string) int {
 
 	for k := range c.items {
 		if strings.HasPrefix(k, prefix) {
-			delete(c.items, k)
+			c.remove(k)
 			count++
 		}
 	}
@@ -79,4 +149,43 @@ func (c *Cache) CleanupEverything() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	c.items = make(map[string]*item)
+
+	if c.order != nil {
+		c.order.Init()
+	}
+}
+
+// Len returns the number of values in the cache
+func (c *Cache) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return len(c.items)
+}
+
+// Stats returns the counters of the cache
+func (c *Cache) Stats() Stats {
+	c.mutex.RLock()
+	evictions := c.evictions
+	c.mutex.RUnlock()
+
+	return Stats{
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		Evictions: evictions,
+	}
+}
+
+// remove deletes a value, the mutex must be held
+func (c *Cache) remove(key string) {
+	item, found := c.items[key]
+	if !found {
+		return
+	}
+
+	if item.element != nil {
+		c.order.Remove(item.element)
+	}
+
+	delete(c.items, key)
 }
@@ -47,6 +47,29 @@ func TestPrefixCleanup(t *testing.T) {
 	assert.Equal("value3", c.GetValue("pre2-key1"))
 }
 
+func TestStats(t *testing.T) {
+	c := NewCacheWithMaxEntries(2)
+	assert := ast.New(t)
+
+	c.Set("key1", "value1")
+	c.Set("key2", "value2")
+
+	c.GetValue("key1")
+	c.GetValue("key1")
+	c.GetValue("key3")
+
+	// key2 is evicted, then missed
+	c.Set("key3", "value3")
+	c.GetValue("key2")
+
+	// Deleted values aren't evictions
+	c.Delete("key3")
+	c.CleanupEverything()
+
+	assert.Equal(Stats{Hits: 2, Misses: 2, Evictions: 1}, c.Stats())
+	assert.Equal(Stats{}, NewCache().Stats())
+}
+
 func BenchmarkGet(b *testing.B) {
 	c := NewCache()
 	nbKeys := 100
@@ -83,3 +106,88 @@ func BenchmarkSet(b *testing.B) {
 		}
 	})
 }
+
+func TestMaxEntries(t *testing.T) {
+	const maxEntries = 20
+
+	c := NewCacheWithMaxEntries(maxEntries)
+	assert := ast.New(t)
+
+	for i := 0; i < maxEntries+10; i++ {
+		c.Set(fmt.Sprintf("key%d", i), i)
+	}
+
+	assert.Equal(maxEntries, c.Len())
+
+	for i := 0; i < 10; i++ {
+		_, ok := c.Get(fmt.Sprintf("key%d", i))
+		assert.False(ok, i)
+	}
+
+	for i := 10; i < maxEntries+10; i++ {
+		assert.Equal(i, c.GetValue(fmt.Sprintf("key%d", i)))
+	}
+}
+
+func TestMaxEntriesRecency(t *testing.T) {
+	c := NewCacheWithMaxEntries(3)
+	assert := ast.New(t)
+
+	c.Set("key1", "value1")
+	c.Set("key2", "value2")
+	c.Set("key3", "value3")
+
+	// Reading key1 and updating key2 make key3 the least recently used
+	assert.Equal("value1", c.GetValue("key1"))
+	c.Set("key2", "value2b")
+	c.Set("key4", "value4")
+
+	assert.Nil(c.GetValue("key3"))
+	assert.Equal("value1", c.GetValue("key1"))
+	assert.Equal("value2b", c.GetValue("key2"))
+	assert.Equal("value4", c.GetValue("key4"))
+
+	// Deleted values free their slot
+	c.Delete("key1")
+	assert.Equal(1, c.CleanupByPrefix("key2"))
+	c.Set("key5", "value5")
+	c.Set("key6", "value6")
+	assert.Equal(3, c.Len())
+	assert.Equal("value4", c.GetValue("key4"))
+
+	c.CleanupEverything()
+	assert.Equal(0, c.Len())
+
+	c.Set("key7", "value7")
+	assert.Equal("value7", c.GetValue("key7"))
+}
+
+func BenchmarkGetMaxEntries(b *testing.B) {
+	nbKeys := 100
+	c := NewCacheWithMaxEntries(nbKeys)
+
+	for i := 0; i < nbKeys; i++ {
+		c.Set(fmt.Sprintf("key %d", i), fmt.Sprintf("value %d", i))
+	}
+
+	keyName := fmt.Sprintf("key %d", nbKeys/2)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.GetValue(keyName)
+		}
+	})
+}
+
+func BenchmarkSetMaxEntries(b *testing.B) {
+	nbKeys := 100
+	c := NewCacheWithMaxEntries(nbKeys)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// Half of the keys don't fit in the cache, so that values keep being evicted
+		c.Set(fmt.Sprintf("key %d", i%(nbKeys*2)), i)
+	}
+}
@@ -0,0 +1,129 @@
+package gdrive // nolint: golint
+
+import (
+	"errors"
+	"os"
+	"path"
+
+	"github.com/spf13/afero"
+)
+
+// childName checks that a name designates a direct child of a directory
+func (f *File) childName(name string) (string, error) {
+	if !f.IsDir() {
+		return "", FileIsNotDirectoryError{Fi: f.FileInfo, Path: f.Path}
+	}
+
+	parts, err := splitPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(parts) != 1 {
+		return "", ErrInvalidChildName
+	}
+
+	return parts[0], nil
+}
+
+// getChild looks up a direct child of the directory, without resolving the path of the directory again
+func (f *File) getChild(name string) (*FileInfo, error) {
+	name, err := f.childName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := f.driver.srvWrapper.getFileByFolderAndName(f.folderID(), name, listFields...)
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	childPath := path.Join(f.Path, name)
+
+	if files == nil || len(files.Files) == 0 {
+		return nil, &FileNotExistError{Path: childPath}
+	}
+
+	if len(files.Files) > 1 {
+		return nil, &FileHasMultipleEntriesError{Path: childPath}
+	}
+
+	return f.driver.newFileInfo(files.Files[0], f.Path), nil
+}
+
+// StatChild gives the FileInfo of a direct child of the directory
+func (f *File) StatChild(name string) (os.FileInfo, error) {
+	return f.getChild(name)
+}
+
+// Mkdir creates a directory as a direct child of the directory. Like GDriver.Mkdir, it succeeds if the
+// directory already exists.
+func (f *File) Mkdir(name string) error {
+	child, err := f.getChild(name)
+	if err == nil {
+		if !child.IsDir() {
+			return FileIsNotDirectoryError{Fi: child, Path: child.Path()}
+		}
+
+		return nil
+	}
+
+	if !IsNotExist(err) {
+		return err
+	}
+
+	name, _ = f.childName(name)
+	_, err = f.driver.srvWrapper.createFile(f.folderID(), name, mimeTypeFolder, fileInfoFields...)
+
+	return err
+}
+
+// OpenChild opens a direct child of the directory, with the same flags as GDriver.OpenFile. The path of
+// the directory isn't resolved again.
+func (f *File) OpenChild(name string, flag int) (afero.File, error) {
+	if flag&os.O_RDWR != 0 {
+		return nil, ErrReadAndWriteNotSupported
+	}
+
+	d := f.driver
+
+	child, err := f.getChild(name)
+
+	var notExist *FileNotExistError
+
+	switch {
+	case err == nil:
+		if child.IsDir() {
+			return &File{
+				driver:   d,
+				Path:     child.Path(),
+				FileInfo: child,
+			}, nil
+		}
+	case errors.As(err, &notExist) && flag&os.O_CREATE != 0 && flag&os.O_WRONLY != 0:
+		name, _ = f.childName(name)
+
+		file, errCreate := d.srvWrapper.createFile(f.folderID(), name, mimeTypeFile, fileInfoFields...)
+		if errCreate != nil {
+			return nil, errCreate
+		}
+
+		child = &FileInfo{file: file, parentPath: f.Path}
+
+		if err := d.applyDefaultPermissions(child); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if flag&os.O_WRONLY != 0 {
+		if err := d.checkWritable(child, child.Path()); err != nil {
+			return nil, err
+		}
+
+		return d.openFileWrite(child, child.Path())
+	}
+
+	return d.openFileRead(child)
+}
@@ -0,0 +1,86 @@
+package gdrive // nolint: golint
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// contextTransport aborts the calls when a context is done, in addition to their own context
+type contextTransport struct {
+	base http.RoundTripper // base performs the calls
+	ctx  context.Context   // ctx is the context the calls are bound to
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// AfterFunc runs asynchronously, a context that is already done has to be checked beforehand
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := context.AfterFunc(t.ctx, cancel)
+
+	release := func() {
+		stop()
+		cancel()
+	}
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+
+		// The call was aborted by the merged context, whose error doesn't tell if ctx timed out
+		if ctxErr := t.ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+
+		return nil, err
+	}
+
+	// The body is streamed after the call returns, so the context is only released once it is closed
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+
+	return resp, nil
+}
+
+// releaseOnClose calls a release function when the body is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	defer b.release()
+
+	return b.ReadCloser.Close()
+}
+
+// WithContext returns a driver performing all its calls to the API with ctx: once it is done, the calls in
+// progress are aborted and the following ones fail with the error of the context. This applies to the streams
+// of the files opened with it too. The returned driver shares the cache and the pending writes of d, it gets
+// a copy of its configuration and root directory.
+func (d *GDriver) WithContext(ctx context.Context) (*GDriver, error) {
+	client := *d.srvWrapper.httpClient
+	client.Transport = &contextTransport{base: client.Transport, ctx: ctx}
+
+	srv, err := drive.NewService(ctx, option.WithHTTPClient(&client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Drive client: %w", err)
+	}
+
+	wrapper := *d.srvWrapper
+	wrapper.srv = srv
+	wrapper.httpClient = &client
+
+	scoped := *d
+	scoped.srv = srv
+	scoped.srvWrapper = &wrapper
+	scoped.ctx = ctx
+
+	return &scoped, nil
+}
@@ -0,0 +1,66 @@
+package gdrive // nolint: golint
+
+import (
+	"io"
+	"os"
+)
+
+// DirHandle allows to list a directory page by page. The position of the listing can be exported as
+// a cursor and resumed later, even from another driver instance.
+type DirHandle struct {
+	dir  *File // dir is the directory being listed
+	done bool  // done is set once the last page has been returned
+}
+
+// OpenDir opens a directory for a paginated listing
+func (d *GDriver) OpenDir(path string) (*DirHandle, error) {
+	return d.ResumeDir(path, "")
+}
+
+// ResumeDir opens a directory listing at the position identified by a cursor previously returned by
+// DirHandle.Cursor. An empty cursor starts the listing from the beginning.
+func (d *GDriver) ResumeDir(path, cursor string) (*DirHandle, error) {
+	path, err := normalizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := d.getFile(path, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, FileIsNotDirectoryError{Fi: fi}
+	}
+
+	return &DirHandle{
+		dir: &File{
+			driver:       d,
+			Path:         path,
+			FileInfo:     fi,
+			dirListToken: cursor,
+		},
+	}, nil
+}
+
+// NextPage returns up to n entries of the directory, all the remaining ones if n <= 0.
+// io.EOF is returned once the listing is complete.
+func (h *DirHandle) NextPage(n int) ([]os.FileInfo, error) {
+	if h.done {
+		return nil, io.EOF
+	}
+
+	files, err := h.dir.driver.listDirectory(h.dir, n)
+	if err == nil && h.dir.dirListToken == "" {
+		h.done = true
+	}
+
+	return files, err
+}
+
+// Cursor returns an opaque value identifying the current position of the listing, to be passed to
+// GDriver.ResumeDir. After a NextPage call, an empty cursor means the listing is complete.
+func (h *DirHandle) Cursor() string {
+	return h.dir.dirListToken
+}
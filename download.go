@@ -0,0 +1,46 @@
+package gdrive // nolint: golint
+
+import (
+	"io"
+
+	"github.com/spf13/afero"
+)
+
+// DownloadToFile copies the content of a Drive file to dstPath on the dst filesystem and returns the number
+// of bytes written. dstPath is created or truncated. The modification time of the Drive file is applied to
+// the copy when the destination filesystem supports it.
+func (d *GDriver) DownloadToFile(drivePath string, dst afero.Fs, dstPath string) (int64, error) {
+	fi, err := d.getFile(drivePath, listFields...)
+	if err != nil {
+		return 0, err
+	}
+
+	reader, err := d.getFileReader(fi, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	out, err := dst.Create(dstPath)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(out, reader)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+
+	if err != nil {
+		return written, err
+	}
+
+	if modTime := fi.ModTime(); !modTime.IsZero() {
+		if err := dst.Chtimes(dstPath, modTime, modTime); err != nil {
+			d.Logger.Debug("Couldn't set the modification time of the copy", "path", dstPath, "err", err)
+		}
+	}
+
+	return written, nil
+}
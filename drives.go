@@ -0,0 +1,54 @@
+package gdrive // nolint: golint
+
+import (
+	"google.golang.org/api/drive/v3"
+)
+
+// drivesListPageSizeMax is the maximum number of shared drives returned by a single Drives.List call
+const drivesListPageSizeMax = 100
+
+// SharedDriveInfo describes a shared drive the account can access
+type SharedDriveInfo struct {
+	ID           string                   // ID is the ID to pass to the SharedDrive option
+	Name         string                   // Name is the name of the shared drive
+	Capabilities *drive.DriveCapabilities // Capabilities are what the account can do on the shared drive
+}
+
+// ListSharedDrives lists all the shared drives the account can access. It doesn't depend on the drive the
+// driver works on, and can be used to pick the one to pass to the SharedDrive option.
+func (d *GDriver) ListSharedDrives() ([]SharedDriveInfo, error) {
+	var drives []SharedDriveInfo
+
+	pageToken := ""
+
+	for {
+		d.srvWrapper.calling("Drives.List")
+
+		call := d.srv.Drives.List().
+			Fields("nextPageToken", "drives(id,name,capabilities)").
+			PageSize(drivesListPageSizeMax)
+
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		list, err := call.Do()
+		if err != nil {
+			return nil, &DriveAPICallError{Err: err}
+		}
+
+		for _, sd := range list.Drives {
+			drives = append(drives, SharedDriveInfo{
+				ID:           sd.Id,
+				Name:         sd.Name,
+				Capabilities: sd.Capabilities,
+			})
+		}
+
+		pageToken = list.NextPageToken
+
+		if pageToken == "" {
+			return drives, nil
+		}
+	}
+}
@@ -3,6 +3,11 @@ package gdrive // nolint: golint
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"google.golang.org/api/googleapi"
 )
 
 // ErrNotImplemented is returned when this operation is not (yet) implemented
@@ -32,6 +37,55 @@ var ErrEmptyPath = errors.New("path cannot be empty")
 // ErrForbiddenOnRoot is returned when an operation is performed on the root node
 var ErrForbiddenOnRoot = errors.New("forbidden for root directory")
 
+// ErrBatchResponseMissing is returned when a batch response didn't contain the result of a call
+var ErrBatchResponseMissing = errors.New("missing response in batch")
+
+// ErrVirusScanWarning is returned when a download link returned the virus scan warning page and the
+// file ID couldn't be extracted from the link to download it through the API
+var ErrVirusScanWarning = errors.New("download link returned the virus scan warning page")
+
+// ErrCloseTimeout is returned when the upload didn't complete within the close timeout
+var ErrCloseTimeout = errors.New("timeout while waiting for the upload to complete")
+
+// ErrIndexableTextTooLarge is returned when the indexable text is larger than what Google Drive accepts
+var ErrIndexableTextTooLarge = errors.New("indexable text is larger than 128KB")
+
+// ErrThumbnailTooLarge is returned when the thumbnail is larger than what Google Drive accepts
+var ErrThumbnailTooLarge = errors.New("thumbnail is larger than 2MB")
+
+// ErrUnknownHashMethod is returned when a hash method isn't known
+var ErrUnknownHashMethod = errors.New("unknown hash method")
+
+// ErrNotNativeDocument is returned when exporting a file that isn't a Google Workspace document
+var ErrNotNativeDocument = errors.New("not a native document")
+
+// ErrInvalidChildName is returned when a name passed to a directory handle isn't a single path element
+var ErrInvalidChildName = errors.New("child name must be a single path element")
+
+// ErrReauthRequired is returned when the API rejected a call because the authorization is no longer valid
+var ErrReauthRequired = errors.New("authorization is no longer valid")
+
+// ErrSingleParent is returned when an operation would give several parents to a file that can only have
+// one, or would remove the last parent of a file
+var ErrSingleParent = errors.New("file must have a single parent")
+
+// ErrUploadSpillExceeded is returned when more content than UploadSpillMaxSize is written to a file
+var ErrUploadSpillExceeded = errors.New("content is larger than the upload spill")
+
+// ErrNotOrphan is returned when reparenting a file that still has a parent
+var ErrNotOrphan = errors.New("file has a parent")
+
+// ErrRateLimited is matched by errors.Is for the calls rejected because too many calls were made
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// ErrQuotaExceeded is matched by errors.Is for the calls rejected because a quota, like the storage quota of
+// the account, is exhausted
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrPermissionDenied is matched by errors.Is for the calls rejected because the account isn't allowed to
+// perform them
+var ErrPermissionDenied = errors.New("permission denied")
+
 // errInternalNil is an internal error and it should never be reported
 var errInternalNil = errors.New("internal nil error")
 
@@ -44,6 +98,11 @@ func (e FileNotExistError) Error() string {
 	return fmt.Sprintf("`%s' does not exist", e.Path)
 }
 
+// Is makes errors.Is(err, fs.ErrNotExist) match a FileNotExistError
+func (e FileNotExistError) Is(target error) bool {
+	return target == os.ErrNotExist
+}
+
 // FileExistError will be thrown if an File exists
 type FileExistError struct {
 	Path string
@@ -53,11 +112,94 @@ func (e FileExistError) Error() string {
 	return fmt.Sprintf("\"%s\" already exists", e.Path)
 }
 
-// IsNotExist returns true if the error is an FileNotExistError
+// Is makes errors.Is(err, fs.ErrExist) match a FileExistError
+func (e FileExistError) Is(target error) bool {
+	return target == os.ErrExist
+}
+
+// IsNotExist returns true if the error is an FileNotExistError, even when wrapped in an *os.PathError, or if
+// the Google Drive API reported that a file doesn't exist. errors.Is(err, fs.ErrNotExist) also matches them,
+// but os.IsNotExist doesn't as it doesn't unwrap errors.
 func IsNotExist(e error) bool {
 	var fileNotExistError *FileNotExistError
 
-	return errors.As(e, &fileNotExistError)
+	return errors.As(e, &fileNotExistError) || isNotFoundError(e)
+}
+
+// IsRateLimited returns true if the Google Drive API rejected a call because too many calls were made. Such a
+// call can succeed later.
+func IsRateLimited(e error) bool {
+	return errors.Is(e, ErrRateLimited)
+}
+
+// IsQuotaExceeded returns true if the Google Drive API rejected a call because a quota is exhausted, like
+// when the storage of the account is full
+func IsQuotaExceeded(e error) bool {
+	return errors.Is(e, ErrQuotaExceeded)
+}
+
+// IsExist returns true if the error is an FileExistError
+func IsExist(e error) bool {
+	var fileExistError *FileExistError
+
+	return errors.As(e, &fileExistError)
+}
+
+// FileTrashedError is returned when the target of a path doesn't exist but is present in the trash
+type FileTrashedError struct {
+	Path string
+	ID   string
+}
+
+func (e FileTrashedError) Error() string {
+	return fmt.Sprintf("`%s' is in the trash (id: %s)", e.Path, e.ID)
+}
+
+// IsTrashed returns true if the error is a FileTrashedError
+func IsTrashed(e error) bool {
+	var fileTrashedError *FileTrashedError
+
+	return errors.As(e, &fileTrashedError)
+}
+
+// NativeDocWriteError is returned when a Google Workspace document is opened for writing
+type NativeDocWriteError struct {
+	Path     string
+	MimeType string
+}
+
+func (e NativeDocWriteError) Error() string {
+	return fmt.Sprintf("`%s' is a native document (%s) and can't be written", e.Path, e.MimeType)
+}
+
+// NativeDocReadError is returned when a Google Workspace document is opened for reading while the
+// GoogleDocPolicy doesn't allow to export it
+type NativeDocReadError struct {
+	Path     string
+	MimeType string
+}
+
+func (e NativeDocReadError) Error() string {
+	return fmt.Sprintf("`%s' is a native document (%s) and can't be read", e.Path, e.MimeType)
+}
+
+// PathOutsideRootError is returned when a path uses ".." to go above the root directory
+type PathOutsideRootError struct {
+	Path string
+}
+
+func (e PathOutsideRootError) Error() string {
+	return fmt.Sprintf("`%s' goes outside of the root directory", e.Path)
+}
+
+// FileTooLargeError is returned when a file is too large for the requested operation
+type FileTooLargeError struct {
+	Path    string
+	MaxSize int64
+}
+
+func (e FileTooLargeError) Error() string {
+	return fmt.Sprintf("`%s' is larger than %d bytes", e.Path, e.MaxSize)
 }
 
 // FileIsDirectoryError will be thrown if a File is a directory
@@ -108,6 +250,46 @@ func (e NoFileInformationError) Error() string {
 	return fmt.Sprintf("no file information present in path \"%s\"", e.Path)
 }
 
+// ShutdownError is returned by Shutdown when some files opened for writing couldn't be written
+type ShutdownError struct {
+	Errors map[string]error // Errors contains the error of each file that couldn't be written, by path
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("%d file(s) couldn't be written before shutdown", len(e.Errors))
+}
+
+// ChecksumUnavailableError is returned when Google Drive doesn't provide the checksum of a file, like for
+// the Google Workspace documents
+type ChecksumUnavailableError struct {
+	Path string
+}
+
+func (e *ChecksumUnavailableError) Error() string {
+	return fmt.Sprintf("checksum of \"%s\" is not available", e.Path)
+}
+
+// SizeMismatchError is returned by UploadSized when the size Google Drive reports for the uploaded file
+// isn't the one that was sent
+type SizeMismatchError struct {
+	Path     string
+	Expected int64
+	Actual   int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("\"%s\" was uploaded with %d bytes but has %d bytes", e.Path, e.Expected, e.Actual)
+}
+
+// UnlimitedQuotaError is returned by About when the storage of the account isn't limited
+type UnlimitedQuotaError struct {
+	Quota *Quota // Quota contains the usage of the account, its Limit is 0
+}
+
+func (e *UnlimitedQuotaError) Error() string {
+	return "the storage of the account is unlimited"
+}
+
 // DriveAPICallError wraps an error that was returned by the Google Drive API
 type DriveAPICallError struct {
 	Err error
@@ -122,6 +304,37 @@ func (e *DriveAPICallError) Unwrap() error {
 	return e.Err
 }
 
+// Is classifies the Google Drive API returned error, so that errors.Is matches ErrRateLimited,
+// ErrQuotaExceeded, ErrPermissionDenied and fs.ErrNotExist
+func (e *DriveAPICallError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return isRateLimitError(e.Err)
+	case ErrQuotaExceeded:
+		return isQuotaError(e.Err)
+	case ErrPermissionDenied:
+		return isPermissionError(e.Err)
+	case os.ErrNotExist:
+		return isNotFoundError(e.Err)
+	default:
+		return false
+	}
+}
+
+// As makes errors.As find a *FileNotExistError in the Google Drive API reporting that a file doesn't exist, like
+// a file deleted between its lookup and its modification. Its Path is empty as only the ID of the file is known
+// by the call, the *os.PathError wrapping the error gives the path of the operation.
+func (e *DriveAPICallError) As(target interface{}) bool {
+	notExist, ok := target.(**FileNotExistError)
+	if !ok || !isNotFoundError(e.Err) {
+		return false
+	}
+
+	*notExist = &FileNotExistError{}
+
+	return true
+}
+
 // DriveStreamError wraps an error that happened while using a stream opened from the Google Drive API
 type DriveStreamError struct {
 	Err error
@@ -135,3 +348,76 @@ func (e *DriveStreamError) Error() string {
 func (e *DriveStreamError) Unwrap() error {
 	return e.Err
 }
+
+// pathError wraps the error of a filesystem operation into an *os.PathError, like the os package does. The
+// underlying error can still be checked with errors.As and errors.Is. An error that already is an
+// *os.PathError, like the one of a method calling another one, is returned as is.
+func pathError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// isRateLimitError returns true if the error is the Google Drive API telling us to slow down
+func isRateLimitError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+
+	if apiErr.Code == http.StatusForbidden {
+		for _, e := range apiErr.Errors {
+			if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// quotaReasons are the reasons of the 403 errors reporting that a quota is exhausted
+var quotaReasons = []string{"storageQuotaExceeded", "quotaExceeded", "dailyLimitExceeded", "teamDriveFileLimitExceeded"}
+
+// isQuotaError returns true if the error is the Google Drive API reporting that a quota is exhausted
+func isQuotaError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+
+	for _, e := range apiErr.Errors {
+		if slices.Contains(quotaReasons, e.Reason) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPermissionError returns true if the error is the Google Drive API refusing a call that the account isn't
+// allowed to perform, which are the 403 errors that aren't about rate limits or quotas
+func isPermissionError(err error) bool {
+	var apiErr *googleapi.Error
+
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden &&
+		!isRateLimitError(err) && !isQuotaError(err)
+}
+
+// isNotFoundError returns true if the error is the Google Drive API reporting that a file doesn't exist
+func isNotFoundError(err error) bool {
+	var apiErr *googleapi.Error
+
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
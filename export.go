@@ -0,0 +1,98 @@
+package gdrive // nolint: golint
+
+import (
+	"context"
+	"io"
+	"mime"
+	"path"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// exportExtensions are the extensions of the formats Google Workspace documents can be exported to
+var exportExtensions = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   ".docx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         ".xlsx",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": ".pptx",
+	"application/vnd.oasis.opendocument.text":                                   ".odt",
+	"application/vnd.oasis.opendocument.spreadsheet":                            ".ods",
+	"application/vnd.oasis.opendocument.presentation":                           ".odp",
+	"application/pdf":           ".pdf",
+	"application/rtf":           ".rtf",
+	"application/epub+zip":      ".epub",
+	"application/zip":           ".zip",
+	"text/plain":                ".txt",
+	"text/html":                 ".html",
+	"text/csv":                  ".csv",
+	"text/tab-separated-values": ".tsv",
+	"text/markdown":             ".md",
+	"image/jpeg":                ".jpg",
+	"image/png":                 ".png",
+	"image/svg+xml":             ".svg",
+}
+
+// exportExtension returns the extension of an export format, or an empty string if it isn't known
+func exportExtension(exportMime string) string {
+	if ext, ok := exportExtensions[exportMime]; ok {
+		return ext
+	}
+
+	if exts, err := mime.ExtensionsByType(exportMime); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+
+	return ""
+}
+
+// ExportCopy exports a Google Workspace document to the exportMime format and stores the result as a new
+// regular file at destPath. The extension of the format is added to destPath if it doesn't have one.
+// The export is streamed, the content isn't stored in memory.
+func (d *GDriver) ExportCopy(filePath, destPath, exportMime string) (*FileInfo, error) {
+	fi, err := d.getFile(filePath, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isGoogleDoc(fi.file) {
+		return nil, ErrNotNativeDocument
+	}
+
+	if path.Ext(destPath) == "" {
+		destPath += exportExtension(exportMime)
+	}
+
+	reader, err := d.exportFile(d.ctx, fi, exportMime)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	return d.CreateWith(destPath, &drive.File{MimeType: exportMime}, reader)
+}
+
+// Export streams the export of a Google Workspace document to the exportMime format, like "application/pdf"
+// or "text/plain" for a document. The formats each type of document can be exported to are listed by Google
+// Drive's About.Get call. The returned stream must be closed.
+func (d *GDriver) Export(filePath, exportMime string) (io.ReadCloser, error) {
+	fi, err := d.getFile(filePath, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isGoogleDoc(fi.file) {
+		return nil, ErrNotNativeDocument
+	}
+
+	return d.exportFile(d.ctx, fi, exportMime)
+}
+
+// exportFile starts the export of a document
+func (d *GDriver) exportFile(ctx context.Context, fi *FileInfo, exportMime string) (io.ReadCloser, error) {
+	resp, err := d.srv.Files.Export(fi.file.Id, exportMime).Context(ctx).Download()
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	return resp.Body, nil
+}
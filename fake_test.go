@@ -0,0 +1,128 @@
+package gdrive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+)
+
+// fakeTransport serves the Google Drive API requests from a local handler, no network is involved
+type fakeTransport struct {
+	handler http.HandlerFunc
+}
+
+func (t *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Like a real transport, the request fails if its body can't be read
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	rec := httptest.NewRecorder()
+	t.handler(rec, req)
+
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	return rec.Result(), nil
+}
+
+const fakeRootID = "fake-root-id"
+
+// newFakeDriver creates a driver whose API calls are all served by the handler. The root node lookup
+// performed by New is answered directly.
+func newFakeDriver(t testing.TB, handler http.HandlerFunc, opts ...Option) *GDriver {
+	client := &http.Client{
+		Transport: &fakeTransport{
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files/root":
+					writeJSON(w, http.StatusOK, &drive.File{Id: fakeRootID, Name: "My Drive", MimeType: mimeTypeFolder})
+				case r.URL.Path == "/batch/drive/v3":
+					serveBatch(t, w, r, handler)
+				default:
+					handler(w, r)
+				}
+			},
+		},
+	}
+
+	driver, err := New(client, opts...)
+	require.NoError(t, err)
+
+	return driver
+}
+
+// serveBatch dispatches each call of a batch request to the handler
+func serveBatch(t testing.TB, w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	require.NoError(t, err)
+
+	body := &bytes.Buffer{}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	mw := multipart.NewWriter(body)
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		require.NoError(t, err)
+
+		req, err := http.ReadRequest(bufio.NewReader(part))
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", "<response-"+strings.Trim(part.Header.Get("Content-ID"), "<>")+">")
+		out, err := mw.CreatePart(header)
+		require.NoError(t, err)
+		require.NoError(t, rec.Result().Write(out))
+	}
+
+	require.NoError(t, mw.Close())
+
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body.Bytes())
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = fmt.Fprintf(
+		w,
+		`{"error":{"code":%d,"message":"%s","errors":[{"reason":"%s"}]}}`,
+		code, http.StatusText(code), reason,
+	)
+}
@@ -1,11 +1,13 @@
 package gdrive // nolint: golint
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -17,14 +19,18 @@ func (f *File) AsAfero() afero.File {
 
 // File represents the managed file structure
 type File struct {
-	*FileInfo                     // FileInfo contains the core fileInfo
-	Path           string         // Path is the complete path of hte file
-	driver         *GDriver       // driver is a reference to the parent driver
-	streamRead     io.ReadCloser  // streamRead is the underlying reading stream
-	streamWrite    io.WriteCloser // streamWrite is the underlying writing stream
-	streamWriteEnd chan error     // streamWriteEnd is a channel returning the error of the underlying write stream
-	streamOffset   int64          // streamOffset is the position of the stream
-	dirListToken   string         // dirListToken contains the token used to list files
+	*FileInfo                            // FileInfo contains the core fileInfo
+	Path              string             // Path is the complete path of hte file
+	driver            *GDriver           // driver is a reference to the parent driver
+	streamRead        io.ReadCloser      // streamRead is the underlying reading stream
+	streamWrite       io.WriteCloser     // streamWrite is the underlying writing stream
+	streamWriteEnd    chan error         // streamWriteEnd is a channel returning the error of the underlying write stream
+	streamWriteCancel context.CancelFunc // streamWriteCancel aborts the upload
+	streamWriteResult *uploadResult      // streamWriteResult receives the file returned by the upload
+	streamOffset      int64              // streamOffset is the position of the stream
+	dirListToken      string             // dirListToken contains the token used to list files
+	closed            bool               // closed is set once the file has been closed
+	closeErr          error              // closeErr is the result of the first Close
 }
 
 // Seek sets the offset for the next Read or Write to offset
@@ -43,6 +49,9 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	return 0, afero.ErrFileClosed
 }
 
+// seekForwardThresholdDefault is the default SeekForwardThreshold
+const seekForwardThresholdDefault = 64 * 1024
+
 func (f *File) seekRead(offset int64, whence int) (int64, error) {
 	startByte := int64(0)
 
@@ -55,6 +64,19 @@ func (f *File) seekRead(offset int64, whence int) (int64, error) {
 		startByte = f.FileInfo.Size() - offset
 	}
 
+	// Skipping a few bytes is cheaper than starting a new download
+	if skip := startByte - f.streamOffset; skip >= 0 && skip <= f.driver.SeekForwardThreshold {
+		skipped, err := io.CopyN(io.Discard, f.streamRead, skip)
+		f.streamOffset += skipped
+
+		// Seeking after the end of the file is allowed, the reads then return io.EOF
+		if err == nil || errors.Is(err, io.EOF) {
+			f.streamOffset = startByte
+
+			return startByte, nil
+		}
+	}
+
 	if err := f.streamRead.Close(); err != nil {
 		return 0, fmt.Errorf("couldn't close previous stream: %w", err)
 	}
@@ -68,17 +90,84 @@ func (f *File) seekRead(offset int64, whence int) (int64, error) {
 	var err error
 
 	f.streamRead, err = f.driver.getFileReader(f.FileInfo, startByte)
+	if err == nil {
+		f.streamOffset = startByte
+	}
 
 	return startByte, err
 }
 
-// ReadAt reads a file at a specific offset
-func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
-	if _, err := f.Seek(off, 0); err != nil {
+// Offset returns the current position in the file: the number of bytes read from the start for a file
+// opened for reading, or the number of bytes written for a file opened for writing.
+func (f *File) Offset() int64 {
+	return f.streamOffset
+}
+
+// Reopen opens a new read stream starting at the current offset, dropping the previous one if any. This
+// allows to resume reading after a transient failure without losing the position. Files opened for
+// writing can't be reopened as the upload can't be resumed, ErrWriteOnly is returned for them.
+func (f *File) Reopen() error {
+	if f.streamWrite != nil {
+		return ErrWriteOnly
+	}
+
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+
+	if f.streamRead != nil {
+		_ = f.streamRead.Close()
+		f.streamRead = nil
+	}
+
+	var err error
+
+	f.streamRead, err = f.driver.getFileReader(f.FileInfo, f.streamOffset)
+
+	return err
+}
+
+// ReadAt reads a file at a specific offset. Each call performs its own ranged download, the read stream of the
+// file and its position are left untouched.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.streamWrite != nil {
+		return 0, ErrWriteOnly
+	}
+
+	if f.closed || f.streamRead == nil {
+		return 0, afero.ErrFileClosed
+	}
+
+	if off < 0 {
+		return 0, ErrInvalidSeek
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Drive rejects the ranges starting after the end of the file
+	if !isGoogleDoc(f.FileInfo.file) && off >= f.FileInfo.Size() {
+		return 0, io.EOF
+	}
+
+	reader, err := f.driver.getFileRangeReader(context.Background(), f.FileInfo, off, int64(len(p)))
+	if err != nil {
 		return 0, err
 	}
 
-	return f.Read(p)
+	defer func() { _ = reader.Close() }()
+
+	n, err := io.ReadFull(reader, p)
+
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		err = io.EOF
+	case err != nil && !errors.Is(err, io.EOF):
+		err = &DriveStreamError{Err: err}
+	}
+
+	return n, err
 }
 
 // Readdir provides a list of file information
@@ -88,13 +177,13 @@ func (f *File) Readdir(count int) ([]os.FileInfo, error) {
 
 // Readdirnames provides a list of directory names
 func (f *File) Readdirnames(n int) ([]string, error) {
-	names := make([]string, n, 0)
-
 	dirs, err := f.Readdir(n)
 	if err != nil {
 		return nil, err
 	}
 
+	names := make([]string, 0, len(dirs))
+
 	for _, d := range dirs {
 		names = append(names, d.Name())
 	}
@@ -102,10 +191,67 @@ func (f *File) Readdirnames(n int) ([]string, error) {
 	return names, nil
 }
 
-// Truncate should truncate a file to a specific size. But this method is not supported by
-// the google drive API.
-func (f *File) Truncate(int64) error {
-	return ErrNotSupported
+// Truncate changes the size of a file opened for writing, before anything was written to it. As an upload
+// replaces the whole content of the file, the first size bytes of the current content are downloaded and
+// written to the upload, padded with zeros if the file is smaller. This costs an additional download, unless
+// size is 0. The following writes are added after them, and the file is only changed on Drive when it's closed.
+func (f *File) Truncate(size int64) error {
+	// Files opened for reading can't be modified
+	if f.streamRead != nil {
+		return ErrNotSupported
+	}
+
+	if f.streamWrite == nil {
+		return afero.ErrFileClosed
+	}
+
+	if size < 0 {
+		return ErrInvalidSeek
+	}
+
+	// What was already written can't be taken back
+	if f.streamOffset != 0 {
+		return ErrNotSupported
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	var written int64
+
+	if f.FileInfo.Size() > 0 {
+		reader, err := f.driver.getFileReader(f.FileInfo, 0)
+		if err != nil {
+			return err
+		}
+
+		written, err = io.Copy(f, io.LimitReader(reader, size))
+		_ = reader.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if written < size {
+		if _, err := io.CopyN(f, zeroReader{}, size-written); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// zeroReader is an endless source of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
 }
 
 func (f *File) Read(p []byte) (int, error) {
@@ -113,9 +259,17 @@ func (f *File) Read(p []byte) (int, error) {
 		return 0, ErrWriteOnly
 	}
 
+	if f.closed || f.streamRead == nil {
+		return 0, afero.ErrFileClosed
+	}
+
 	n, err := f.streamRead.Read(p)
 	f.streamOffset += int64(n)
 
+	if n > 0 && f.driver.DownloadProgress != nil {
+		f.driver.DownloadProgress(f.streamOffset, f.FileInfo.Size())
+	}
+
 	if err != nil && !errors.Is(err, io.EOF) {
 		err = &DriveStreamError{Err: err}
 	}
@@ -128,16 +282,38 @@ func (f *File) Write(p []byte) (int, error) {
 		return 0, ErrReadOnly
 	}
 
+	if f.closed || f.streamWrite == nil || f.uploadEnded() {
+		return 0, afero.ErrFileClosed
+	}
+
 	n, err := f.streamWrite.Write(p)
 	f.streamOffset += int64(n)
 
-	if err != nil && !errors.Is(err, io.EOF) {
+	switch {
+	case errors.Is(err, io.ErrClosedPipe):
+		// The upload ended while we were writing
+		err = afero.ErrFileClosed
+	case err != nil && !errors.Is(err, io.EOF):
 		err = &DriveStreamError{Err: err}
 	}
 
 	return n, err
 }
 
+// uploadEnded tells if the upload already ended, in which case nothing can be written anymore. The result of
+// the upload is left for Close.
+func (f *File) uploadEnded() bool {
+	select {
+	case err := <-f.streamWriteEnd:
+		// The channel has room for it as the uploader sends a single result
+		f.streamWriteEnd <- err
+
+		return true
+	default:
+		return false
+	}
+}
+
 // WriteAt writes some bytes at a specified offset
 func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
 	if _, err := f.Seek(off, 0); err != nil {
@@ -153,17 +329,35 @@ func (f *File) WriteString(s string) (ret int, err error) {
 }
 
 // Close closes the file
-// This marks the end of the file write.
+// This marks the end of the file write. If the driver has a CloseTimeout and the upload doesn't complete
+// in time, it is aborted and ErrCloseTimeout is returned. Closing the file again has no effect and returns
+// the result of the first call.
 func (f *File) Close() error {
+	if f.closed {
+		return f.closeErr
+	}
+
+	f.closed = true
+	f.closeErr = f.closeStreams()
+
+	return f.closeErr
+}
+
+// closeStreams closes the read or write stream of the file
+func (f *File) closeStreams() error {
 	if f.streamWrite != nil {
-		err := f.streamWrite.Close()
-		if err != nil {
-			log.Println("Closing issue: ", err)
+		closeErr := f.closeWrite()
+
+		// The uploaded file carries the new size and checksum, the FileInfo might be shared so it is replaced
+		if closeErr == nil && f.streamWriteResult != nil && f.streamWriteResult.file != nil {
+			f.FileInfo = &FileInfo{file: f.streamWriteResult.file, parentPath: f.FileInfo.parentPath}
 		}
 
-		closeErr := <-f.streamWriteEnd
 		f.streamWrite = nil
 		f.streamWriteEnd = nil
+		f.streamWriteCancel = nil
+		f.streamWriteResult = nil
+		f.driver.unregisterWriter(f, closeErr)
 
 		return closeErr
 	} else if f.streamRead != nil {
@@ -179,6 +373,35 @@ func (f *File) Close() error {
 	return nil
 }
 
+func (f *File) closeWrite() error {
+	closed := make(chan error, 1)
+
+	// Closing the stream flushes the buffers, which can block as long as the upload is stuck
+	go func(streamWrite io.WriteCloser, streamWriteEnd chan error) {
+		if err := streamWrite.Close(); err != nil {
+			log.Println("Closing issue: ", err)
+		}
+
+		closed <- <-streamWriteEnd
+	}(f.streamWrite, f.streamWriteEnd)
+
+	if f.driver.CloseTimeout <= 0 {
+		return <-closed
+	}
+
+	timer := time.NewTimer(f.driver.CloseTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-closed:
+		return err
+	case <-timer.C:
+		f.streamWriteCancel()
+
+		return ErrCloseTimeout
+	}
+}
+
 // Stat provides stat file information
 func (f *File) Stat() (os.FileInfo, error) {
 	return f.FileInfo, nil
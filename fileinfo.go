@@ -3,23 +3,31 @@ package gdrive // nolint: golint
 import (
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	drive "google.golang.org/api/drive/v3"
 )
 
-const mimeFolder = "application/vnd.google-apps.folder"
-
 // FileInfo represents File information for a File or directory
 type FileInfo struct {
 	file       *drive.File
 	parentPath string
+	target     string // target is the ID of the folder pointed by a followed shortcut
 }
 
 // Mode returns the file mode bits
 func (i *FileInfo) Mode() os.FileMode {
 	mode := os.FileMode(0)
-	if i.file.MimeType == mimeFolder {
+
+	if value, ok := i.file.Properties[fileModeProperty]; ok {
+		if stored, err := strconv.Atoi(value); err == nil {
+			mode = os.FileMode(stored).Perm()
+		}
+	}
+
+	if i.IsDir() {
 		mode |= os.ModeDir
 	}
 
@@ -40,22 +48,49 @@ func (i *FileInfo) CreateTime() time.Time {
 	return t
 }
 
+// ViewedByMe returns true if the account viewed the file. It is only available when the file was fetched with
+// FetchViewedByMe, or by ListRecentlyViewed.
+func (i *FileInfo) ViewedByMe() bool {
+	return i.file.ViewedByMe
+}
+
+// ViewedByMeTime returns the last time the account viewed the file. It is zero if the file wasn't viewed, or
+// wasn't fetched with FetchViewedByMe or by ListRecentlyViewed.
+func (i *FileInfo) ViewedByMeTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, i.file.ViewedByMeTime)
+
+	return t
+}
+
+// AccessTime returns the access time set by Chtimes, or the zero time if it was never set
+func (i *FileInfo) AccessTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, i.file.Properties[accessTimeProperty])
+
+	return t
+}
+
 // Sys provides underlying data source
 func (i *FileInfo) Sys() interface{} {
 	return i.file
 }
 
-// Name returns the name of the File or directory
+// Name returns the base name of the File or directory, as required by the os.FileInfo contract.
+// Path provides the full path relative to the root directory of the driver.
 func (i *FileInfo) Name() string {
 	return sanitizeName(i.file.Name)
 }
 
+// ID returns the Google Drive ID of the File or directory
+func (i *FileInfo) ID() string {
+	return i.file.Id
+}
+
 // ParentPath returns the parent path of the File or directory
 func (i *FileInfo) ParentPath() string {
 	return i.parentPath
 }
 
-// Path returns the full path to this File or directory
+// Path returns the full path to this File or directory, relative to the root directory of the driver
 func (i *FileInfo) Path() string {
 	return path.Join(i.parentPath, i.Name())
 }
@@ -65,9 +100,47 @@ func (i *FileInfo) Size() int64 {
 	return i.file.Size
 }
 
-// IsDir returns true if this File is a directory
+// IsDir returns true if this File is a directory, or a followed shortcut to a directory
 func (i *FileInfo) IsDir() bool {
-	return i.file.MimeType == mimeTypeFolder
+	return i.file.MimeType == mimeTypeFolder || i.target != ""
+}
+
+// folderID returns the ID to use to access the content of a directory
+func (i *FileInfo) folderID() string {
+	if i.target != "" {
+		return i.target
+	}
+
+	return i.file.Id
+}
+
+// OriginalFilename returns the name of the file that was uploaded, if any. Unlike the name, it isn't
+// sanitized and isn't changed when the file is renamed.
+func (i *FileInfo) OriginalFilename() string {
+	return i.file.OriginalFilename
+}
+
+// MD5 returns the hex-encoded MD5 checksum of the content, as computed by Google Drive. It is empty for the
+// directories and the Google Workspace documents.
+func (i *FileInfo) MD5() string {
+	return i.file.Md5Checksum
+}
+
+// WebViewLink returns the link to open the file in a browser
+func (i *FileInfo) WebViewLink() string {
+	return i.file.WebViewLink
+}
+
+// WebContentLink returns the link to download the content of the file in a browser. It is empty for the
+// directories and the Google Workspace documents.
+func (i *FileInfo) WebContentLink() string {
+	return i.file.WebContentLink
+}
+
+// IsNative returns true if this File is a Google Workspace document (Docs, Sheets, Slides...). Their
+// content can't be downloaded or uploaded as-is.
+func (i *FileInfo) IsNative() bool {
+	return strings.HasPrefix(i.file.MimeType, mimeTypeNativePrefix) && !i.IsDir()
 }
 
 // DriveFile returns the underlaying drive.File
@@ -89,3 +162,40 @@ func sanitizeName(s string) string {
 func isPathSeperator(r rune) bool {
 	return r == '/' || r == '\\'
 }
+
+// splitPath splits a path into its parts. Both '/' and '\' are accepted as separators, so duplicate,
+// leading and trailing separators are ignored. "." parts are dropped and ".." parts remove the previous
+// one, going above the root returns a *PathOutsideRootError. As a consequence, a file literally named ".."
+// can't be addressed by its path.
+func splitPath(p string) ([]string, error) {
+	parts := strings.FieldsFunc(p, isPathSeperator)
+	cleaned := parts[:0]
+
+	for _, part := range parts {
+		switch part {
+		case ".":
+			continue
+		case "..":
+			if len(cleaned) == 0 {
+				return nil, &PathOutsideRootError{Path: p}
+			}
+
+			cleaned = cleaned[:len(cleaned)-1]
+		default:
+			cleaned = append(cleaned, part)
+		}
+	}
+
+	return cleaned, nil
+}
+
+// normalizePath converts a path to its canonical form: parts separated by a single '/', without any
+// leading or trailing separator. The root is represented by an empty string.
+func normalizePath(p string) (string, error) {
+	parts, err := splitPath(p)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(parts, "/"), nil
+}
@@ -0,0 +1,43 @@
+package gdrive
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePath(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":                   "",
+		"/":                  "",
+		"//":                 "",
+		"\\":                 "",
+		"a":                  "a",
+		"/a/":                "a",
+		"//a/":               "a",
+		"/a//b":              "a/b",
+		"a\\b":               "a/b",
+		"\\a\\\\b\\":         "a/b",
+		"C:\\Folder1/File1":  "C:/Folder1/File1",
+		"/Folder1\\/File1//": "Folder1/File1",
+		"a/./b":              "a/b",
+		"./a":                "a",
+		"a/../b":             "b",
+		"a/b/..":             "a",
+		"a/..":               "",
+		"a\\..\\b":           "b",
+		"a/..b/c..":          "a/..b/c..",
+	} {
+		normalized, err := normalizePath(input)
+		require.NoError(t, err, "input: %q", input)
+		require.Equal(t, expected, normalized, "input: %q", input)
+	}
+
+	for _, input := range []string{"..", "/..", "../a", "a/../../b", "a/../.."} {
+		_, err := normalizePath(input)
+
+		var outsideErr *PathOutsideRootError
+		require.True(t, errors.As(err, &outsideErr), "input: %q", input)
+	}
+}
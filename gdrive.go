@@ -3,11 +3,15 @@ package gdrive
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +23,7 @@ import (
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 
+	"github.com/fclairamb/afero-gdrive/cache"
 	"github.com/fclairamb/afero-gdrive/iohelper"
 )
 
@@ -45,16 +50,97 @@ type GDriver struct {
 	TrashForDelete      bool
 	WriteBufferType     WriteBufferType
 	WriteBufferSize     int
-	srvWrapper          *APIWrapper
+	WritePipeBufferSize int
+	BufferedReadMaxSize int64
+	ListRetryMax        int
+	ListRetryBackoff    time.Duration
+	CloseTimeout        time.Duration
+	CreateRetryMax      int
+	CreateRetryBackoff  time.Duration
+	RetryMax            int
+	RetryBaseDelay      time.Duration
+	OverwriteNativeDocs bool
+	GoogleDocPolicy     GoogleDocPolicy
+	GoogleDocExportMime string
+	FollowShortcuts     bool
+	AllowReadWrite      bool
+	// DefaultPermissions are granted on every file created through the driver. When it fails, the creation
+	// fails too, unless IgnoreDefaultPermissionErrors is set, in which case the failure is only logged.
+	DefaultPermissions            []SharePermission
+	IgnoreDefaultPermissionErrors bool
+	// OnUnauthorized is called when the API rejects a call because the authorization is no longer valid. If
+	// it returns nil, the call is retried once, so it should refresh the credentials used by the HTTP client
+	// passed to New. Without it, or if it fails, the call fails with ErrReauthRequired.
+	OnUnauthorized func() error
+	// DefaultParent is a directory path, relative to the root, where the files and directories created with a
+	// bare name like "file.txt" are placed instead of the root. Paths with several segments like "dir/file.txt"
+	// and paths starting with a "/" like "/file.txt" are left untouched, so the root stays reachable.
+	DefaultParent string
+	// KeepUnresolvedShortcuts makes ReaddirResolved return the shortcuts whose target can't be fetched, like
+	// when it was deleted or isn't shared with the account, instead of dropping them.
+	KeepUnresolvedShortcuts bool
+	// FetchViewedByMe makes Stat, the listings and the searches fetch when the account last viewed the files,
+	// as returned by FileInfo.ViewedByMe and FileInfo.ViewedByMeTime.
+	FetchViewedByMe bool
+	// DownloadProgress, when set, is called by File.Read after each read of a file opened for reading, with the
+	// position reached in the file and the size of the file. It is called from the goroutine reading the file.
+	DownloadProgress func(downloaded, total int64)
+	// MetricsObserver, when set, is told about each call to the API, with its duration and its error
+	MetricsObserver MetricsObserver
+	// SeekForwardThreshold is the largest forward seek, in bytes, performed on a file opened for reading by
+	// discarding the content in between from the current download, instead of starting a new download. The
+	// other seeks always start a new download.
+	SeekForwardThreshold int64
+	// BufferedSpillFs, when set, is where OpenBuffered stores the files bigger than BufferedSpillThreshold,
+	// in a temporary file, instead of keeping them in memory. These files aren't limited by BufferedReadMaxSize.
+	BufferedSpillFs        afero.Fs
+	BufferedSpillThreshold int64
+	// UploadSpillFs, when set, is where the content written to the files opened for writing is copied, in a
+	// temporary file, so that their upload is performed a second time if it fails with a transient error. Up
+	// to UploadSpillMaxSize bytes can then be written to a file, the upload fails with ErrUploadSpillExceeded
+	// beyond that.
+	UploadSpillFs      afero.Fs
+	UploadSpillMaxSize int64
+	// ListFilterFunc, when set, is called on every listed file and the ones for which it returns false
+	// are dropped. Drive can't filter on capabilities in its queries, so they are fetched for each file
+	// when a filter is set, making listings more expensive in bandwidth.
+	ListFilterFunc func(*drive.File) bool
+	srvWrapper     *APIWrapper
+	writersMu      *sync.Mutex
+	writers        map[*File]*pendingWrite
+	ctx            context.Context
+	driveRoot      *FileInfo // driveRoot is the root folder of the drive
+	driveRootFor   string    // driveRootFor is the shared drive driveRoot was fetched for
 }
 
 // HashMethod is the hashing method to use for GetFileHash
 type HashMethod int
 
+const (
+	// HashMD5 is the MD5 checksum
+	HashMD5 HashMethod = iota
+	// HashSHA1 is the SHA-1 checksum
+	HashSHA1
+	// HashSHA256 is the SHA-256 checksum
+	HashSHA256
+)
+
 const (
 	mimeTypeFolder = "application/vnd.google-apps.folder"
 	mimeTypeFile   = "application/octet-stream"
 
+	// mimeTypeShortcut is the mime type of the shortcuts to other files
+	mimeTypeShortcut = "application/vnd.google-apps.shortcut"
+
+	// mimeTypeNativePrefix is the prefix of the mime types of the Google Workspace documents
+	mimeTypeNativePrefix = "application/vnd.google-apps."
+
+	// fileModeProperty is the property storing the mode of files and directories
+	fileModeProperty = "ftp_file_mode"
+
+	// accessTimeProperty is the property storing the access time set by Chtimes
+	accessTimeProperty = "ftp_file_atime"
+
 	// We should probably ignore these types of files:
 	// mimeTypeDocument     = "application/vnd.google-apps.document"
 	// mimeTypeSpreadsheet  = "application/vnd.google-apps.spreadsheet"
@@ -70,7 +156,15 @@ var (
 		"modifiedTime",
 		"name",
 		"size",
+		"shortcutDetails",
+		"originalFilename",
+		"properties",
+		"md5Checksum",
+		"webViewLink",
+		"webContentLink",
 	}
+	// viewedFields are the fields describing when the account viewed the files, see FetchViewedByMe
+	viewedFields   = []googleapi.Field{"viewedByMe", "viewedByMeTime"}
 	listFields     []googleapi.Field
 	sharedInitOnce sync.Once
 )
@@ -81,21 +175,59 @@ func sharedInit() {
 	}
 }
 
+// fileFields returns the fields to fetch for the files returned by Stat, the listings and the searches: the
+// fields of fileInfoFields, the viewedFields if FetchViewedByMe is set, and the extra ones
+func (d *GDriver) fileFields(extra ...googleapi.Field) googleapi.Field {
+	return googleapi.Field(fmt.Sprintf("files(%s)", googleapi.CombineFields(d.fileFieldList(extra...))))
+}
+
+// fileFieldList returns the fields of fileFields, for the calls returning a single file
+func (d *GDriver) fileFieldList(extra ...googleapi.Field) []googleapi.Field {
+	fields := append([]googleapi.Field{}, fileInfoFields...)
+
+	if d.FetchViewedByMe {
+		fields = append(fields, viewedFields...)
+	}
+
+	for _, field := range extra {
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
 // New creates a new Google Drive driver, client must me an authenticated instance for google drive
 func New(client *http.Client, opts ...Option) (*GDriver, error) {
 	sharedInitOnce.Do(sharedInit)
 
 	driver := &GDriver{
-		Logger: logno.NewNoOpLogger(),
+		Logger:               logno.NewNoOpLogger(),
+		ListRetryMax:         listRetryMaxDefault,
+		ListRetryBackoff:     listRetryBackoffDefault,
+		WritePipeBufferSize:  writePipeBufferSizeDefault,
+		BufferedReadMaxSize:  bufferedReadMaxSizeDefault,
+		SeekForwardThreshold: seekForwardThresholdDefault,
+		writersMu:            &sync.Mutex{},
+		writers:              make(map[*File]*pendingWrite),
+		ctx:                  context.Background(),
 	}
 
 	var err error
 
+	limiter := newRateLimiter()
+	client = withMetrics(withRetry(withUnauthorizedHandler(withRateLimit(client, limiter), driver), driver), driver)
+
 	driver.srv, err = drive.NewService(context.Background(), option.WithHTTPClient(client))
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve Drive client: %w", err)
 	}
 
+	driver.srvWrapper = NewAPIWrapper(driver.srv, driver.Logger.With("component", "api"))
+	driver.srvWrapper.httpClient = client
+	driver.srvWrapper.limiter = limiter
+
 	if _, err = driver.SetRootDirectory(""); err != nil {
 		return nil, err
 	}
@@ -106,8 +238,6 @@ func New(client *http.Client, opts ...Option) (*GDriver, error) {
 		}
 	}
 
-	driver.srvWrapper = NewAPIWrapper(driver.srv, driver.Logger.With("component", "api"))
-
 	return driver, nil
 }
 
@@ -121,11 +251,24 @@ func (d *GDriver) AsAfero() afero.Fs {
 	return d
 }
 
+// CacheStats returns the hits, misses and evictions of the cache of the lookups and listings, which helps
+// tuning options like NotFoundCache and CacheMaxEntries
+func (d *GDriver) CacheStats() cache.Stats {
+	return d.srvWrapper.cache.Stats()
+}
+
+// APICallCounts returns a snapshot of the number of calls performed to the API through the APIWrapper, by API
+// method like "Files.List" or "Files.Create". The calls made directly with the drive service aren't counted, a
+// MetricsObserver receives all the calls.
+func (d *GDriver) APICallCounts() map[string]int32 {
+	return d.srvWrapper.Counts()
+}
+
 // SetRootDirectory changes the working root directory
 // use this if you want to do certain operations in a special directory
 // path should always be the absolute real path
 func (d *GDriver) SetRootDirectory(path string) (*FileInfo, error) {
-	rootNode, err := getRootNode(d.srv)
+	rootNode, err := d.getDriveRootNode()
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve Drive root: %w", err)
 	}
@@ -144,13 +287,89 @@ func (d *GDriver) SetRootDirectory(path string) (*FileInfo, error) {
 	return file, nil
 }
 
-// Stat gives a FileInfo for a File or directory
+// getDriveRootNode returns the root folder of the drive. The "root" alias is only resolved once, so that the
+// real ID of the root folder is always used.
+func (d *GDriver) getDriveRootNode() (*FileInfo, error) {
+	sharedDriveID := d.srvWrapper.sharedDriveID
+
+	if d.driveRoot != nil && d.driveRootFor == sharedDriveID {
+		return d.driveRoot, nil
+	}
+
+	rootNode, err := getRootNode(d.srv, sharedDriveID)
+	if err != nil {
+		return nil, err
+	}
+
+	d.driveRoot = rootNode
+	d.driveRootFor = sharedDriveID
+
+	return rootNode, nil
+}
+
+// isRoot checks if a file is the root directory. IDs are compared as a file can be resolved multiple times.
+func (d *GDriver) isRoot(fi *FileInfo) bool {
+	return fi.file.Id == d.rootNode.file.Id
+}
+
+// Stat gives a FileInfo for a File or directory. Its errors are wrapped in an *os.PathError: a missing file
+// is matched by IsNotExist and errors.Is(err, fs.ErrNotExist), but not by os.IsNotExist.
 func (d *GDriver) Stat(path string) (os.FileInfo, error) {
-	return d.getFile(path, listFields...)
+	fi, err := d.getFile(path, d.fileFields())
+	if err != nil {
+		return nil, pathError("stat", path, err)
+	}
+
+	return fi, nil
 }
 
-const filesListPageSizeMax = 1000
+// StatTrashAware behaves like Stat, except that when the path (or one of its parent directories) is in the
+// trash, a FileTrashedError containing the ID of the trashed file is returned instead of a FileNotExistError.
+// This costs an additional call when the file doesn't exist.
+func (d *GDriver) StatTrashAware(filePath string) (os.FileInfo, error) {
+	fi, err := d.getFile(filePath, listFields...)
+	if err == nil {
+		return fi, nil
+	}
 
+	// The errors of the API don't have a path to look for in the trash
+	var notExist *FileNotExistError
+	if !errors.As(err, &notExist) || notExist.Path == "" {
+		return nil, err
+	}
+
+	parent, errParent := d.getFile(path.Dir(notExist.Path))
+	if errParent != nil {
+		return nil, err
+	}
+
+	files, errTrash := d.srvWrapper.getTrashedFileByFolderAndName(parent.folderID(), path.Base(notExist.Path))
+	if errTrash != nil {
+		return nil, &DriveAPICallError{Err: errTrash}
+	}
+
+	if len(files.Files) == 0 {
+		return nil, err
+	}
+
+	return nil, &FileTrashedError{Path: notExist.Path, ID: files.Files[0].Id}
+}
+
+const (
+	filesListPageSizeMax    = 1000
+	listRetryMaxDefault     = 3
+	listRetryBackoffDefault = time.Second
+)
+
+// listPage fetches a single listing page. Rate-limited pages are retried by the transport, as configured by
+// ListRetry, and the page token stays the same so that a failed listing can be resumed.
+func (d *GDriver) listPage(call *drive.FilesListCall) (*drive.FileList, error) {
+	return call.Context(context.WithValue(d.ctx, listRetryKey{}, true)).Do()
+}
+
+// listDirectory lists the content of a directory. If a page can't be fetched, the entries already
+// collected are returned along with the error and the page token is kept so that the next call
+// resumes the listing where it stopped.
 func (d *GDriver) listDirectory(f *File, count int) ([]os.FileInfo, error) {
 	if !f.FileInfo.IsDir() {
 		return nil, FileIsNotDirectoryError{Fi: f.FileInfo}
@@ -158,15 +377,25 @@ func (d *GDriver) listDirectory(f *File, count int) ([]os.FileInfo, error) {
 
 	files := make([]os.FileInfo, 0)
 
+	fields := []googleapi.Field{d.fileFields()}
+	if d.ListFilterFunc != nil {
+		fields = []googleapi.Field{d.fileFields("capabilities")}
+	}
+
+	// A complete listing can serve the lookups of the children that usually follow, like in a walk
+	complete := count < 0 && f.dirListToken == ""
+
+	var children []*drive.File
+
 	for count < 0 || len(files) < count {
 		pageSize := int64(count - len(files))
 		if pageSize > filesListPageSizeMax || pageSize <= 0 {
 			pageSize = filesListPageSizeMax
 		}
 
-		call := d.srv.Files.List().
-			Q(fmt.Sprintf("'%s' in parents and trashed = false", f.FileInfo.file.Id)).
-			Fields(append(listFields, "nextPageToken")...).
+		call := d.srvWrapper.filesList().
+			Q(fmt.Sprintf("'%s' in parents and trashed = false", f.FileInfo.folderID())).
+			Fields(append(fields, "nextPageToken")...).
 			OrderBy("name").
 			PageSize(pageSize)
 
@@ -174,20 +403,29 @@ func (d *GDriver) listDirectory(f *File, count int) ([]os.FileInfo, error) {
 			call = call.PageToken(f.dirListToken)
 		}
 
-		descendants, err := call.Do()
+		descendants, err := d.listPage(call)
 		if err != nil {
-			return nil, &DriveAPICallError{Err: err}
+			return files, &DriveAPICallError{Err: err}
 		}
 
 		if descendants == nil {
-			return nil, &NoFileInformationError{Fi: f.FileInfo}
+			return files, &NoFileInformationError{Fi: f.FileInfo}
+		}
+
+		if complete {
+			children = append(children, descendants.Files...)
 		}
 
 		for i := 0; i < len(descendants.Files); i++ {
-			files = append(files, &FileInfo{
-				file:       descendants.Files[i],
-				parentPath: f.FileInfo.Path(),
-			})
+			if d.ListFilterFunc != nil && !d.ListFilterFunc(descendants.Files[i]) {
+				continue
+			}
+
+			if d.hiddenInListings(descendants.Files[i]) {
+				continue
+			}
+
+			files = append(files, d.newFileInfo(descendants.Files[i], f.FileInfo.Path()))
 		}
 
 		f.dirListToken = descendants.NextPageToken
@@ -197,9 +435,186 @@ func (d *GDriver) listDirectory(f *File, count int) ([]os.FileInfo, error) {
 		}
 	}
 
+	if complete {
+		d.srvWrapper.setChildren(f.FileInfo.folderID(), googleapi.CombineFields(fields), children)
+	}
+
 	return files, nil
 }
 
+const prefetchConcurrency = 4
+
+// Prefetch loads the metadata of a directory subtree into the cache so that the subsequent Stat or
+// Open calls within it don't need to call the API. depth is the number of levels to load: 1 only
+// loads the direct children of the directory, a negative depth loads the whole subtree.
+// Nothing is done if the cache is disabled.
+func (d *GDriver) Prefetch(path string, depth int) error {
+	if !d.srvWrapper.UseCache || depth == 0 {
+		return nil
+	}
+
+	dir, err := d.getFile(path, listFields...)
+	if err != nil {
+		return err
+	}
+
+	if !dir.IsDir() {
+		return FileIsNotDirectoryError{Fi: dir}
+	}
+
+	childrenFields := googleapi.Field(fmt.Sprintf("files(%s,parents)", googleapi.CombineFields(fileInfoFields)))
+	lookupFields := []string{lookupFieldsDefault, googleapi.CombineFields(listFields)}
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+		prefetch func(folderID string, depth int)
+	)
+
+	semaphore := make(chan struct{}, prefetchConcurrency)
+
+	prefetch = func(folderID string, depth int) {
+		defer wg.Done()
+
+		semaphore <- struct{}{}
+		children, err := d.srvWrapper.listAllChildren(folderID, childrenFields)
+		<-semaphore
+
+		if err != nil {
+			errMu.Lock()
+			if firstErr == nil {
+				firstErr = &DriveAPICallError{Err: err}
+			}
+			errMu.Unlock()
+
+			return
+		}
+
+		d.srvWrapper.primeFolderCache(folderID, children, lookupFields...)
+
+		if depth == 1 {
+			return
+		}
+
+		for _, child := range children {
+			if child.MimeType == mimeTypeFolder {
+				wg.Add(1)
+
+				go prefetch(child.Id, depth-1)
+			}
+		}
+	}
+
+	wg.Add(1)
+
+	go prefetch(dir.folderID(), depth)
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// ExistsIn checks which of the provided names exist in a directory. The directory is listed once instead of
+// looking up each name, which makes it much cheaper than calling Stat for each file.
+func (d *GDriver) ExistsIn(dirPath string, names []string) (map[string]bool, error) {
+	dir, err := d.getFile(dirPath, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dir.IsDir() {
+		return nil, FileIsNotDirectoryError{Fi: dir}
+	}
+
+	children, err := d.srvWrapper.listAllChildren(dir.folderID(), "files(name)")
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	present := make(map[string]bool, len(children))
+	for _, child := range children {
+		present[child.Name] = true
+	}
+
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = present[sanitizeName(name)]
+	}
+
+	return exists, nil
+}
+
+// ListRich lists the children of a directory as drive.File objects, with the requested file fields in
+// addition to their ID, name and mime type, like "permissions" or "capabilities". This avoids fetching
+// each child separately, but heavy fields like permissions can make the listing much bigger.
+func (d *GDriver) ListRich(dirPath string, fields ...googleapi.Field) ([]*drive.File, error) {
+	dir, err := d.getFile(dirPath, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dir.IsDir() {
+		return nil, FileIsNotDirectoryError{Fi: dir}
+	}
+
+	fileFields := googleapi.CombineFields(append([]googleapi.Field{"id", "name", "mimeType"}, fields...))
+
+	files, err := d.srvWrapper.listAllChildren(dir.folderID(), googleapi.Field(fmt.Sprintf("files(%s)", fileFields)))
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	if d.GoogleDocPolicy == GoogleDocSkipInListings {
+		visible := files[:0]
+
+		for _, file := range files {
+			if !d.hiddenInListings(file) {
+				visible = append(visible, file)
+			}
+		}
+
+		files = visible
+	}
+
+	return files, nil
+}
+
+// ListMatching lists the files and directories of a directory whose name contains a pattern. Google Drive
+// only matches names by prefix for each word, so "file" matches "my file" but not "myfile".
+func (d *GDriver) ListMatching(dirPath, pattern string) ([]*FileInfo, error) {
+	dir, err := d.getFile(dirPath, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dir.IsDir() {
+		return nil, FileIsNotDirectoryError{Fi: dir}
+	}
+
+	query := fmt.Sprintf(
+		"'%s' in parents and name contains '%s' and trashed = false",
+		dir.folderID(),
+		escapeQuery(pattern),
+	)
+
+	files, err := d.srvWrapper.listAll(query, listFields...)
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	list := make([]*FileInfo, 0, len(files))
+	for _, file := range files {
+		if d.hiddenInListings(file) {
+			continue
+		}
+
+		list = append(list, d.newFileInfo(file, dir.Path()))
+	}
+
+	return list, nil
+}
+
 // Mkdir creates a directory in the filesystem, return an error if any
 // happens.
 func (d *GDriver) Mkdir(path string, perm os.FileMode) error {
@@ -208,23 +623,100 @@ func (d *GDriver) Mkdir(path string, perm os.FileMode) error {
 
 // MkdirAll creates a directory path and all parents that does not exist
 // yet.
-func (d *GDriver) MkdirAll(path string, _ os.FileMode) error {
-	_, err := d.makeDirectoryByParts(strings.FieldsFunc(path, isPathSeperator))
+func (d *GDriver) MkdirAll(path string, perm os.FileMode) error {
+	return pathError("mkdir", path, d.mkdirAll(path, perm))
+}
+
+func (d *GDriver) mkdirAll(path string, perm os.FileMode) error {
+	pathParts, err := splitPath(d.creationPath(path))
+	if err != nil {
+		return err
+	}
+
+	_, _, err = d.makeDirectoryByPartsEx(pathParts, perm)
 
 	return err
 }
 
+// MkdirAllEx creates a directory path and all parents that does not exist yet. It returns the paths of
+// the directories that were created, from the top-most one, and the directory at the end of the path.
+func (d *GDriver) MkdirAllEx(path string, perm os.FileMode) ([]string, *FileInfo, error) {
+	pathParts, err := splitPath(d.creationPath(path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	leaf, created, err := d.makeDirectoryByPartsEx(pathParts, perm)
+	if err != nil {
+		return created, nil, err
+	}
+
+	if !leaf.IsDir() {
+		return created, nil, &FileIsNotDirectoryError{Fi: leaf}
+	}
+
+	return created, leaf, nil
+}
+
+// EnsureDir returns the directory at path, creating it and its missing parents if needed. created tells
+// whether the directory itself was created by this call.
+func (d *GDriver) EnsureDir(path string) (fi *FileInfo, created bool, err error) {
+	createdDirs, fi, err := d.MkdirAllEx(path, 0)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Once a directory is created, all its descendants in the path are too
+	return fi, len(createdDirs) > 0, nil
+}
+
+// EnsureFile returns the file at path, creating it as an empty file with its missing parent directories if
+// it doesn't exist. created tells whether the file was created by this call.
+func (d *GDriver) EnsureFile(path string) (fi *FileInfo, created bool, err error) {
+	path = d.creationPath(path)
+
+	fi, err = d.getFile(path, listFields...)
+
+	switch {
+	case err == nil:
+		if fi.IsDir() {
+			return nil, false, FileIsDirectoryError{Path: fi.Path()}
+		}
+
+		return fi, false, nil
+	case IsNotExist(err):
+		fi, err = d.createFile(path)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return fi, true, nil
+	default:
+		return nil, false, err
+	}
+}
+
 func (d *GDriver) makeDirectoryByParts(pathParts []string) (*FileInfo, error) {
+	dir, _, err := d.makeDirectoryByPartsEx(pathParts, 0)
+
+	return dir, err
+}
+
+// makeDirectoryByPartsEx creates the missing directories of a path and also returns the paths of the
+// directories it created. The created directories get the perm mode, unless it's 0.
+func (d *GDriver) makeDirectoryByPartsEx(pathParts []string, perm os.FileMode) (*FileInfo, []string, error) {
 	parentNode := d.rootNode
 
+	var created []string
+
 	for i := 0; i < len(pathParts); i++ {
-		files, err := d.srvWrapper.getFileByFolderAndName(parentNode.file.Id, pathParts[i], listFields...)
+		files, err := d.srvWrapper.getFileByFolderAndName(parentNode.folderID(), pathParts[i], listFields...)
 		if err != nil {
-			return nil, &DriveAPICallError{Err: err}
+			return nil, created, &DriveAPICallError{Err: err}
 		}
 
 		if files == nil {
-			return nil, &NoFileInformationError{Fi: parentNode, Path: path.Join(pathParts[:i+1]...)}
+			return nil, created, &NoFileInformationError{Fi: parentNode, Path: path.Join(pathParts[:i+1]...)}
 		}
 
 		switch len(files.Files) {
@@ -232,43 +724,41 @@ func (d *GDriver) makeDirectoryByParts(pathParts []string) (*FileInfo, error) {
 			{
 				// File not found => create directory
 				if !parentNode.IsDir() {
-					return nil, FileIsNotDirectoryError{
+					return nil, created, FileIsNotDirectoryError{
 						Fi:   parentNode,
 						Path: path.Join(pathParts[:i]...),
 					}
 				}
 				var createdDir *drive.File
 
-				createdDir, err = d.srvWrapper.createFile(
-					parentNode.file.Id,
+				createdDir, err = d.srvWrapper.createFolder(
+					parentNode.folderID(),
 					pathParts[i],
-					mimeTypeFolder,
+					perm,
 					fileInfoFields...,
 				)
 				if err != nil {
-					return nil, &DriveAPICallError{Err: err}
+					return nil, created, &DriveAPICallError{Err: err}
 				}
 
 				parentNode = &FileInfo{
 					file:       createdDir,
 					parentPath: path.Join(pathParts[:i]...),
 				}
+				created = append(created, path.Join(pathParts[:i+1]...))
 			}
 		case 1:
 			{
-				parentNode = &FileInfo{
-					file:       files.Files[0],
-					parentPath: path.Join(pathParts[:i]...),
-				}
+				parentNode = d.newFileInfo(files.Files[0], path.Join(pathParts[:i]...))
 			}
 		default:
 			{
-				return nil, &FileHasMultipleEntriesError{Path: path.Join(pathParts[:i+1]...)}
+				return nil, created, &FileHasMultipleEntriesError{Path: path.Join(pathParts[:i+1]...)}
 			}
 		}
 	}
 
-	return parentNode, nil
+	return parentNode, created, nil
 }
 
 // DeleteDirectory will delete a directory and its descendants
@@ -282,7 +772,7 @@ func (d *GDriver) DeleteDirectory(path string) error {
 		return FileIsNotDirectoryError{Fi: file}
 	}
 
-	if file == d.rootNode {
+	if d.isRoot(file) {
 		return ErrForbiddenOnRoot
 	}
 
@@ -299,12 +789,16 @@ func (d *GDriver) deleteFile(fi *FileInfo) error {
 
 // RemoveAll will delete a File or directory, if directory it will also delete its descendants
 func (d *GDriver) RemoveAll(path string) error {
+	return pathError("remove", path, d.removeAll(path))
+}
+
+func (d *GDriver) removeAll(path string) error {
 	file, err := d.getFile(path)
 	if err != nil {
 		return err
 	}
 
-	if file == d.rootNode {
+	if d.isRoot(file) {
 		return ErrForbiddenOnRoot
 	}
 
@@ -317,14 +811,87 @@ func (d *GDriver) Remove(path string) error {
 	return d.RemoveAll(path)
 }
 
+// RemoveIfExists removes a file or directory like Remove, except that a path that doesn't exist isn't an
+// error, even if it disappears between its lookup and its removal. removed tells whether something was
+// actually removed, which makes cleanups idempotent.
+func (d *GDriver) RemoveIfExists(path string) (removed bool, err error) {
+	err = d.RemoveAll(path)
+
+	switch {
+	case err == nil:
+		return true, nil
+	case IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// DirExists checks if a path exists and is a directory, like afero.DirExists. A missing path isn't an error.
+func (d *GDriver) DirExists(path string) (bool, error) {
+	fi, err := d.getFile(path, listFields...)
+
+	switch {
+	case err == nil:
+		return fi.IsDir(), nil
+	case IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// FileExists checks if a path exists and is a regular file. A missing path isn't an error.
+func (d *GDriver) FileExists(path string) (bool, error) {
+	fi, err := d.getFile(path, listFields...)
+
+	switch {
+	case err == nil:
+		return !fi.IsDir(), nil
+	case IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
 func (d *GDriver) getFileReader(fi *FileInfo, offset int64) (io.ReadCloser, error) {
+	return d.getFileReaderContext(context.Background(), fi, offset)
+}
+
+// getFileReaderContext opens a download stream that is aborted when ctx is done
+func (d *GDriver) getFileReaderContext(ctx context.Context, fi *FileInfo, offset int64) (io.ReadCloser, error) {
+	return d.getFileRangeReader(ctx, fi, offset, -1)
+}
+
+// limitedReadCloser reads at most a given amount of bytes from a stream
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// getFileRangeReader opens a download stream of length bytes starting at offset, up to the end of the file if
+// length is negative
+func (d *GDriver) getFileRangeReader(ctx context.Context, fi *FileInfo, offset, length int64) (io.ReadCloser, error) {
 	if fi.IsDir() {
 		return nil, FileIsDirectoryError{Path: fi.Path()}
 	}
 
-	request := d.srv.Files.Get(fi.file.Id)
+	if isGoogleDoc(fi.file) {
+		reader, err := d.getGoogleDocReader(ctx, fi, offset)
+		if err != nil || length < 0 {
+			return reader, err
+		}
 
-	if offset > 0 {
+		return &limitedReadCloser{Reader: io.LimitReader(reader, length), Closer: reader}, nil
+	}
+
+	request := d.srv.Files.Get(fi.file.Id).SupportsAllDrives(true).Context(ctx)
+
+	switch {
+	case length >= 0:
+		request.Header().Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
 		request.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
 
@@ -337,17 +904,76 @@ func (d *GDriver) getFileReader(fi *FileInfo, offset int64) (io.ReadCloser, erro
 	return response.Body, nil
 }
 
-func (d *GDriver) getFileWriter(fi *FileInfo) (io.WriteCloser, chan error, error) {
+// writePipeBufferSizeDefault is the default size of the buffer placed in front of the upload pipe
+const writePipeBufferSizeDefault = 32 * 1024
+
+// uploadResult receives the file returned by an upload, it is set before the error of the upload is sent
+type uploadResult struct {
+	file *drive.File
+}
+
+// uploadFunc performs an upload whose content is read from media
+type uploadFunc func(ctx context.Context, media io.Reader) (*drive.File, error)
+
+// updateUpload returns the upload replacing the content of an existing file
+func (d *GDriver) updateUpload(fi *FileInfo) uploadFunc {
+	return func(ctx context.Context, media io.Reader) (*drive.File, error) {
+		return d.srv.Files.Update(fi.file.Id, nil).
+			Fields(fileInfoFields...).
+			SupportsAllDrives(true).
+			Media(media).
+			Context(ctx).
+			Do()
+	}
+}
+
+// getFileWriter starts the upload of the content of an existing file and returns the writer feeding it.
+// When result isn't nil, it receives the updated file once the upload succeeds.
+func (d *GDriver) getFileWriter(
+	fi *FileInfo,
+	result *uploadResult,
+) (io.WriteCloser, chan error, context.CancelFunc, error) {
 	if fi == nil {
-		return nil, nil, errInternalNil
+		return nil, nil, nil, errInternalNil
+	}
+
+	return d.startUpload(fi, result, d.updateUpload(fi))
+}
+
+// startUpload starts an upload and returns the writer feeding it. Each write to the underlying pipe blocks
+// until the uploader consumes it, so unless WritePipeBufferSize is 0, the pipe is wrapped into a buffer
+// that coalesces small writes.
+func (d *GDriver) startUpload(
+	fi *FileInfo,
+	result *uploadResult,
+	upload uploadFunc,
+) (io.WriteCloser, chan error, context.CancelFunc, error) {
+	spill, err := d.newUploadSpill()
+	if err != nil {
+		return nil, nil, nil, err
 	}
+
 	// open a pipe and use the writer part for Write()
-	reader, writer := io.Pipe()
+	reader, pipeWriter := io.Pipe()
+
+	var writer io.WriteCloser = pipeWriter
+	if d.WritePipeBufferSize > 0 {
+		writer = iohelper.NewBufferedWriteCloser(pipeWriter, d.WritePipeBufferSize)
+	}
+
+	// the channel is buffered so that the uploader doesn't stay stuck if nobody waits for it anymore
+	endErr := make(chan error, 1)
 
-	endErr := make(chan error)
+	ctx, cancel := context.WithCancel(d.ctx)
+
+	// The uploader might be waiting for some content when the upload is canceled
+	stopOnCancel := context.AfterFunc(ctx, func() { _ = reader.CloseWithError(ctx.Err()) })
 
 	// the channel is used to notify the Close() or Write() function if something goes wrong
 	go func() {
+		defer cancel()
+		defer stopOnCancel()
+
 		if d.LogReaderAndWriters {
 			d.Logger.Info("Starting the writer",
 				"fileId", fi.file.Id,
@@ -355,7 +981,14 @@ func (d *GDriver) getFileWriter(fi *FileInfo) (io.WriteCloser, chan error, error
 			)
 		}
 
-		_, err := d.srv.Files.Update(fi.file.Id, nil).Fields(fileInfoFields...).Media(reader).Do()
+		file, err := d.runUpload(ctx, upload, reader, spill)
+
+		// Any subsequent write will fail instead of blocking forever
+		_ = reader.Close()
+
+		if err == nil && result != nil {
+			result.file = file
+		}
 
 		endErr <- err
 
@@ -367,16 +1000,35 @@ func (d *GDriver) getFileWriter(fi *FileInfo) (io.WriteCloser, chan error, error
 		}
 	}()
 
-	return writer, endErr, nil
+	return writer, endErr, cancel, nil
 }
 
 func (d *GDriver) getFileInfoFromPath(path string) (*FileInfo, error) {
 	return d.getFile(path, listFields...)
 }
 
+// creationPath gives the path where a file or directory should be created, bare names are placed in the
+// DefaultParent directory
+func (d *GDriver) creationPath(p string) string {
+	if d.DefaultParent == "" || strings.HasPrefix(p, "/") {
+		return p
+	}
+
+	parts, err := splitPath(p)
+	if err != nil || len(parts) != 1 {
+		return p
+	}
+
+	return path.Join(d.DefaultParent, parts[0])
+}
+
 // createFile creates a new file
 func (d *GDriver) createFile(filePath string) (*FileInfo, error) {
-	pathParts := strings.FieldsFunc(filePath, isPathSeperator)
+	pathParts, err := splitPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
 	amountOfParts := len(pathParts)
 
 	if amountOfParts <= 0 {
@@ -393,7 +1045,7 @@ func (d *GDriver) createFile(filePath string) (*FileInfo, error) {
 		existentFile = nil
 	}
 
-	if existentFile == d.rootNode {
+	if existentFile != nil && d.isRoot(existentFile) {
 		return nil, ErrForbiddenOnRoot
 	}
 
@@ -415,115 +1067,465 @@ func (d *GDriver) createFile(filePath string) (*FileInfo, error) {
 		}
 	}
 
-	file, err := d.srvWrapper.createFile(parentNode.file.Id, pathParts[amountOfParts-1], mimeTypeFile, fileInfoFields...)
+	file, err := d.srvWrapper.createFile(parentNode.folderID(), pathParts[amountOfParts-1], mimeTypeFile, fileInfoFields...)
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	fi := &FileInfo{
+		file:       file,
+		parentPath: path.Join(pathParts[:amountOfParts-1]...),
+	}
+
+	if err := d.applyDefaultPermissions(fi); err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+// Rename moves a File or directory to a new path
+func (d *GDriver) Rename(oldPath, newPath string) error {
+	return pathError("rename", oldPath, d.rename(oldPath, newPath))
+}
+
+func (d *GDriver) rename(oldPath, newPath string) error {
+	pathParts, err := splitPath(newPath)
+	if err != nil {
+		return err
+	}
+
+	amountOfParts := len(pathParts)
+
+	if amountOfParts <= 0 {
+		return ErrEmptyPath
+	}
+
+	file, err := d.getFile(oldPath, "files(id,parents)")
+	if err != nil {
+		return err
+	}
+
+	if d.isRoot(file) {
+		return ErrForbiddenOnRoot
+	}
+
+	parentNode := d.rootNode
+
+	if amountOfParts > 1 {
+		dir, errMkDir := d.makeDirectoryByParts(pathParts[:amountOfParts-1])
+		if errMkDir != nil {
+			return errMkDir
+		}
+
+		parentNode = dir
+		if !parentNode.IsDir() {
+			// Was: return fmt.Errorf("unable to create File in `%s': `%s' is not a directory",
+			// path.Join(pathParts[:amountOfParts-1]...), parentNode.Name())
+			return &FileIsNotDirectoryError{Fi: parentNode}
+		}
+	}
+
+	_, err = d.srv.Files.Update(file.file.Id, &drive.File{
+		Name: sanitizeName(pathParts[amountOfParts-1]),
+	}).
+		AddParents(parentNode.folderID()).
+		RemoveParents(path.Join(file.file.Parents...)).
+		Fields(fileInfoFields...).
+		SupportsAllDrives(true).
+		Do()
+
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	// Both the source and the target folders have changed
+	d.srvWrapper.invalidateFile(file.file)
+	d.srvWrapper.cache.CleanupByPrefix(parentNode.folderID() + "-")
+
+	return nil
+}
+
+// getFileAndFolder resolves a file and the directory it should be added to or removed from
+func (d *GDriver) getFileAndFolder(filePath, folderPath string) (*FileInfo, *FileInfo, error) {
+	file, err := d.getFile(filePath, "files(id,parents,mimeType)")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if d.isRoot(file) {
+		return nil, nil, ErrForbiddenOnRoot
+	}
+
+	folder, err := d.getFile(folderPath, listFields...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !folder.IsDir() {
+		return nil, nil, &FileIsNotDirectoryError{Fi: folder}
+	}
+
+	return file, folder, nil
+}
+
+// AddToFolder adds a directory to the parents of a file, so that it also shows up in this directory. Items
+// of a shared drive can only have a single parent, so this fails with ErrSingleParent on shared drives.
+func (d *GDriver) AddToFolder(filePath, folderPath string) error {
+	if d.srvWrapper.sharedDriveID != "" {
+		return ErrSingleParent
+	}
+
+	file, folder, err := d.getFileAndFolder(filePath, folderPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.srv.Files.Update(file.file.Id, &drive.File{}).
+		AddParents(folder.folderID()).
+		SupportsAllDrives(true).
+		Do()
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	d.srvWrapper.invalidateFile(file.file)
+	d.srvWrapper.cache.CleanupByPrefix(fmt.Sprintf("%s-", folder.folderID()))
+
+	return nil
+}
+
+// RemoveFromFolder removes a directory from the parents of a file that has several of them. Removing the last
+// parent of a file fails with ErrSingleParent.
+func (d *GDriver) RemoveFromFolder(filePath, folderPath string) error {
+	file, folder, err := d.getFileAndFolder(filePath, folderPath)
+	if err != nil {
+		return err
+	}
+
+	inFolder := false
+
+	for _, p := range file.file.Parents {
+		if p == folder.folderID() {
+			inFolder = true
+		}
+	}
+
+	if !inFolder {
+		folderPath, _ = normalizePath(folderPath)
+
+		return &FileNotExistError{Path: path.Join(folderPath, path.Base(filePath))}
+	}
+
+	if len(file.file.Parents) == 1 {
+		return ErrSingleParent
+	}
+
+	_, err = d.srv.Files.Update(file.file.Id, &drive.File{}).
+		RemoveParents(folder.folderID()).
+		SupportsAllDrives(true).
+		Do()
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	d.srvWrapper.invalidateFile(file.file)
+
+	return nil
+}
+
+func (d *GDriver) trashPath(path string) error {
+	fi, err := d.getFile(path)
+	if err != nil {
+		return err
+	}
+
+	return d.srvWrapper.deleteFile(fi.file, true)
+}
+
+// ListTrash lists the contents of the trash
+// if you specify directories it will only list the trash contents of the specified directories.
+// At most count entries are returned, all of them if count is negative or 0.
+func (d *GDriver) ListTrash(filePath string, count int) ([]*FileInfo, error) {
+	file, err := d.getFile(filePath, "files(id,name)")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*FileInfo
+
+	pageToken := ""
+
+	for count <= 0 || len(list) < count {
+		pageSize := int64(count - len(list))
+		if pageSize > filesListPageSizeMax || pageSize <= 0 {
+			pageSize = filesListPageSizeMax
+		}
+
+		// no directories specified
+		call := d.srvWrapper.filesList().Q("trashed = true").Fields(
+			googleapi.Field(fmt.Sprintf("files(%s,parents)", googleapi.CombineFields(fileInfoFields))),
+			"nextPageToken",
+		).PageSize(pageSize)
+
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		files, err := d.listPage(call)
+		if err != nil {
+			return nil, &DriveAPICallError{Err: err}
+		}
+
+		for i := 0; i < len(files.Files) && (count <= 0 || len(list) < count); i++ {
+			// determinate the parent of this File
+			inRoot, parentPath, err := isInRoot(d.srv, file.file.Id, files.Files[i], "")
+			if err != nil {
+				return nil, err
+			}
+
+			if inRoot {
+				list = append(
+					list,
+					&FileInfo{
+						file:       files.Files[i],
+						parentPath: path.Join(file.Path(), parentPath),
+					},
+				)
+			}
+		}
+
+		pageToken = files.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return list, nil
+}
+
+// Restore brings back a trashed file or directory to its path. As the trashed files can't be found by their
+// path, the trash is searched for the ones with the same name within the root directory. A FileExistError is
+// returned if a file already uses the path, a FileHasMultipleEntriesError if several trashed files match it,
+// in which case RestoreByID can be used with an ID returned by ListTrash.
+func (d *GDriver) Restore(filePath string) error {
+	normalized, err := normalizePath(filePath)
+	if err != nil {
+		return err
+	}
+
+	if normalized == "" {
+		return ErrForbiddenOnRoot
+	}
+
+	if _, err := d.getFile(normalized); err == nil {
+		return &FileExistError{Path: normalized}
+	} else if !IsNotExist(err) {
+		return err
+	}
+
+	query := fmt.Sprintf("trashed = true and name = '%s'", escapeQuery(path.Base(normalized)))
+
+	files, err := d.srvWrapper.listAll(query, "files(id,name,mimeType,parents)")
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	var matches []*drive.File
+
+	for _, file := range files {
+		inRoot, parentPath, err := isInRoot(d.srv, d.rootNode.file.Id, file, "")
+		if err != nil {
+			return err
+		}
+
+		if inRoot && path.Join(parentPath, file.Name) == normalized {
+			matches = append(matches, file)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return &FileNotExistError{Path: normalized}
+	case 1:
+		return d.restoreFile(matches[0])
+	default:
+		return &FileHasMultipleEntriesError{Path: normalized}
+	}
+}
+
+// RestoreByID brings back a trashed file or directory to its location. A FileNotExistError is returned if it
+// isn't located within the root directory.
+func (d *GDriver) RestoreByID(id string) error {
+	file, err := d.srv.Files.Get(id).Fields("id,mimeType,parents").SupportsAllDrives(true).Do()
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	inRoot, _, err := isInRoot(d.srv, d.rootNode.file.Id, file, "")
+	if err != nil {
+		return err
+	}
+
+	if !inRoot {
+		return &FileNotExistError{Path: id}
+	}
+
+	return d.restoreFile(file)
+}
+
+// restoreFile takes a file out of the trash
+func (d *GDriver) restoreFile(file *drive.File) error {
+	// Trashed has to be forced as false is omitted by default
+	_, err := d.srv.Files.Update(file.Id, &drive.File{Trashed: false, ForceSendFields: []string{"Trashed"}}).
+		SupportsAllDrives(true).
+		Do()
 	if err != nil {
-		return nil, &DriveAPICallError{Err: err}
+		return &DriveAPICallError{Err: err}
 	}
 
-	return &FileInfo{
-		file:       file,
-		parentPath: path.Join(pathParts[:amountOfParts-1]...),
-	}, nil
+	d.srvWrapper.invalidateFile(file)
+
+	return nil
 }
 
-// Rename moves a File or directory to a new path
-func (d *GDriver) Rename(oldPath, newPath string) error {
-	pathParts := strings.FieldsFunc(newPath, isPathSeperator)
-	amountOfParts := len(pathParts)
+// EmptyTrash permanently deletes the trashed files, they can't be restored afterwards. When the root directory
+// is the root of the drive, the whole trash of the drive is emptied, or the one of the shared drive. Otherwise,
+// only the trashed files within the root directory are deleted, one by one, as listed by ListTrash.
+func (d *GDriver) EmptyTrash() error {
+	driveRoot, err := d.getDriveRootNode()
+	if err != nil {
+		return err
+	}
 
-	if amountOfParts <= 0 {
-		return ErrEmptyPath
+	if d.isRoot(driveRoot) {
+		return d.srvWrapper.emptyTrash()
 	}
 
-	file, err := d.getFile(oldPath, "files(id,parents)")
+	trashed, err := d.ListTrash("", 0)
 	if err != nil {
 		return err
 	}
 
-	if file == d.rootNode {
-		return ErrForbiddenOnRoot
+	for _, fi := range trashed {
+		// The content of a trashed directory is deleted along with it
+		if err := d.srvWrapper.deleteFile(fi.file, false); err != nil && !isNotFoundError(err) {
+			return err
+		}
 	}
 
-	parentNode := d.rootNode
+	return nil
+}
 
-	if amountOfParts > 1 {
-		dir, errMkDir := d.makeDirectoryByParts(pathParts[:amountOfParts-1])
-		if errMkDir != nil {
-			return errMkDir
-		}
+// ListRecent lists the most recently modified or viewed files that are within the root directory, most
+// recent first. Folders and trashed files are excluded. Checking that a file is within the root directory
+// requires fetching its ancestors, so this gets more expensive as the files are deep in the tree.
+func (d *GDriver) ListRecent(limit int) ([]*FileInfo, error) {
+	return d.findInRoot(fmt.Sprintf("mimeType != '%s' and trashed = false", mimeTypeFolder), "recency desc", limit)
+}
 
-		parentNode = dir
-		if !parentNode.IsDir() {
-			// Was: return fmt.Errorf("unable to create File in `%s': `%s' is not a directory",
-			// path.Join(pathParts[:amountOfParts-1]...), parentNode.Name())
-			return &FileIsNotDirectoryError{Fi: parentNode}
-		}
+// Search lists up to count files and directories within the root directory that match a query, all of them if
+// count is negative or 0. The query uses the search syntax of Google Drive, like
+// "modifiedTime > '2024-01-01T00:00:00' and fullText contains 'invoice'", and is passed as is: it is an escape
+// hatch for what can't be expressed with paths. Trashed files are only excluded if the query says so, with
+// "trashed = false". As for ListRecent, this gets more expensive as the files are deep in the tree.
+func (d *GDriver) Search(query string, count int) ([]*FileInfo, error) {
+	if count <= 0 {
+		count = math.MaxInt
 	}
 
-	_, err = d.srv.Files.Update(file.file.Id, &drive.File{
-		Name: sanitizeName(pathParts[amountOfParts-1]),
-	}).
-		AddParents(parentNode.file.Id).
-		RemoveParents(path.Join(file.file.Parents...)).
-		Fields(fileInfoFields...).Do()
+	return d.findInRoot(query, "", count)
+}
 
-	if err != nil {
-		return &DriveAPICallError{Err: err}
-	}
+// FindByProperty lists the files and directories within the root directory that have a public property
+// with the given value, like the ones set by Chmod. At most limit files are returned.
+func (d *GDriver) FindByProperty(key, value string, limit int) ([]*FileInfo, error) {
+	query := fmt.Sprintf(
+		"properties has { key='%s' and value='%s' } and trashed = false",
+		escapeQuery(key),
+		escapeQuery(value),
+	)
 
-	return nil
+	return d.findInRoot(query, "", limit)
 }
 
-func (d *GDriver) trashPath(path string) error {
-	fi, err := d.getFile(path)
-	if err != nil {
-		return err
-	}
+// viewedSince is a date before any file could be viewed, the searches use it to only match the viewed files
+const viewedSince = "1970-01-01T00:00:00"
 
-	return d.srvWrapper.deleteFile(fi.file, true)
+// ListRecentlyViewed lists the files within the root directory that the account viewed, most recently viewed
+// first. Folders and trashed files are excluded. Their ViewedByMeTime is always available, even without
+// FetchViewedByMe. Like for ListRecent, this gets more expensive as the files are deep in the tree.
+func (d *GDriver) ListRecentlyViewed(limit int) ([]*FileInfo, error) {
+	query := fmt.Sprintf("mimeType != '%s' and trashed = false and viewedByMeTime > '%s'", mimeTypeFolder, viewedSince)
+
+	return d.findInRoot(query, "viewedByMeTime desc", limit, viewedFields...)
 }
 
-// ListTrash lists the contents of the trash
-// if you specify directories it will only list the trash contents of the specified directories
-func (d *GDriver) ListTrash(filePath string, _ int) ([]*FileInfo, error) {
-	file, err := d.getFile(filePath, "files(id,name)")
-	if err != nil {
-		return nil, err
-	}
+// findInRoot lists the files matching a query that are within the root directory, up to limit files. The extra
+// fields are fetched in addition to the usual ones.
+func (d *GDriver) findInRoot(query string, orderBy string, limit int, extra ...googleapi.Field) ([]*FileInfo, error) {
+	var list []*FileInfo
 
-	// no directories specified
-	files, err := d.srv.Files.List().Q("trashed = true").Fields(
-		googleapi.Field(fmt.Sprintf("files(%s,parents)", googleapi.CombineFields(fileInfoFields))),
-	).Do()
-	if err != nil {
-		return nil, &DriveAPICallError{Err: err}
+	pageSize := limit
+	if pageSize > filesListPageSizeMax {
+		pageSize = filesListPageSizeMax
 	}
 
-	var list []*FileInfo
+	pageToken := ""
+
+	for len(list) < limit {
+		call := d.srvWrapper.filesList().
+			Q(query).
+			Fields(d.fileFields(append([]googleapi.Field{"parents"}, extra...)...), "nextPageToken").
+			PageSize(int64(pageSize))
+
+		if orderBy != "" {
+			call = call.OrderBy(orderBy)
+		}
 
-	for i := 0; i < len(files.Files); i++ {
-		// determinate the parent of this File
-		inRoot, parentPath, err := isInRoot(d.srv, file.file.Id, files.Files[i], "")
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		files, err := d.listPage(call)
 		if err != nil {
-			return nil, err
+			return list, &DriveAPICallError{Err: err}
 		}
 
-		if inRoot {
-			list = append(
-				list,
-				&FileInfo{
-					file:       files.Files[i],
-					parentPath: path.Join(file.Path(), parentPath),
-				},
-			)
+		for _, file := range files.Files {
+			inRoot, parentPath, err := isInRoot(d.srv, d.rootNode.file.Id, file, "")
+			if err != nil {
+				return list, err
+			}
+
+			if inRoot && len(list) < limit {
+				list = append(list, &FileInfo{file: file, parentPath: parentPath})
+			}
+		}
+
+		pageToken = files.NextPageToken
+		if pageToken == "" {
+			break
 		}
 	}
 
 	return list, nil
 }
 
-func getRootNode(srv *drive.Service) (*FileInfo, error) {
-	root, err := srv.Files.Get("root").Fields(fileInfoFields...).Do()
+// escapeQuery escapes a value to be used within single quotes in a query
+func escapeQuery(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// getRootNode fetches the root folder of the user's drive, or of a shared drive if an ID is specified.
+// The root folder of a shared drive has the ID of the shared drive.
+func getRootNode(srv *drive.Service, sharedDriveID string) (*FileInfo, error) {
+	rootID := "root"
+	if sharedDriveID != "" {
+		rootID = sharedDriveID
+	}
+
+	root, err := srv.Files.Get(rootID).Fields(fileInfoFields...).SupportsAllDrives(true).Do()
 	if err != nil {
 		return nil, &DriveAPICallError{Err: err}
 	}
@@ -541,7 +1543,7 @@ func isInRoot(srv *drive.Service, rootID string, file *drive.File, basePath stri
 			return true, basePath, nil
 		}
 
-		parent, err := srv.Files.Get(parentID).Fields("id,name,parents").Do()
+		parent, err := srv.Files.Get(parentID).Fields("id,name,parents").SupportsAllDrives(true).Do()
 		if err != nil {
 			return false, "", &DriveAPICallError{Err: err}
 		}
@@ -554,12 +1556,28 @@ func isInRoot(srv *drive.Service, rootID string, file *drive.File, basePath stri
 	return false, "", nil
 }
 
+// newFileInfo creates the FileInfo of a file. When shortcuts are followed, a shortcut to a folder is
+// considered as a directory whose content is the content of the target folder.
+func (d *GDriver) newFileInfo(file *drive.File, parentPath string) *FileInfo {
+	fi := &FileInfo{file: file, parentPath: parentPath}
+
+	if d.FollowShortcuts && file.MimeType == mimeTypeShortcut && file.ShortcutDetails != nil &&
+		file.ShortcutDetails.TargetMimeType == mimeTypeFolder {
+		fi.target = file.ShortcutDetails.TargetId
+	}
+
+	return fi
+}
+
 func (d *GDriver) getFile(path string, fields ...googleapi.Field) (*FileInfo, error) {
 	return d.getFileOnRootNode(d.rootNode, path, fields...)
 }
 
 func (d *GDriver) getFileOnRootNode(rootNode *FileInfo, path string, fields ...googleapi.Field) (*FileInfo, error) {
-	spl := strings.FieldsFunc(path, isPathSeperator)
+	spl, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
 
 	return d.getFileByParts(rootNode, spl, fields...)
 }
@@ -572,23 +1590,40 @@ func (d *GDriver) getFileByParts(rootNode *FileInfo, pathParts []string, fields
 		return rootNode, nil
 	}
 
-	lastID := rootNode.file.Id
-	lastPart := amountOfParts - 1
-	var lastFile *drive.File
+	files, err := d.resolveParts(rootNode, pathParts, "", googleapi.Field(googleapi.CombineFields(fields)))
+	if err != nil {
+		return nil, err
+	}
+
+	return files[amountOfParts-1], nil
+}
 
-	requestedFields := googleapi.Field(googleapi.CombineFields(fields))
+// resolveParts looks up each part of a path and returns the FileInfo of every segment, from the first one to
+// the last one. Intermediate segments are fetched with intermediateFields, the last one with lastFields.
+func (d *GDriver) resolveParts(
+	rootNode *FileInfo,
+	pathParts []string,
+	intermediateFields googleapi.Field,
+	lastFields googleapi.Field,
+) ([]*FileInfo, error) {
+	amountOfParts := len(pathParts)
+	lastID := rootNode.folderID()
+	lastPart := amountOfParts - 1
+	resolved := make([]*FileInfo, 0, amountOfParts)
 
 	for i := 0; i < amountOfParts; i++ {
 		fileName := pathParts[i]
 
-		var queryFields googleapi.Field
+		queryFields := intermediateFields
 		if i == lastPart {
-			queryFields = requestedFields
-		} else {
-			queryFields = ""
+			queryFields = lastFields
 		}
 
 		files, err := d.srvWrapper.getFileByFolderAndName(lastID, fileName, queryFields)
+		if err == nil && (files == nil || len(files.Files) == 0) {
+			files, err = d.lookupRecentlyCreated(lastID, fileName, queryFields, files)
+		}
+
 		if err != nil {
 			return nil, &DriveAPICallError{Err: err}
 		}
@@ -601,14 +1636,69 @@ func (d *GDriver) getFileByParts(rootNode *FileInfo, pathParts []string, fields
 			return nil, &FileHasMultipleEntriesError{Path: path.Join(pathParts[:i+1]...)}
 		}
 
-		lastFile = files.Files[0]
-		lastID = lastFile.Id
+		fi := d.newFileInfo(files.Files[0], path.Join(pathParts[:i]...))
+		resolved = append(resolved, fi)
+		lastID = fi.folderID()
 	}
 
-	return &FileInfo{
-		file:       lastFile,
-		parentPath: path.Join(pathParts[:amountOfParts-1]...),
-	}, nil
+	return resolved, nil
+}
+
+// ResolvePath returns the FileInfo of each segment of a path, starting with the root directory and ending
+// with the file designated by the path. It is meant for breadcrumbs, as it doesn't require to stat each
+// ancestor separately.
+func (d *GDriver) ResolvePath(filePath string) ([]*FileInfo, error) {
+	pathParts, err := splitPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := googleapi.Field(googleapi.CombineFields(listFields))
+
+	files, err := d.resolveParts(d.rootNode, pathParts, fields, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]*FileInfo{d.rootNode}, files...), nil
+}
+
+// lookupRecentlyCreated retries the lookup of a file that wasn't found although it was recently created
+// through this driver, as Google Drive doesn't always list newly created files immediately.
+func (d *GDriver) lookupRecentlyCreated(
+	folderID string,
+	fileName string,
+	queryFields googleapi.Field,
+	files *drive.FileList,
+) (*drive.FileList, error) {
+	if d.CreateRetryMax <= 0 || !d.srvWrapper.recentlyCreated(folderID, fileName) {
+		return files, nil
+	}
+
+	backoff := d.CreateRetryBackoff
+
+	for attempt := 0; attempt < d.CreateRetryMax; attempt++ {
+		d.Logger.Debug("Recently created file not found, retrying",
+			"attempt", attempt+1,
+			"fileName", fileName,
+		)
+
+		time.Sleep(backoff)
+		backoff *= 2
+
+		d.srvWrapper.forgetFileByFolderAndName(folderID, fileName, queryFields)
+
+		var err error
+		if files, err = d.srvWrapper.getFileByFolderAndName(folderID, fileName, queryFields); err != nil {
+			return nil, err
+		}
+
+		if files != nil && len(files.Files) > 0 {
+			return files, nil
+		}
+	}
+
+	return files, nil
 }
 
 // Open a File for reading.
@@ -616,16 +1706,36 @@ func (d *GDriver) Open(name string) (afero.File, error) {
 	return d.OpenFile(name, os.O_RDONLY, 0)
 }
 
-// OpenFile opens a File in the traditional os.Open way
+// OpenFile opens a File in the traditional os.Open way. Like all the other operations, it treats "", "/"
+// and any path normalizing to them as the root directory. With os.O_CREATE, bare names are opened in the
+// DefaultParent directory.
 func (d *GDriver) OpenFile(path string, flag int, _ os.FileMode) (afero.File, error) {
-	if path == "" {
-		return nil, ErrEmptyPath
+	file, err := d.openFile(path, flag)
+	if err != nil {
+		return nil, pathError("open", path, err)
 	}
 
-	if flag&os.O_RDWR != 0 {
+	return file, nil
+}
+
+func (d *GDriver) openFile(path string, flag int) (afero.File, error) {
+	if flag&os.O_RDWR != 0 && !d.AllowReadWrite {
 		return nil, ErrReadAndWriteNotSupported
 	}
 
+	if flag&os.O_CREATE != 0 {
+		path = d.creationPath(path)
+	}
+
+	path, err := normalizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_RDWR != 0 {
+		return d.openFileReadWrite(path, flag)
+	}
+
 	// determinate existent status
 	file, err := d.getFileInfoFromPath(path)
 	var fileExists bool
@@ -635,6 +1745,10 @@ func (d *GDriver) OpenFile(path string, flag int, _ os.FileMode) (afero.File, er
 		{
 			fileExists = true
 
+			if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+				return nil, &FileExistError{Path: path}
+			}
+
 			if file.IsDir() {
 				return &File{
 					driver:   d,
@@ -662,6 +1776,8 @@ func (d *GDriver) OpenFile(path string, flag int, _ os.FileMode) (afero.File, er
 			}
 
 			fileExists = true
+			// A new file has no content to append to
+			flag &^= os.O_APPEND
 		} else {
 			return nil, &FileNotExistError{Path: path}
 		}
@@ -673,12 +1789,60 @@ func (d *GDriver) OpenFile(path string, flag int, _ os.FileMode) (afero.File, er
 			return nil, &FileNotExistError{Path: path}
 		}
 
+		if err := d.checkWritable(file, path); err != nil {
+			return nil, err
+		}
+
+		if flag&os.O_APPEND != 0 && file.Size() > 0 {
+			return d.openFileAppend(file, path)
+		}
+
 		return d.openFileWrite(file, path)
 	}
 
 	return d.openFileRead(file)
 }
 
+// checkWritable returns an error if the content of a file can't be replaced: a directory has no content and
+// uploading content would replace a native document with a regular file, unless OverwriteNativeDocs is set
+func (d *GDriver) checkWritable(fi *FileInfo, path string) error {
+	if fi.IsDir() {
+		return FileIsDirectoryError{Path: path}
+	}
+
+	if fi.IsNative() && !d.OverwriteNativeDocs {
+		return &NativeDocWriteError{Path: path, MimeType: fi.file.MimeType}
+	}
+
+	return nil
+}
+
+// openFileAppend opens a file for writing after its current content. As an upload replaces the whole
+// content, the current content is downloaded and written to the upload first.
+func (d *GDriver) openFileAppend(file *FileInfo, path string) (afero.File, error) {
+	reader, err := d.getFileReader(file, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	f, err := d.openFileWrite(file, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(f, reader); err != nil {
+		// The upload is canceled so that the file isn't replaced by a partial content
+		f.(*File).streamWriteCancel()
+		_ = f.Close()
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
 func (d *GDriver) openFileRead(file *FileInfo) (afero.File, error) {
 	reader, errReader := d.getFileReader(file, 0)
 
@@ -713,22 +1877,48 @@ func (d *GDriver) wrapWriteCloser(dst io.WriteCloser) (io.WriteCloser, error) {
 }
 
 func (d *GDriver) openFileWrite(file *FileInfo, path string) (afero.File, error) {
-	writer, endErr, err := d.getFileWriter(file)
+	f, err := d.openUpload(file, path, d.updateUpload(file))
 	if err != nil {
 		return nil, err
 	}
 
-	if writerBuffer, err := d.wrapWriteCloser(writer); err != nil {
-		writer = writerBuffer
+	return f, nil
+}
+
+// openUpload opens a file whose content is written to an upload
+func (d *GDriver) openUpload(file *FileInfo, path string, upload uploadFunc) (*File, error) {
+	result := &uploadResult{}
+
+	writer, endErr, cancel, err := d.startUpload(file, result, upload)
+	if err != nil {
+		return nil, err
 	}
 
-	return &File{
-		driver:         d,
-		Path:           path,
-		FileInfo:       file,
-		streamWrite:    writer,
-		streamWriteEnd: endErr,
-	}, nil
+	bufferedWriter, err := d.wrapWriteCloser(writer)
+	if err != nil {
+		// The upload is canceled before the pipe is closed so that no empty content gets uploaded
+		cancel()
+
+		_ = writer.Close()
+
+		return nil, err
+	}
+
+	writer = bufferedWriter
+
+	f := &File{
+		driver:            d,
+		Path:              path,
+		FileInfo:          file,
+		streamWrite:       writer,
+		streamWriteEnd:    endErr,
+		streamWriteCancel: cancel,
+		streamWriteResult: result,
+	}
+
+	d.registerWriter(f)
+
+	return f, nil
 }
 
 const createFileMode = os.FileMode(0777)
@@ -736,20 +1926,25 @@ const createFileMode = os.FileMode(0777)
 // Create creates a file in the filesystem, returning the file and an
 // error, if any happens.
 func (d *GDriver) Create(name string) (afero.File, error) {
-	file, err := d.OpenFile(name, os.O_CREATE, createFileMode)
+	file, err := d.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, createFileMode)
 	if err != nil {
 		return nil, err
 	}
 
 	if _, errWrite := file.Write([]byte{}); errWrite != nil {
-		return nil, err
+		return nil, errWrite
 	}
 
 	return file, nil
 }
 
-// Chmod changes the mode of the named file to mode.
+// Chmod changes the mode of the named file to mode. Only the permission bits are stored, they are then
+// reported by the Mode of its FileInfo.
 func (d *GDriver) Chmod(path string, mode os.FileMode) error {
+	return pathError("chmod", path, d.chmod(path, mode))
+}
+
+func (d *GDriver) chmod(path string, mode os.FileMode) error {
 	fi, err := d.getFile(path)
 	if err != nil {
 		return err
@@ -757,29 +1952,128 @@ func (d *GDriver) Chmod(path string, mode os.FileMode) error {
 
 	_, err = d.srv.Files.Update(fi.file.Id, &drive.File{
 		Properties: map[string]string{
-			"ftp_file_mode": fmt.Sprintf("%d", mode),
+			fileModeProperty: fmt.Sprintf("%d", mode.Perm()),
 		},
-	}).Do()
+	}).SupportsAllDrives(true).Do()
 
 	if err != nil {
 		return &DriveAPICallError{Err: err}
 	}
 
+	// The cached lookup still has the previous mode
+	d.srvWrapper.invalidateFile(fi.file)
+
 	return nil
 }
 
-// Chtimes changes the access and modification times of the named file
+// Chtimes changes the access and modification times of the named file. Google Drive has no access time, so
+// it is stored in a property and reported by FileInfo.AccessTime. A zero time leaves the matching time as is.
 func (d *GDriver) Chtimes(path string, atime time.Time, mTime time.Time) error {
+	return pathError("chtimes", path, d.chtimes(path, atime, mTime))
+}
+
+func (d *GDriver) chtimes(path string, atime time.Time, mTime time.Time) error {
+	fi, err := d.getFile(path)
+	if err != nil {
+		return err
+	}
+
+	patch := &drive.File{}
+
+	if !mTime.IsZero() {
+		patch.ModifiedTime = mTime.Format(time.RFC3339)
+	}
+
+	if !atime.IsZero() {
+		patch.Properties = map[string]string{
+			accessTimeProperty: atime.Format(time.RFC3339),
+		}
+	}
+
+	_, err = d.srv.Files.Update(fi.file.Id, patch).SupportsAllDrives(true).Do()
+
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	d.srvWrapper.invalidateFile(fi.file)
+
+	return nil
+}
+
+// SetOriginalFilename sets the original file name of a file. It can also be set at creation time with
+// CreateWith.
+func (d *GDriver) SetOriginalFilename(path string, originalFilename string) error {
+	fi, err := d.getFile(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.srv.Files.Update(fi.file.Id, &drive.File{
+		OriginalFilename: originalFilename,
+	}).SupportsAllDrives(true).Do()
+
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	d.srvWrapper.invalidateFile(fi.file)
+
+	return nil
+}
+
+// indexableTextMaxSize is the maximum size of the indexable text accepted by Google Drive
+const indexableTextMaxSize = 128 * 1024
+
+// SetIndexableText attaches some text to a file so that it can be found by Google Drive's full-text search.
+// This is useful for files Google Drive can't index by itself, like scanned documents.
+func (d *GDriver) SetIndexableText(path string, text string) error {
+	if len(text) > indexableTextMaxSize {
+		return ErrIndexableTextTooLarge
+	}
+
+	fi, err := d.getFile(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.srv.Files.Update(fi.file.Id, &drive.File{
+		ContentHints: &drive.FileContentHints{
+			IndexableText: text,
+		},
+	}).SupportsAllDrives(true).Do()
+
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	return nil
+}
+
+// thumbnailMaxSize is the maximum size of the thumbnails accepted by Google Drive
+const thumbnailMaxSize = 2 * 1024 * 1024
+
+// SetThumbnail sets the image displayed as the preview of a file in Google Drive, which is useful for file
+// types Google Drive can't generate a thumbnail for. Google Drive drops it when the content of the file
+// changes.
+func (d *GDriver) SetThumbnail(path string, image []byte, mimeType string) error {
+	if len(image) > thumbnailMaxSize {
+		return ErrThumbnailTooLarge
+	}
+
 	fi, err := d.getFile(path)
 	if err != nil {
 		return err
 	}
 
 	_, err = d.srv.Files.Update(fi.file.Id, &drive.File{
-		ViewedByMeTime: atime.Format(time.RFC3339),
-		ModifiedTime:   mTime.Format(time.RFC3339),
-		// ModifiedByMeTime: mTime.Format(time.RFC3339),
-	}).Do()
+		ContentHints: &drive.FileContentHints{
+			Thumbnail: &drive.FileContentHintsThumbnail{
+				Image:    base64.URLEncoding.EncodeToString(image),
+				MimeType: mimeType,
+			},
+		},
+	}).SupportsAllDrives(true).Do()
 
 	if err != nil {
 		return &DriveAPICallError{Err: err}
@@ -789,6 +2083,6 @@ func (d *GDriver) Chtimes(path string, atime time.Time, mTime time.Time) error {
 }
 
 // Chown changes the ownership of a file
-func (d *GDriver) Chown(string, int, int) error {
-	return ErrNotSupported
+func (d *GDriver) Chown(path string, _, _ int) error {
+	return pathError("chown", path, ErrNotSupported)
 }
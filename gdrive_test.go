@@ -2,20 +2,30 @@
 package gdrive
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/md5" // nolint: gosec
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -24,8 +34,11 @@ import (
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
 
+	"github.com/fclairamb/afero-gdrive/cache"
+	"github.com/fclairamb/afero-gdrive/iohelper"
 	"github.com/fclairamb/afero-gdrive/oauthhelper"
 )
 
@@ -205,10 +218,24 @@ func TestMakeDirectory(t *testing.T) {
 		require.EqualError(
 			t,
 			driver.MkdirAll("Folder1/File1/Folder2", os.FileMode(0)),
-			"file Folder1/File1 is not a directory",
+			"mkdir Folder1/File1/Folder2: file Folder1/File1 is not a directory",
 		)
 	})
 
+	t.Run("messy path", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		require.NoError(t, driver.MkdirAll("\\Folder1//Folder2\\", os.FileMode(0)))
+
+		fi, err := driver.Stat("/Folder1\\Folder2")
+		require.NoError(t, err)
+		require.Equal(t, "Folder1/Folder2", fi.(*FileInfo).Path())
+
+		dir, err := driver.Open("//Folder1/Folder2/")
+		require.NoError(t, err)
+		require.Equal(t, "Folder1/Folder2", dir.(*File).Path)
+	})
+
 	t.Run("make root", func(t *testing.T) {
 		driver := setup(t).AsAfero()
 
@@ -223,7 +250,7 @@ func TestFileFolderMixup(t *testing.T) {
 	require.NoError(t, writeFile(driver, "Folder1/File1", bytes.NewBufferString("Hello World")))
 
 	err := writeFile(driver, "Folder1/File1/File2", bytes.NewBufferString("Hello World"))
-	require.EqualError(t, err, "couldn't open file: file Folder1/File1 is not a directory")
+	require.EqualError(t, err, "couldn't open file: open Folder1/File1/File2: file Folder1/File1 is not a directory")
 }
 
 func TestFileWriteBuffer(t *testing.T) {
@@ -267,244 +294,5763 @@ func TestCreateFile(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, "File1", fi.Name())
 
-		// Compare File contents
-		r, err := driver.Open("File1")
+		// Compare File contents
+		r, err := driver.Open("File1")
+		require.NoError(t, err)
+		received, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(received))
+	})
+
+	t.Run("in non existing folder", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		// create File
+		mustWriteFileContent(t, driver, "Folder1/File1", "Hello World")
+
+		// Folder created?
+		require.NoError(t, getError(driver.Stat("Folder1")))
+
+		// File created?
+		fi, err := driver.Stat("Folder1/File1")
+		require.NoError(t, err)
+		require.Equal(t, "File1", fi.Name())
+
+		// Compare File contents
+		r, err := driver.Open("Folder1/File1")
+		require.NoError(t, err)
+		received, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(received))
+	})
+
+	t.Run("as descendant of File", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		// create File
+		require.NoError(t, writeFile(driver, "Folder1/File1", bytes.NewBufferString("Hello World")))
+
+		err := writeFile(driver, "Folder1/File1/File2", bytes.NewBufferString("Hello World"))
+		require.EqualError(t, err, "couldn't open file: open Folder1/File1/File2: file Folder1/File1 is not a directory")
+	})
+
+	t.Run("empty target", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		// create File
+		require.ErrorIs(t, writeFile(driver, "", bytes.NewBufferString("Hello World")), ErrEmptyPath)
+	})
+
+	t.Run("overwrite File", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		// create File
+		mustWriteFileContent(t, driver, "File1", "Hello World")
+
+		// File created?
+		fi1, err := driver.Stat("File1")
+		require.NoError(t, err)
+		require.Equal(t, "File1", fi1.Name())
+
+		// Compare File contents
+		r, err := driver.Open("File1")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, r.Close()) }()
+		received, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(received))
+
+		// create File
+		mustWriteFileContent(t, driver, "File1", "Hello Universe")
+
+		// File created?
+		fi2, err := driver.Stat("File1")
+		require.NoError(t, err)
+		require.Equal(t, "File1", fi2.Name())
+
+		// Compare File contents
+		r, err = driver.Open("File1")
+		defer func() { require.NoError(t, r.Close()) }()
+		require.NoError(t, err)
+		received, err = ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "Hello Universe", string(received))
+	})
+}
+
+func TestGetFile(t *testing.T) {
+	driver := setup(t).AsAfero()
+
+	mustWriteFile(t, driver, "Folder1/File1")
+
+	// Compare File content
+	fi, err := driver.Open("Folder1/File1")
+	require.NoError(t, err)
+	received, err := ioutil.ReadAll(fi)
+	require.NoError(t, err)
+	require.Equal(t, "Hello World", string(received))
+	require.Equal(t, "File1", fi.Name())
+
+	// Get File contents of an Folder
+	file, err := driver.Open("Folder1")
+	require.NoError(t, err)
+	fileInfo, err := file.Stat()
+	require.NoError(t, err)
+	require.True(t, fileInfo.IsDir())
+}
+
+func TestDelete(t *testing.T) {
+	t.Run("delete file", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		mustWriteFile(t, driver, "File1")
+
+		// delete File
+		require.NoError(t, driver.Remove("File1"))
+
+		// File1 deleted?
+		requireNotExist(t, getError(driver.Stat("File1")), "File1")
+	})
+
+	t.Run("delete directory", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		mustCreateDir(t, driver, "Folder1")
+
+		// delete folder
+		require.NoError(t, driver.Remove("Folder1"))
+
+		// Folder1 deleted?
+		requireNotExist(t, getError(driver.Stat("Folder1")), "Folder1")
+	})
+}
+
+func TestDeleteDirectory(t *testing.T) {
+	t.Run("delete directory", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		mustCreateDir(t, driver, "Folder1")
+
+		// delete folder
+		require.NoError(t, driver.Remove("Folder1"))
+
+		// Folder1 deleted?
+		requireNotExist(t, getError(driver.Stat("Folder1")), "Folder1")
+	})
+}
+
+func TestListDirectory(t *testing.T) {
+	t.Run("standard", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		mustWriteFile(t, driver, "Folder1/File1")
+		mustWriteFile(t, driver, "Folder1/File2")
+
+		t.Run("listing 1000", func(t *testing.T) {
+			dir, err := driver.Open("Folder1")
+			require.NoError(t, err)
+
+			files, err := dir.Readdir(1000)
+			require.NoError(t, err)
+
+			require.Len(t, files, 2)
+
+			// sort so we can be sure the test works with random order
+			sort.Slice(files, func(i, j int) bool {
+				return strings.Compare(files[i].Name(), files[j].Name()) == -1
+			})
+
+			require.Equal(t, "File1", files[0].Name())
+			require.Equal(t, "File2", files[1].Name())
+		})
+
+		t.Run("listing no limit", func(t *testing.T) {
+			dir, err := driver.Open("Folder1")
+			require.NoError(t, err)
+
+			files, err := dir.Readdir(-1)
+			require.NoError(t, err)
+
+			require.Len(t, files, 2)
+		})
+
+		// Partial listing
+		t.Run("partial", func(t *testing.T) {
+			mustWriteFile(t, driver, "Folder1/File3")
+			defer func() { require.NoError(t, driver.Remove("Folder1/File3")) }()
+
+			dir, err := driver.Open("Folder1")
+			require.NoError(t, err)
+			defer func() { _ = dir.Close() }()
+
+			files, err := dir.Readdir(2)
+			require.NoError(t, err)
+			require.Len(t, files, 2)
+			require.Equal(t, "File1", files[0].Name())
+			require.Equal(t, "File2", files[1].Name())
+
+			files, err = dir.Readdir(2)
+			require.NoError(t, err)
+			require.Len(t, files, 1)
+			require.Equal(t, "File3", files[0].Name())
+		})
+
+		// Remove contents
+		require.NoError(t, driver.Remove("Folder1/File1"))
+		require.NoError(t, driver.Remove("Folder1/File2"))
+
+		{ // Test if folder is empty
+			dir, err := driver.Open("Folder1")
+			require.NoError(t, err)
+
+			files, err := dir.Readdir(2000)
+			require.NoError(t, err)
+
+			require.Len(t, files, 0)
+		}
+	})
+
+	t.Run("directory does not exist", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		_, err := driver.Open("Folder5")
+		requireNotExist(t, err, "Folder5")
+	})
+
+	t.Run("list File", func(t *testing.T) {
+		driver := setup(t).AsAfero()
+
+		mustWriteFile(t, driver, "File1")
+
+		dir, err := driver.Open("File1")
+		require.NoError(t, err)
+
+		_, err = dir.Readdir(1000)
+		require.EqualError(t, err, "file File1 is not a directory")
+	})
+}
+
+func TestListDirectoryRateLimited(t *testing.T) {
+	listHandler := func(failuresOnSecondPage, status int) http.HandlerFunc {
+		failures := 0
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("pageToken") {
+			case "":
+				writeJSON(w, http.StatusOK, &drive.FileList{
+					Files:         []*drive.File{{Id: "1", Name: "File1"}},
+					NextPageToken: "page2",
+				})
+			case "page2":
+				if failures < failuresOnSecondPage {
+					failures++
+
+					writeAPIError(w, status, "rateLimitExceeded")
+
+					return
+				}
+
+				writeJSON(w, http.StatusOK, &drive.FileList{
+					Files: []*drive.File{{Id: "2", Name: "File2"}},
+				})
+			}
+		}
+	}
+
+	newDir := func(driver *GDriver) *File {
+		return &File{
+			driver:   driver,
+			FileInfo: &FileInfo{file: &drive.File{Id: "dir", Name: "Folder1", MimeType: mimeTypeFolder}},
+		}
+	}
+
+	t.Run("page retried", func(t *testing.T) {
+		driver := newFakeDriver(t, listHandler(1, http.StatusTooManyRequests), ListRetry(2, time.Millisecond))
+
+		files, err := newDir(driver).Readdir(-1)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+		require.Equal(t, "File2", files[1].Name())
+	})
+
+	t.Run("forbidden rate-limit retried by default", func(t *testing.T) {
+		driver := newFakeDriver(t, listHandler(listRetryMaxDefault, http.StatusForbidden))
+		driver.ListRetryBackoff = time.Millisecond
+
+		files, err := newDir(driver).Readdir(-1)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+		require.Equal(t, "File2", files[1].Name())
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		driver := newFakeDriver(t, listHandler(5, http.StatusTooManyRequests), ListRetry(2, time.Millisecond))
+		dir := newDir(driver)
+
+		files, err := dir.Readdir(-1)
+		require.Error(t, err)
+		require.True(t, isRateLimitError(err))
+		require.Len(t, files, 1)
+		require.Equal(t, "page2", dir.dirListToken)
+
+		// The listing resumes from the preserved token
+		files, err = dir.Readdir(-1)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		require.Equal(t, "File2", files[0].Name())
+	})
+}
+
+func TestPrefetch(t *testing.T) {
+	lookups := 0
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(query, "name="):
+			lookups++
+
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case strings.HasPrefix(query, fmt.Sprintf("'%s' in parents", fakeRootID)):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case strings.HasPrefix(query, "'folder1' in parents"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file2", Name: "File2", MimeType: mimeTypeFile, Size: 11},
+			}})
+		}
+	})
+
+	require.NoError(t, driver.Prefetch("", -1))
+
+	fi, err := driver.Stat("Folder1/File2")
+	require.NoError(t, err)
+	require.EqualValues(t, 11, fi.Size())
+
+	fi, err = driver.Stat("File1")
+	require.NoError(t, err)
+	require.False(t, fi.IsDir())
+
+	require.Equal(t, 0, lookups)
+}
+
+func TestDirHandle(t *testing.T) {
+	children := []*drive.File{
+		{Id: "1", Name: "File1"},
+		{Id: "2", Name: "File2"},
+		{Id: "3", Name: "File3"},
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("q"), "name=") {
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			}})
+
+			return
+		}
+
+		start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+		end := start + pageSize
+		list := &drive.FileList{}
+
+		if end < len(children) {
+			list.NextPageToken = strconv.Itoa(end)
+		} else {
+			end = len(children)
+		}
+
+		list.Files = children[start:end]
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	dir, err := driver.OpenDir("Folder1")
+	require.NoError(t, err)
+
+	files, err := dir.NextPage(2)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "Folder1/File1", files[0].(*FileInfo).Path())
+
+	cursor := dir.Cursor()
+	require.NotEmpty(t, cursor)
+
+	// Resuming from another handle
+	dir, err = driver.ResumeDir("Folder1", cursor)
+	require.NoError(t, err)
+
+	files, err = dir.NextPage(2)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "File3", files[0].Name())
+	require.Empty(t, dir.Cursor())
+
+	_, err = dir.NextPage(2)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestPathTraversal(t *testing.T) {
+	driver := setup(t).AsAfero()
+
+	mustWriteFile(t, driver, "Folder1/File1")
+
+	fi, err := driver.Stat("Folder1/./File1")
+	require.NoError(t, err)
+	require.Equal(t, "File1", fi.Name())
+
+	fi, err = driver.Stat("Folder2/../Folder1/File1")
+	require.NoError(t, err)
+	require.Equal(t, "Folder1/File1", fi.(*FileInfo).Path())
+
+	// A file literally named ".." can't be reached, going above the root is rejected
+	var outside *PathOutsideRootError
+	require.ErrorAs(t, getError(driver.Stat("Folder1/../..")), &outside)
+	require.Equal(t, "Folder1/../..", outside.Path)
+	require.ErrorAs(t, driver.Mkdir("../Folder2", os.FileMode(0)), &outside)
+	require.Equal(t, "../Folder2", outside.Path)
+}
+
+func TestBatchUpdate(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		patched []string
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")
+
+		if r.Method != http.MethodPatch || id == "missing" {
+			writeAPIError(w, http.StatusNotFound, "notFound")
+
+			return
+		}
+
+		require.Equal(t, "true", r.URL.Query().Get("supportsAllDrives"), r.URL.String())
+
+		var patch drive.File
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+		require.True(t, patch.Starred)
+
+		mu.Lock()
+		patched = append(patched, id)
+		mu.Unlock()
+
+		writeJSON(w, http.StatusOK, &drive.File{Id: id, Parents: []string{fakeRootID}, Starred: true})
+	})
+
+	ids := make([]string, 0, batchMaxRequests+2)
+	for i := 0; i <= batchMaxRequests; i++ {
+		ids = append(ids, fmt.Sprintf("file%d", i))
+	}
+
+	ids = append(ids, "missing")
+
+	results, err := driver.BatchUpdate(ids, &drive.File{Starred: true}, "starred")
+	require.NoError(t, err)
+	require.Len(t, results, len(ids))
+	require.Len(t, patched, batchMaxRequests+1)
+	require.NoError(t, results["file0"])
+	require.NoError(t, results[fmt.Sprintf("file%d", batchMaxRequests)])
+	require.Error(t, results["missing"])
+	require.Equal(t, 2, int(*driver.srvWrapper.calls["Batch"]))
+}
+
+func BenchmarkSmallWrites(b *testing.B) {
+	const chunkSize = 16
+
+	chunk := bytes.Repeat([]byte("a"), chunkSize)
+
+	for _, bufferSize := range []int{0, writePipeBufferSizeDefault} {
+		b.Run(fmt.Sprintf("pipe buffer %d", bufferSize), func(b *testing.B) {
+			driver := newFakeDriver(b, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1"})
+			}, WritePipeBuffer(bufferSize))
+
+			fi := &FileInfo{file: &drive.File{Id: "file1", Name: "File1"}}
+
+			b.SetBytes(chunkSize)
+			b.ResetTimer()
+
+			writer, endErr, _, err := driver.getFileWriter(fi, nil)
+			require.NoError(b, err)
+
+			for i := 0; i < b.N; i++ {
+				_, err = writer.Write(chunk)
+				require.NoError(b, err)
+			}
+
+			require.NoError(b, writer.Close())
+			require.NoError(b, <-endErr)
+		})
+	}
+}
+
+func TestDownloadLinkVirusScanWarning(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Host == "drive.google.com" && r.URL.Query().Get("id") == "small":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte("small content"))
+		case r.URL.Host == "drive.google.com":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<html>Google Drive can't scan this file for viruses.</html>"))
+		case r.URL.Path == "/drive/v3/files/large" && r.URL.Query().Get("alt") == "media":
+			_, _ = w.Write([]byte("large content"))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	for id, content := range map[string]string{"small": "small content", "large": "large content"} {
+		reader, err := driver.DownloadLink("https://drive.google.com/uc?id=" + id + "&export=download")
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, content, string(data))
+	}
+
+	_, err := driver.DownloadLink("https://drive.google.com/uc?export=download")
+	require.ErrorIs(t, err, ErrVirusScanWarning)
+}
+
+func TestOpenBuffered(t *testing.T) {
+	content := "Hello World"
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: int64(len(content))},
+			}})
+		case r.URL.Path == "/drive/v3/files/file1":
+			_, _ = w.Write([]byte(content))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	t.Run("random access", func(t *testing.T) {
+		f, err := driver.OpenBuffered("File1")
+		require.NoError(t, err)
+
+		buf := make([]byte, 5)
+		_, err = f.ReadAt(buf, 6)
+		require.NoError(t, err)
+		require.Equal(t, "World", string(buf))
+
+		_, err = f.Seek(-5, io.SeekEnd)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, "World", string(data))
+
+		_, err = f.WriteString("nope")
+		require.ErrorIs(t, err, ErrReadOnly)
+		require.NoError(t, f.Close())
+		require.NoError(t, f.Close())
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		driver.BufferedReadMaxSize = 5
+		defer func() { driver.BufferedReadMaxSize = bufferedReadMaxSizeDefault }()
+
+		_, err := driver.OpenBuffered("File1")
+		require.EqualError(t, err, "`File1' is larger than 5 bytes")
+	})
+
+	t.Run("spilled", func(t *testing.T) {
+		spill := afero.NewMemMapFs()
+		driver.BufferedReadMaxSize = 5
+		require.NoError(t, BufferedSpill(spill, 5)(driver))
+
+		defer func() {
+			driver.BufferedReadMaxSize = bufferedReadMaxSizeDefault
+			driver.BufferedSpillFs = nil
+		}()
+
+		f, err := driver.OpenBuffered("File1")
+		require.NoError(t, err)
+		require.Equal(t, "File1", f.Name())
+
+		tmpFiles, err := afero.ReadDir(spill, os.TempDir())
+		require.NoError(t, err)
+		require.Len(t, tmpFiles, 1)
+
+		buf := make([]byte, 5)
+		_, err = f.ReadAt(buf, 6)
+		require.NoError(t, err)
+		require.Equal(t, "World", string(buf))
+
+		_, err = f.Seek(-5, io.SeekEnd)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, "World", string(data))
+
+		_, err = f.WriteString("nope")
+		require.ErrorIs(t, err, ErrReadOnly)
+		require.NoError(t, f.Close())
+
+		// The temporary file isn't closed and removed twice
+		require.NoError(t, f.Close())
+
+		tmpFiles, err = afero.ReadDir(spill, os.TempDir())
+		require.NoError(t, err)
+		require.Empty(t, tmpFiles)
+	})
+
+	t.Run("not spilled below the threshold", func(t *testing.T) {
+		spill := afero.NewMemMapFs()
+		require.NoError(t, BufferedSpill(spill, 1024)(driver))
+
+		defer func() { driver.BufferedSpillFs = nil }()
+
+		f, err := driver.OpenBuffered("File1")
+		require.NoError(t, err)
+		require.IsType(t, &BufferedFile{}, f)
+		require.NoError(t, f.Close())
+	})
+}
+
+func TestSharedDriveRootCreation(t *testing.T) {
+	const driveID = "shared-drive-id"
+
+	var created *drive.File
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "true", r.URL.Query().Get("supportsAllDrives"), r.URL.String())
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files/"+driveID:
+			writeJSON(w, http.StatusOK, &drive.File{Id: driveID, Name: "Shared", MimeType: mimeTypeFolder})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			require.Equal(t, "drive", r.URL.Query().Get("corpora"))
+			require.Equal(t, driveID, r.URL.Query().Get("driveId"))
+			require.Equal(t, "true", r.URL.Query().Get("includeItemsFromAllDrives"))
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files":
+			created = &drive.File{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(created))
+			created.Id = "folder1"
+			writeJSON(w, http.StatusOK, created)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}, SharedDrive(driveID))
+
+	require.Equal(t, driveID, driver.rootNode.file.Id)
+	require.NoError(t, driver.MkdirAll("Folder1", os.FileMode(0)))
+	require.NotNil(t, created)
+	require.Equal(t, []string{driveID}, created.Parents)
+	require.Equal(t, mimeTypeFolder, created.MimeType)
+}
+
+func TestFileInfoNames(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(query, "name='Folder1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			}})
+		case strings.Contains(query, "name='Folder2'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder2", Name: "Folder2", MimeType: mimeTypeFolder},
+			}})
+		case strings.HasPrefix(query, "'folder2' in parents"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		}
+	})
+
+	fi, err := driver.Stat("Folder1/Folder2")
+	require.NoError(t, err)
+	require.Equal(t, "Folder2", fi.Name())
+	require.Equal(t, "Folder1/Folder2", fi.(*FileInfo).Path())
+	require.Equal(t, "folder2", fi.(*FileInfo).ID())
+
+	dir, err := driver.Open("Folder1/Folder2")
+	require.NoError(t, err)
+
+	files, err := dir.Readdir(-1)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "File1", files[0].Name())
+	require.Equal(t, "Folder1/Folder2/File1", files[0].(*FileInfo).Path())
+}
+
+func TestUploadSized(t *testing.T) {
+	var (
+		uploaded   []byte
+		misreading int64
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{
+					Id: "file1", Name: "File1", MimeType: mimeTypeFile,
+					Size: int64(len(uploaded)), Parents: []string{fakeRootID},
+				},
+			}})
+		case strings.Contains(r.URL.Query().Get("q"), "name='Doc1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{
+					Id: "doc1", Name: "Doc1", MimeType: "application/vnd.google-apps.document",
+					Parents: []string{fakeRootID},
+				},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/upload/drive/v3/files/file1":
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			_, err = mr.NextPart() // metadata
+			require.NoError(t, err)
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			uploaded, err = io.ReadAll(media)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "badRequest")
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1", Size: int64(len(uploaded)) + misreading})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	t.Run("known size", func(t *testing.T) {
+		fi, err := driver.UploadSized("File1", strings.NewReader("Hello World and more"), 11)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(uploaded))
+		require.EqualValues(t, 11, fi.Size())
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		fi, err := driver.UploadSized("File1", strings.NewReader("Hello Universe"), -1)
+		require.NoError(t, err)
+		require.EqualValues(t, 14, fi.Size())
+
+		// The cached lookup of the file was dropped
+		stat, err := driver.Stat("File1")
+		require.NoError(t, err)
+		require.EqualValues(t, 14, stat.Size())
+	})
+
+	t.Run("native document", func(t *testing.T) {
+		_, err := driver.UploadSized("Doc1", strings.NewReader("Hello"), 5)
+
+		var nativeErr *NativeDocWriteError
+		require.ErrorAs(t, err, &nativeErr)
+		require.Equal(t, "Doc1", nativeErr.Path)
+	})
+
+	t.Run("truncated source", func(t *testing.T) {
+		_, err := driver.UploadSized("File1", strings.NewReader("Hello"), 11)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("size mismatch", func(t *testing.T) {
+		misreading = -1
+		defer func() { misreading = 0 }()
+
+		_, err := driver.UploadSized("File1", strings.NewReader("Hello World"), 11)
+
+		var mismatch *SizeMismatchError
+		require.ErrorAs(t, err, &mismatch)
+		require.EqualValues(t, 11, mismatch.Expected)
+		require.EqualValues(t, 10, mismatch.Actual)
+	})
+}
+
+func TestSizeAfterWrite(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: 3},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/upload/drive/v3/files/file1":
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			_, err = mr.NextPart() // metadata
+			require.NoError(t, err)
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			content, err := io.ReadAll(media)
+			require.NoError(t, err)
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1", Size: int64(len(content))})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	f, err := driver.OpenFile("File1", os.O_WRONLY|os.O_TRUNC, 0)
+	require.NoError(t, err)
+
+	written, err := f.Write([]byte("Hello World"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	stat, err := f.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, written, stat.Size())
+}
+
+func TestListFilterFunc(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Contains(t, r.URL.Query().Get("fields"), "capabilities")
+
+		writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+			{Id: "1", Name: "File1", Capabilities: &drive.FileCapabilities{CanEdit: true}},
+			{Id: "2", Name: "File2", Capabilities: &drive.FileCapabilities{CanEdit: false}},
+			{Id: "3", Name: "File3", Capabilities: &drive.FileCapabilities{CanEdit: true}},
+		}})
+	})
+
+	driver.ListFilterFunc = func(f *drive.File) bool {
+		return f.Capabilities != nil && f.Capabilities.CanEdit
+	}
+
+	root, err := driver.Open("/")
+	require.NoError(t, err)
+
+	files, err := root.Readdir(-1)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "File1", files[0].Name())
+	require.Equal(t, "File3", files[1].Name())
+}
+
+func TestCloseTimeout(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		// The upload never completes
+		<-r.Context().Done()
+	})
+	driver.CloseTimeout = 50 * time.Millisecond
+
+	f, err := driver.openFileWrite(&FileInfo{file: &drive.File{Id: "file1", Name: "File1"}}, "File1")
+	require.NoError(t, err)
+
+	_, err = f.WriteString("Hello World")
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.ErrorIs(t, f.Close(), ErrCloseTimeout)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestVerify(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Dir1'") && strings.Contains(q, "'"+fakeRootID+"' in parents"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder},
+			}})
+		case strings.Contains(q, "name='Good'"):
+			require.Contains(t, r.URL.Query().Get("fields"), "md5Checksum")
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "good", Name: "Good", MimeType: mimeTypeFile, Size: 3, Md5Checksum: "abc"},
+			}})
+		case strings.Contains(q, "name='Bad'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "bad", Name: "Bad", MimeType: mimeTypeFile, Size: 3, Md5Checksum: "def"},
+			}})
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	missing, mismatched, err := driver.Verify(map[string]FileSig{
+		"Dir1/Good":    {Size: 3, MD5: "abc"},
+		"Dir1/Bad":     {Size: 3, MD5: "abc"},
+		"Dir1/Short":   {Size: 3, MD5: "abc"},
+		"Dir2/Missing": {Size: 3, MD5: "abc"},
+		"Dir1":         {},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Dir1/Short", "Dir2/Missing"}, missing)
+	require.Equal(t, []string{"Dir1", "Dir1/Bad"}, mismatched)
+}
+
+func TestStatTrashAware(t *testing.T) {
+	var trashed int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/file1":
+			atomic.StoreInt32(&trashed, 1)
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Trashed: true})
+		case strings.Contains(q, "name='Folder1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			}})
+		case strings.Contains(q, "name='File1'") && strings.Contains(q, "trashed = true"):
+			if atomic.LoadInt32(&trashed) == 1 {
+				writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{{Id: "file1"}}})
+			} else {
+				writeJSON(w, http.StatusOK, &drive.FileList{})
+			}
+		case strings.Contains(q, "name='File1'"):
+			if atomic.LoadInt32(&trashed) == 1 {
+				writeJSON(w, http.StatusOK, &drive.FileList{})
+			} else {
+				writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+					{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Parents: []string{"folder1"}},
+				}})
+			}
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+	driver.TrashForDelete = true
+
+	_, err := driver.StatTrashAware("Folder1/File1")
+	require.NoError(t, err)
+
+	require.NoError(t, driver.Remove("Folder1/File1"))
+
+	_, err = driver.Stat("Folder1/File1")
+	require.True(t, IsNotExist(err))
+
+	_, err = driver.StatTrashAware("Folder1/File1")
+	require.True(t, IsTrashed(err))
+	require.EqualError(t, err, "`Folder1/File1' is in the trash (id: file1)")
+
+	_, err = driver.StatTrashAware("Folder1/File2")
+	require.EqualError(t, err, "`Folder1/File2' does not exist")
+}
+
+func TestExistsIn(t *testing.T) {
+	var lists int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Dir1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder},
+			}})
+		case q == "'dir1' in parents and trashed = false":
+			atomic.AddInt32(&lists, 1)
+
+			if r.URL.Query().Get("pageToken") == "" {
+				writeJSON(w, http.StatusOK, &drive.FileList{
+					Files:         []*drive.File{{Name: "File1"}},
+					NextPageToken: "page2",
+				})
+			} else {
+				writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{{Name: "File3"}}})
+			}
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	exists, err := driver.ExistsIn("Dir1", []string{"File1", "File2", "File3"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"File1": true, "File2": false, "File3": true}, exists)
+	require.Equal(t, int32(2), atomic.LoadInt32(&lists))
+
+	_, err = driver.ExistsIn("Dir2", []string{"File1"})
+	require.True(t, IsNotExist(err))
+}
+
+func TestSetIndexableText(t *testing.T) {
+	var updated *drive.File
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/file1":
+			updated = &drive.File{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(updated))
+			writeJSON(w, http.StatusOK, updated)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	require.NoError(t, driver.SetIndexableText("File1", "scanned invoice"))
+	require.NotNil(t, updated)
+	require.Equal(t, "scanned invoice", updated.ContentHints.IndexableText)
+
+	err := driver.SetIndexableText("File1", strings.Repeat("a", indexableTextMaxSize+1))
+	require.ErrorIs(t, err, ErrIndexableTextTooLarge)
+}
+
+func TestSetThumbnail(t *testing.T) {
+	var updated *drive.File
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/file1":
+			updated = &drive.File{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(updated))
+			writeJSON(w, http.StatusOK, updated)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	image := []byte{0x89, 'P', 'N', 'G', 0xfb, 0xff}
+
+	require.NoError(t, driver.SetThumbnail("File1", image, "image/png"))
+	require.NotNil(t, updated)
+	require.Equal(t, "image/png", updated.ContentHints.Thumbnail.MimeType)
+
+	decoded, err := base64.URLEncoding.DecodeString(updated.ContentHints.Thumbnail.Image)
+	require.NoError(t, err)
+	require.Equal(t, image, decoded)
+
+	err = driver.SetThumbnail("File1", make([]byte, thumbnailMaxSize+1), "image/png")
+	require.ErrorIs(t, err, ErrThumbnailTooLarge)
+}
+
+func TestMoveMany(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		removed = map[string]string{}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Dest'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dest", Name: "Dest", MimeType: mimeTypeFolder},
+			}})
+		case strings.Contains(q, "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Parents: []string{fakeRootID}},
+			}})
+		case strings.Contains(q, "name='File2'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file2", Name: "File2", MimeType: mimeTypeFile, Parents: []string{fakeRootID, "other", "dest"}},
+			}})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodPatch:
+			id := strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")
+			require.Equal(t, "dest", r.URL.Query().Get("addParents"))
+
+			mu.Lock()
+			removed[id] = r.URL.Query().Get("removeParents")
+			mu.Unlock()
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: id, MimeType: mimeTypeFile, Parents: []string{"dest"}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	results, err := driver.MoveMany([]string{"File1", "File2", "Missing"}, "Dest")
+	require.NoError(t, err)
+	require.NoError(t, results["File1"])
+	require.NoError(t, results["File2"])
+	require.True(t, IsNotExist(results["Missing"]))
+	require.Equal(t, map[string]string{"file1": fakeRootID, "file2": fakeRootID + ",other"}, removed)
+	require.Equal(t, 1, int(*driver.srvWrapper.calls["Batch"]))
+}
+
+func TestListRecent(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/drive/v3/files":
+			require.Equal(t, "recency desc", r.URL.Query().Get("orderBy"))
+			require.Contains(t, r.URL.Query().Get("q"), "mimeType != '"+mimeTypeFolder+"'")
+
+			if r.URL.Query().Get("pageToken") == "" {
+				writeJSON(w, http.StatusOK, &drive.FileList{
+					Files: []*drive.File{
+						{Id: "file1", Name: "File1", Parents: []string{"folder1"}},
+						{Id: "outside", Name: "Outside", Parents: []string{"elsewhere"}},
+					},
+					NextPageToken: "page2",
+				})
+			} else {
+				writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+					{Id: "file2", Name: "File2", Parents: []string{fakeRootID}},
+					{Id: "file3", Name: "File3", Parents: []string{fakeRootID}},
+				}})
+			}
+		case r.URL.Path == "/drive/v3/files/folder1":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "folder1", Name: "Folder1", Parents: []string{fakeRootID}})
+		case r.URL.Path == "/drive/v3/files/elsewhere":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "elsewhere", Name: "Elsewhere"})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	list, err := driver.ListRecent(2)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "Folder1/File1", list[0].Path())
+	require.Equal(t, "File2", list[1].Path())
+}
+
+func TestCreateWith(t *testing.T) {
+	var (
+		metadata drive.File
+		uploaded []byte
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='Folder1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			}})
+		case strings.Contains(r.URL.Query().Get("q"), "name='Existing'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "existing", Name: "Existing", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			require.NoError(t, json.NewDecoder(part).Decode(&metadata))
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			uploaded, err = io.ReadAll(media)
+			require.NoError(t, err)
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: metadata.Name, MimeType: metadata.MimeType})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	fi, err := driver.CreateWith("Folder1/File1", &drive.File{
+		Name:         "ignored",
+		Parents:      []string{"ignored"},
+		MimeType:     "text/markdown",
+		Description:  "My notes",
+		ModifiedTime: "2020-01-02T03:04:05Z",
+		Properties:   map[string]string{"key": "value"},
+	}, strings.NewReader("# Hello"))
+	require.NoError(t, err)
+	require.Equal(t, "Folder1/File1", fi.Path())
+	require.Equal(t, "# Hello", string(uploaded))
+	require.Equal(t, "File1", metadata.Name)
+	require.Equal(t, []string{"folder1"}, metadata.Parents)
+	require.Equal(t, "text/markdown", metadata.MimeType)
+	require.Equal(t, "My notes", metadata.Description)
+	require.Equal(t, "2020-01-02T03:04:05Z", metadata.ModifiedTime)
+	require.Equal(t, map[string]string{"key": "value"}, metadata.Properties)
+	require.Equal(t, 1, int(*driver.srvWrapper.calls["Files.Create"]))
+
+	_, err = driver.CreateWith("Existing", nil, nil)
+	require.EqualError(t, err, `"Existing" already exists`)
+}
+
+func TestCreateAs(t *testing.T) {
+	var (
+		metadata    drive.File
+		mediaType   string
+		uploaded    []byte
+		spreadsheet = "application/vnd.google-apps.spreadsheet"
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			require.NoError(t, json.NewDecoder(part).Decode(&metadata))
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			mediaType = media.Header.Get("Content-Type")
+			uploaded, err = io.ReadAll(media)
+			require.NoError(t, err)
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "sheet1", Name: metadata.Name, MimeType: metadata.MimeType})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	f, err := driver.CreateAs("report.csv", spreadsheet)
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("name,count\nfoo,1\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.Equal(t, spreadsheet, metadata.MimeType)
+	require.Equal(t, "report.csv", metadata.Name)
+	require.Contains(t, mediaType, "text/csv")
+	require.Equal(t, "name,count\nfoo,1\n", string(uploaded))
+	require.Equal(t, "sheet1", f.file.Id)
+	require.Equal(t, spreadsheet, f.file.MimeType)
+}
+
+func TestWriteNativeDoc(t *testing.T) {
+	const mimeTypeDocument = "application/vnd.google-apps.document"
+
+	var uploaded int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='Doc1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "doc1", Name: "Doc1", MimeType: mimeTypeDocument},
+			}})
+		case r.URL.Path == "/upload/drive/v3/files/doc1":
+			atomic.AddInt32(&uploaded, 1)
+			_, _ = io.Copy(io.Discard, r.Body)
+			writeJSON(w, http.StatusOK, &drive.File{Id: "doc1", Name: "Doc1", MimeType: mimeTypeFile})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	_, err := driver.OpenFile("Doc1", os.O_WRONLY, os.FileMode(0))
+	require.EqualError(t, err, "open Doc1: `Doc1' is a native document ("+mimeTypeDocument+") and can't be written")
+
+	_, err = driver.OpenFile("Doc1", os.O_WRONLY|os.O_CREATE, os.FileMode(0))
+	require.Error(t, err)
+	require.Zero(t, atomic.LoadInt32(&uploaded))
+
+	driver.OverwriteNativeDocs = true
+
+	f, err := driver.OpenFile("Doc1", os.O_WRONLY, os.FileMode(0))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("converted"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.EqualValues(t, 1, atomic.LoadInt32(&uploaded))
+}
+
+func TestExportCopy(t *testing.T) {
+	var (
+		metadata drive.File
+		uploaded []byte
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='Doc1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "doc1", Name: "Doc1", MimeType: "application/vnd.google-apps.document"},
+			}})
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.URL.Path == "/drive/v3/files/doc1/export":
+			require.Equal(t, "application/pdf", r.URL.Query().Get("mimeType"))
+			_, _ = w.Write([]byte("%PDF-1.4"))
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			require.NoError(t, json.NewDecoder(part).Decode(&metadata))
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			uploaded, err = io.ReadAll(media)
+			require.NoError(t, err)
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "pdf1", Name: metadata.Name, MimeType: metadata.MimeType})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	fi, err := driver.ExportCopy("Doc1", "Archive", "application/pdf")
+	require.NoError(t, err)
+	require.Equal(t, "Archive.pdf", fi.Path())
+	require.Equal(t, "application/pdf", metadata.MimeType)
+	require.Equal(t, "%PDF-1.4", string(uploaded))
+
+	_, err = driver.ExportCopy("File1", "Archive2", "application/pdf")
+	require.ErrorIs(t, err, ErrNotNativeDocument)
+}
+
+func TestExport(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='Doc1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "doc1", Name: "Doc1", MimeType: "application/vnd.google-apps.document"},
+			}})
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.URL.Path == "/drive/v3/files/doc1/export":
+			_, _ = fmt.Fprintf(w, "exported as %s", r.URL.Query().Get("mimeType"))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	reader, err := driver.Export("Doc1", "text/plain")
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "exported as text/plain", string(content))
+	require.NoError(t, reader.Close())
+
+	_, err = driver.Export("File1", "text/plain")
+	require.ErrorIs(t, err, ErrNotNativeDocument)
+
+	// Open exports to the configured format
+	require.NoError(t, ExportGoogleDocsAs("text/html")(driver))
+
+	f, err := driver.Open("Doc1")
+	require.NoError(t, err)
+
+	content, err = io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "exported as text/html", string(content))
+	require.NoError(t, f.Close())
+}
+
+func TestExportImportedDoc(t *testing.T) {
+	loadEnvFromFile(t)
+
+	if os.Getenv("GOOGLE_TOKEN") == "" {
+		t.Skip("GOOGLE_TOKEN is required to create a document")
+	}
+
+	driver := setup(t)
+
+	_, err := driver.CreateWith(
+		"Doc1",
+		&drive.File{MimeType: "application/vnd.google-apps.document"},
+		strings.NewReader("Hello World"),
+	)
+	require.NoError(t, err)
+
+	reader, err := driver.Export("Doc1", "text/plain")
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, reader.Close()) }()
+
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "Hello World")
+}
+
+func TestGoogleDocPolicy(t *testing.T) {
+	const mimeTypeDocument = "application/vnd.google-apps.document"
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Doc1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "doc1", Name: "Doc1", MimeType: mimeTypeDocument},
+			}})
+		case strings.Contains(q, "in parents"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder},
+				{Id: "doc1", Name: "Doc1", MimeType: mimeTypeDocument},
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+				{Id: "link1", Name: "Link1", MimeType: mimeTypeShortcut},
+			}})
+		case r.URL.Path == "/drive/v3/files/doc1/export":
+			require.Equal(t, mimeTypeOfficePrefix+"wordprocessingml.document", r.URL.Query().Get("mimeType"))
+			_, _ = w.Write([]byte("exported"))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	var readErr *NativeDocReadError
+
+	listNames := func() []string {
+		root, err := driver.Open("/")
+		require.NoError(t, err)
+
+		names, err := root.Readdirnames(-1)
+		require.NoError(t, err)
+
+		return names
+	}
+
+	t.Run("error", func(t *testing.T) {
+		_, err := driver.Open("Doc1")
+		require.ErrorAs(t, err, &readErr)
+		require.EqualError(t, err, "open Doc1: `Doc1' is a native document ("+mimeTypeDocument+") and can't be read")
+
+		_, err = driver.OpenBuffered("Doc1")
+		require.ErrorAs(t, err, &readErr)
+
+		require.Equal(t, []string{"Dir1", "Doc1", "File1", "Link1"}, listNames())
+	})
+
+	t.Run("export", func(t *testing.T) {
+		require.NoError(t, GoogleDocs(GoogleDocExport)(driver))
+
+		f, err := driver.Open("Doc1")
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, "exported", string(content))
+		require.NoError(t, f.Close())
+
+		f, err = driver.Open("Doc1")
+		require.NoError(t, err)
+
+		_, err = f.Seek(3, io.SeekStart)
+		require.NoError(t, err)
+
+		content, err = io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, "orted", string(content))
+		require.NoError(t, f.Close())
+
+		require.Equal(t, []string{"Dir1", "Doc1", "File1", "Link1"}, listNames())
+	})
+
+	t.Run("skip in listings", func(t *testing.T) {
+		require.NoError(t, GoogleDocs(GoogleDocSkipInListings)(driver))
+
+		_, err := driver.Open("Doc1")
+		require.ErrorAs(t, err, &readErr)
+
+		require.Equal(t, []string{"Dir1", "File1", "Link1"}, listNames())
+
+		files, err := driver.ListRich("/")
+		require.NoError(t, err)
+		require.Len(t, files, 3)
+	})
+}
+
+func TestFollowShortcuts(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Link'"):
+			require.Contains(t, r.URL.Query().Get("fields"), "shortcutDetails")
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{{
+				Id:       "link",
+				Name:     "Link",
+				MimeType: mimeTypeShortcut,
+				ShortcutDetails: &drive.FileShortcutDetails{
+					TargetId:       "folder1",
+					TargetMimeType: mimeTypeFolder,
+				},
+			}}})
+		case strings.Contains(q, "'folder1' in parents and name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case q == "'folder1' in parents and trashed = false":
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+				{Id: "file2", Name: "File2", MimeType: mimeTypeFile},
+			}})
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	fi, err := driver.Stat("Link")
+	require.NoError(t, err)
+	require.False(t, fi.IsDir())
+
+	driver.FollowShortcuts = true
+
+	fi, err = driver.Stat("Link")
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	require.True(t, fi.Mode().IsDir())
+
+	dir, err := driver.Open("Link")
+	require.NoError(t, err)
+	entries, err := dir.Readdir(-1)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "Link/File2", entries[1].(*FileInfo).Path())
+
+	fi, err = driver.Stat("Link/File1")
+	require.NoError(t, err)
+	require.Equal(t, "Link/File1", fi.(*FileInfo).Path())
+}
+
+func TestReaddirResolved(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Shared'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "shared", Name: "Shared", MimeType: mimeTypeFolder},
+			}})
+		case q == "'shared' in parents and trashed = false":
+			shortcut := func(id, target, targetMime string) *drive.File {
+				return &drive.File{
+					Id:              id,
+					Name:            "Link to " + target,
+					MimeType:        mimeTypeShortcut,
+					ShortcutDetails: &drive.FileShortcutDetails{TargetId: target, TargetMimeType: targetMime},
+				}
+			}
+
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: 1},
+				shortcut("link1", "file2", mimeTypeFile),
+				shortcut("link2", "folder1", mimeTypeFolder),
+				shortcut("link3", "deleted", mimeTypeFile),
+			}})
+		case r.URL.Path == "/drive/v3/files/file2":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file2", Name: "File2", MimeType: mimeTypeFile, Size: 42})
+		case r.URL.Path == "/drive/v3/files/folder1":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	entries, err := driver.ReaddirResolved("Shared")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.EqualValues(t, 1, atomic.LoadInt32(driver.srvWrapper.calls["Batch"]))
+
+	require.Equal(t, "File1", entries[0].Name())
+
+	require.Equal(t, "Shared/Link to file2", entries[1].(*FileInfo).Path())
+	require.Equal(t, "file2", entries[1].(*FileInfo).ID())
+	require.EqualValues(t, 42, entries[1].Size())
+
+	require.True(t, entries[2].IsDir())
+	require.Equal(t, "Link to folder1", entries[2].Name())
+
+	driver.KeepUnresolvedShortcuts = true
+
+	entries, err = driver.ReaddirResolved("Shared")
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	require.Equal(t, "link3", entries[3].(*FileInfo).ID())
+	require.False(t, entries[3].IsDir())
+}
+
+func TestMkdirAllEx(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		folders = map[string]*drive.File{
+			fakeRootID + "/Folder1": {Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+		}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			var parent, name string
+			_, err := fmt.Sscanf(r.URL.Query().Get("q"), "'%s in parents and name=%s and trashed = false", &parent, &name)
+			require.NoError(t, err)
+
+			list := &drive.FileList{}
+			if f := folders[strings.Trim(parent, "'")+"/"+strings.Trim(name, "'")]; f != nil {
+				list.Files = []*drive.File{f}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files":
+			created := &drive.File{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(created))
+			created.Id = "id-" + created.Name
+			folders[created.Parents[0]+"/"+created.Name] = created
+			writeJSON(w, http.StatusOK, created)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	created, leaf, err := driver.MkdirAllEx("Folder1/Sub1/Sub2", os.FileMode(0))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Folder1/Sub1", "Folder1/Sub1/Sub2"}, created)
+	require.Equal(t, "Folder1/Sub1/Sub2", leaf.Path())
+	require.True(t, leaf.IsDir())
+
+	created, leaf, err = driver.MkdirAllEx("Folder1/Sub1/Sub2", os.FileMode(0))
+	require.NoError(t, err)
+	require.Empty(t, created)
+	require.Equal(t, "id-Sub2", leaf.file.Id)
+}
+
+func TestMkdirPerm(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		folders = map[string]*drive.File{}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			var parent, name string
+			_, err := fmt.Sscanf(r.URL.Query().Get("q"), "'%s in parents and name=%s and trashed = false", &parent, &name)
+			require.NoError(t, err)
+
+			list := &drive.FileList{}
+			if f := folders[strings.Trim(parent, "'")+"/"+strings.Trim(name, "'")]; f != nil {
+				list.Files = []*drive.File{f}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files":
+			created := &drive.File{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(created))
+			created.Id = "id-" + created.Name
+			folders[created.Parents[0]+"/"+created.Name] = created
+			writeJSON(w, http.StatusOK, created)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	require.NoError(t, driver.Mkdir("Private", 0o700))
+	require.NoError(t, driver.MkdirAll("Shared/Sub", 0o755))
+	require.NoError(t, driver.Mkdir("Default", os.FileMode(0)))
+
+	stat, err := driver.Stat("Private")
+	require.NoError(t, err)
+	require.True(t, stat.IsDir())
+	require.Equal(t, os.FileMode(0o700), stat.Mode().Perm())
+
+	stat, err = driver.Stat("Shared/Sub")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o755), stat.Mode().Perm())
+
+	stat, err = driver.Stat("Default")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0), stat.Mode().Perm())
+	require.Equal(t, os.ModeDir, stat.Mode())
+}
+
+func TestChmod(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		file = &drive.File{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Parents: []string{fakeRootID}}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{file}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/file1":
+			update := &drive.File{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(update))
+
+			updated := *file
+			updated.Properties = update.Properties
+			file = &updated
+
+			writeJSON(w, http.StatusOK, file)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	stat, err := driver.Stat("File1")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0), stat.Mode())
+
+	require.NoError(t, driver.Chmod("File1", 0o751))
+
+	stat, err = driver.Stat("File1")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o751), stat.Mode())
+
+	// Only the permission bits are kept
+	require.NoError(t, driver.Chmod("File1", os.ModeSetuid|0o640))
+
+	stat, err = driver.Stat("File1")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o640), stat.Mode())
+	require.Equal(t, "416", file.Properties[fileModeProperty])
+}
+
+func TestEnsure(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		entries = map[string]*drive.File{}
+		creates int32
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			var parent, name string
+			_, err := fmt.Sscanf(r.URL.Query().Get("q"), "'%s in parents and name=%s and trashed = false", &parent, &name)
+			require.NoError(t, err)
+
+			list := &drive.FileList{}
+			if f := entries[strings.Trim(parent, "'")+"/"+strings.Trim(name, "'")]; f != nil {
+				list.Files = []*drive.File{f}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/drive/v3/files"):
+			atomic.AddInt32(&creates, 1)
+
+			created := &drive.File{}
+			if r.URL.Path == "/upload/drive/v3/files" {
+				_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				require.NoError(t, err)
+
+				part, err := multipart.NewReader(r.Body, params["boundary"]).NextPart()
+				require.NoError(t, err)
+				require.NoError(t, json.NewDecoder(part).Decode(created))
+			} else {
+				require.NoError(t, json.NewDecoder(r.Body).Decode(created))
+			}
+
+			created.Id = "id-" + created.Name
+			entries[created.Parents[0]+"/"+created.Name] = created
+			writeJSON(w, http.StatusOK, created)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	dir, created, err := driver.EnsureDir("Folder1/Sub1")
+	require.NoError(t, err)
+	require.True(t, created)
+	require.True(t, dir.IsDir())
+	require.Equal(t, "id-Sub1", dir.ID())
+	require.EqualValues(t, 2, atomic.LoadInt32(&creates))
+
+	dir, created, err = driver.EnsureDir("Folder1/Sub1")
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, "id-Sub1", dir.ID())
+
+	file, created, err := driver.EnsureFile("Folder1/Sub1/File1")
+	require.NoError(t, err)
+	require.True(t, created)
+	require.Equal(t, "id-File1", file.ID())
+	require.Equal(t, "Folder1/Sub1/File1", file.Path())
+	require.EqualValues(t, 3, atomic.LoadInt32(&creates))
+
+	file, created, err = driver.EnsureFile("Folder1/Sub1/File1")
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, "id-File1", file.ID())
+	require.EqualValues(t, 3, atomic.LoadInt32(&creates))
+
+	_, _, err = driver.EnsureFile("Folder1/Sub1")
+	require.ErrorAs(t, err, &FileIsDirectoryError{})
+
+	var notDir *FileIsNotDirectoryError
+
+	_, _, err = driver.EnsureDir("Folder1/Sub1/File1")
+	require.ErrorAs(t, err, &notDir)
+}
+
+func TestDefaultParent(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		entries = map[string]*drive.File{}
+		creates int32
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			var parent, name string
+			_, err := fmt.Sscanf(r.URL.Query().Get("q"), "'%s in parents and name=%s and trashed = false", &parent, &name)
+			require.NoError(t, err)
+
+			list := &drive.FileList{}
+			if f := entries[strings.Trim(parent, "'")+"/"+strings.Trim(name, "'")]; f != nil {
+				list.Files = []*drive.File{f}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/drive/v3/files"):
+			atomic.AddInt32(&creates, 1)
+
+			created := &drive.File{}
+			if r.URL.Path == "/upload/drive/v3/files" {
+				_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				require.NoError(t, err)
+
+				part, err := multipart.NewReader(r.Body, params["boundary"]).NextPart()
+				require.NoError(t, err)
+				require.NoError(t, json.NewDecoder(part).Decode(created))
+			} else {
+				require.NoError(t, json.NewDecoder(r.Body).Decode(created))
+			}
+
+			created.Id = "id-" + created.Name
+			entries[created.Parents[0]+"/"+created.Name] = created
+			writeJSON(w, http.StatusOK, created)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	driver.DefaultParent = "Inbox"
+
+	require.NoError(t, driver.Mkdir("Dir1", os.FileMode(0)))
+	require.NotNil(t, entries["id-Inbox/Dir1"])
+
+	file, created, err := driver.EnsureFile("File1")
+	require.NoError(t, err)
+	require.True(t, created)
+	require.Equal(t, "Inbox/File1", file.Path())
+	require.NotNil(t, entries["id-Inbox/File1"])
+
+	// Existing files are looked up in the default parent too
+	_, created, err = driver.EnsureFile("File1")
+	require.NoError(t, err)
+	require.False(t, created)
+
+	f, err := driver.OpenFile("File2", os.O_CREATE|os.O_WRONLY, os.FileMode(0))
+	require.NoError(t, err)
+	require.Equal(t, "Inbox/File2", f.(*File).Path)
+	_ = f.Close()
+
+	// Paths with several segments or starting at the root aren't redirected
+	require.NoError(t, driver.MkdirAll("Folder1/Sub1", os.FileMode(0)))
+	require.NotNil(t, entries[fakeRootID+"/Folder1"])
+
+	_, _, err = driver.EnsureFile("/File3")
+	require.NoError(t, err)
+	require.NotNil(t, entries[fakeRootID+"/File3"])
+}
+
+func TestCreateRetry(t *testing.T) {
+	var lookups, created int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			atomic.StoreInt32(&created, 1)
+			_, _ = io.Copy(io.Discard, r.Body)
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1", MimeType: mimeTypeFile})
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			// The file only shows up on the third lookup following its creation
+			if atomic.LoadInt32(&created) == 0 || atomic.AddInt32(&lookups, 1) < 3 {
+				writeJSON(w, http.StatusOK, &drive.FileList{})
+			} else {
+				writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+					{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+				}})
+			}
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}, CreateRetry(3, time.Millisecond))
+
+	_, err := driver.createFile("File1")
+	require.NoError(t, err)
+
+	fi, err := driver.Stat("File1")
+	require.NoError(t, err)
+	require.Equal(t, "File1", fi.Name())
+	require.EqualValues(t, 3, atomic.LoadInt32(&lookups))
+
+	// Files that weren't created by the driver aren't retried
+	before := *driver.srvWrapper.calls["Files.List"]
+	_, err = driver.Stat("File2")
+	require.True(t, IsNotExist(err))
+	require.Equal(t, before+1, *driver.srvWrapper.calls["Files.List"])
+}
+
+func TestPermissions(t *testing.T) {
+	const link = "https://drive.google.com/file/d/file1/view"
+
+	var (
+		mu          sync.Mutex
+		permissions = []*drive.Permission{{Id: "owner", Type: "user", Role: "owner", EmailAddress: "me@example.com"}}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, WebViewLink: link},
+			}})
+		case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files/file1/permissions":
+			perm := &drive.Permission{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(perm))
+			perm.Id = fmt.Sprintf("perm%d", len(permissions))
+			permissions = append(permissions, perm)
+			writeJSON(w, http.StatusOK, perm)
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files/file1/permissions":
+			// One permission per page
+			page, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+			list := &drive.PermissionList{Permissions: permissions[page : page+1]}
+
+			if page+1 < len(permissions) {
+				list.NextPageToken = strconv.Itoa(page + 1)
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/drive/v3/files/file1/permissions/"):
+			id := path.Base(r.URL.Path)
+			for i, perm := range permissions {
+				if perm.Id == id {
+					permissions = append(permissions[:i], permissions[i+1:]...)
+					w.WriteHeader(http.StatusNoContent)
+
+					return
+				}
+			}
+
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	perm, err := driver.Grant("File1", SharePermission{Type: "anyone", Role: "reader"})
+	require.NoError(t, err)
+	require.Equal(t, "perm1", perm.ID)
+	require.Equal(t, "anyone", perm.Type)
+	require.Equal(t, "reader", perm.Role)
+	require.Equal(t, link, perm.WebViewLink)
+
+	require.NoError(t, driver.Share("File1", SharePermission{Type: "domain", Role: "writer", Domain: "example.com"}))
+
+	list, err := driver.ListPermissions("File1")
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	require.Equal(t, "owner", list[0].ID)
+	require.Equal(t, "anyone", list[1].Type)
+	require.Equal(t, "example.com", list[2].Domain)
+
+	require.NoError(t, driver.RemovePermission("File1", "perm1"))
+
+	list, err = driver.ListPermissions("File1")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "domain", list[1].Type)
+
+	require.Error(t, driver.RemovePermission("File1", "perm1"))
+
+	_, err = driver.Grant("Missing", SharePermission{Type: "anyone", Role: "reader"})
+	require.True(t, IsNotExist(err))
+}
+
+func TestDefaultPermissions(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		permissions []*drive.Permission
+		failing     bool
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			_, _ = io.Copy(io.Discard, r.Body)
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1", MimeType: mimeTypeFile})
+		case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files/file1/permissions":
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failing {
+				writeAPIError(w, http.StatusForbidden, "forbidden")
+
+				return
+			}
+
+			perm := &drive.Permission{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(perm))
+			permissions = append(permissions, perm)
+			writeJSON(w, http.StatusOK, perm)
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+	driver.DefaultPermissions = []SharePermission{{Type: "anyone", Role: "reader"}}
+
+	_, err := driver.createFile("File1")
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	require.Equal(t, "anyone", permissions[0].Type)
+	require.Equal(t, "reader", permissions[0].Role)
+
+	failing = true
+
+	_, err = driver.createFile("File1")
+	var permErr *DefaultPermissionError
+	require.ErrorAs(t, err, &permErr)
+	require.Equal(t, "File1", permErr.Path)
+
+	driver.IgnoreDefaultPermissionErrors = true
+
+	_, err = driver.createFile("File1")
+	require.NoError(t, err)
+}
+
+func TestFindByProperty(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/drive/v3/files":
+			require.Equal(t,
+				`properties has { key='ftp_file_mode' and value='it\'s \\ 420' } and trashed = false`,
+				r.URL.Query().Get("q"),
+			)
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", Parents: []string{fakeRootID}},
+				{Id: "outside", Name: "Outside", Parents: []string{"elsewhere"}},
+			}})
+		case r.URL.Path == "/drive/v3/files/elsewhere":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "elsewhere", Name: "Elsewhere"})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	list, err := driver.FindByProperty("ftp_file_mode", `it's \ 420`, 10)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "File1", list[0].Path())
+}
+
+func TestSearch(t *testing.T) {
+	var pageTokens []string
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/drive/v3/files":
+			require.Equal(t, "name contains 'report'", r.URL.Query().Get("q"))
+
+			pageTokens = append(pageTokens, r.URL.Query().Get("pageToken"))
+
+			if r.URL.Query().Get("pageToken") == "" {
+				writeJSON(w, http.StatusOK, &drive.FileList{NextPageToken: "next", Files: []*drive.File{
+					{Id: "file1", Name: "report-2023.csv", Parents: []string{fakeRootID}},
+					{Id: "outside", Name: "report-old.csv", Parents: []string{"elsewhere"}},
+				}})
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file2", Name: "report-2024.csv", Parents: []string{"dir1"}},
+			}})
+		case r.URL.Path == "/drive/v3/files/dir1":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "dir1", Name: "Dir1", Parents: []string{fakeRootID}})
+		case r.URL.Path == "/drive/v3/files/elsewhere":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "elsewhere", Name: "Elsewhere"})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	list, err := driver.Search("name contains 'report'", 0)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "report-2023.csv", list[0].Path())
+	require.Equal(t, "Dir1/report-2024.csv", list[1].Path())
+	require.Equal(t, []string{"", "next"}, pageTokens)
+
+	list, err = driver.Search("name contains 'report'", 1)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+}
+
+func TestShutdown(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files/"):
+			_, _ = io.Copy(io.Discard, r.Body)
+			writeJSON(w, http.StatusOK, &drive.File{Id: path.Base(r.URL.Path), MimeType: mimeTypeFile})
+		case strings.Contains(r.URL.Query().Get("q"), "name='File"):
+			name := strings.Split(r.URL.Query().Get("q"), "'")[3]
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: strings.ToLower(name), Name: name, MimeType: mimeTypeFile},
+			}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	require.NoError(t, driver.Shutdown(context.Background()))
+
+	file1, err := driver.OpenFile("File1", os.O_WRONLY, os.FileMode(0))
+	require.NoError(t, err)
+	file2, err := driver.OpenFile("File2", os.O_WRONLY, os.FileMode(0))
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = file1.WriteString("Hello")
+		_ = file1.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = driver.Shutdown(ctx)
+
+	var shutdownErr *ShutdownError
+	require.ErrorAs(t, err, &shutdownErr)
+	require.Len(t, shutdownErr.Errors, 1)
+	require.ErrorIs(t, shutdownErr.Errors["File2"], context.DeadlineExceeded)
+
+	require.NoError(t, file2.Close())
+	require.NoError(t, driver.Shutdown(context.Background()))
+}
+
+func TestListMatching(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Dir1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder},
+			}})
+		case strings.Contains(q, "name contains"):
+			require.Equal(t, `'dir1' in parents and name contains 'rep\'ort' and trashed = false`, q)
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "rep'ort 2020", MimeType: mimeTypeFile},
+			}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	list, err := driver.ListMatching("Dir1", "rep'ort")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "Dir1/rep-ort 2020", list[0].Path())
+}
+
+func TestWithoutDescription(t *testing.T) {
+	var (
+		mu           sync.Mutex
+		descriptions []string
+	)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/drive/v3/files"):
+			var file drive.File
+
+			if strings.HasPrefix(r.URL.Path, "/upload/") {
+				_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				require.NoError(t, err)
+				part, err := multipart.NewReader(r.Body, params["boundary"]).NextPart()
+				require.NoError(t, err)
+				require.NoError(t, json.NewDecoder(part).Decode(&file))
+			} else {
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&file))
+			}
+
+			mu.Lock()
+			descriptions = append(descriptions, file.Description)
+			mu.Unlock()
+
+			file.Id = "id-" + file.Name
+			writeJSON(w, http.StatusOK, &file)
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}
+
+	driver := newFakeDriver(t, handler)
+	_, err := driver.createFile("Folder1/File1")
+	require.NoError(t, err)
+	require.Equal(t, []string{createdDescription, createdDescription}, descriptions)
+
+	descriptions = nil
+	driver = newFakeDriver(t, handler, WithoutDescription())
+	_, err = driver.createFile("Folder1/File1")
+	require.NoError(t, err)
+	_, err = driver.CreateWith("File2", nil, strings.NewReader("content"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "", ""}, descriptions)
+}
+
+func TestOriginalFilename(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		stored *drive.File
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+			part, err := multipart.NewReader(r.Body, params["boundary"]).NextPart()
+			require.NoError(t, err)
+			stored = &drive.File{}
+			require.NoError(t, json.NewDecoder(part).Decode(stored))
+			stored.Id = "file1"
+			writeJSON(w, http.StatusOK, stored)
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/file1":
+			var patch drive.File
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+			stored.OriginalFilename = patch.OriginalFilename
+			writeJSON(w, http.StatusOK, stored)
+		case strings.Contains(r.URL.Query().Get("q"), "name='Report.pdf'") && stored != nil:
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{stored}})
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	_, err := driver.CreateWith("Report.pdf", &drive.File{OriginalFilename: "report/2020.pdf"}, strings.NewReader("%PDF"))
+	require.NoError(t, err)
+
+	fi, err := driver.Stat("Report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, "report/2020.pdf", fi.(*FileInfo).OriginalFilename())
+
+	require.NoError(t, driver.SetOriginalFilename("Report.pdf", "report 2021.pdf"))
+
+	fi, err = driver.Stat("Report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, "report 2021.pdf", fi.(*FileInfo).OriginalFilename())
+}
+
+func TestFileChildren(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		lookups []string
+		created []*drive.File
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			lookups = append(lookups, q)
+
+			var files []*drive.File
+
+			switch {
+			case strings.Contains(q, "name='Folder1'"):
+				files = []*drive.File{{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder}}
+			case strings.Contains(q, "'folder1' in parents and name='File1'"):
+				files = []*drive.File{{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: 5}}
+			}
+
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: files})
+		case r.Method == http.MethodPost:
+			file := &drive.File{}
+
+			if strings.HasPrefix(r.URL.Path, "/upload/") {
+				_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				require.NoError(t, err)
+				part, err := multipart.NewReader(r.Body, params["boundary"]).NextPart()
+				require.NoError(t, err)
+				require.NoError(t, json.NewDecoder(part).Decode(file))
+			} else {
+				require.NoError(t, json.NewDecoder(r.Body).Decode(file))
+			}
+
+			file.Id = "id-" + file.Name
+			created = append(created, file)
+			writeJSON(w, http.StatusOK, file)
+		case r.URL.Path == "/drive/v3/files/file1":
+			_, _ = w.Write([]byte("Hello"))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	f, err := driver.Open("Folder1")
+	require.NoError(t, err)
+
+	dir := f.(*File)
+	lookups = nil
+
+	fi, err := dir.StatChild("File1")
+	require.NoError(t, err)
+	require.Equal(t, "Folder1/File1", fi.(*FileInfo).Path())
+
+	child, err := dir.OpenChild("File1", os.O_RDONLY)
+	require.NoError(t, err)
+	content, err := io.ReadAll(child)
+	require.NoError(t, err)
+	require.Equal(t, "Hello", string(content))
+
+	_, err = dir.StatChild("File2")
+	require.EqualError(t, err, "`Folder1/File2' does not exist")
+
+	require.NoError(t, dir.Mkdir("Sub1"))
+	require.Len(t, created, 1)
+	require.Equal(t, mimeTypeFolder, created[0].MimeType)
+	require.Equal(t, []string{"folder1"}, created[0].Parents)
+
+	child, err = dir.OpenChild("File3", os.O_WRONLY|os.O_CREATE)
+	require.NoError(t, err)
+	require.Equal(t, "Folder1/File3", child.(*File).Path)
+	require.Len(t, created, 2)
+	require.Equal(t, []string{"folder1"}, created[1].Parents)
+
+	// The directory is never resolved again from the root
+	for _, q := range lookups {
+		require.Contains(t, q, "'folder1' in parents")
+	}
+
+	_, err = dir.StatChild("Sub1/File1")
+	require.ErrorIs(t, err, ErrInvalidChildName)
+}
+
+func TestOnUnauthorized(t *testing.T) {
+	var valid int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case atomic.LoadInt32(&valid) == 0:
+			writeAPIError(w, http.StatusUnauthorized, "authError")
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	_, err := driver.Stat("File1")
+	require.ErrorIs(t, err, ErrReauthRequired)
+
+	var calls int32
+
+	driver.OnUnauthorized = func() error {
+		atomic.AddInt32(&calls, 1)
+		atomic.StoreInt32(&valid, 1)
+
+		return nil
+	}
+
+	fi, err := driver.Stat("File1")
+	require.NoError(t, err)
+	require.Equal(t, "File1", fi.Name())
+	require.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestListRich(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Dir1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder},
+			}})
+		case q == "'dir1' in parents and trashed = false":
+			require.Equal(t, "files(id,name,mimeType,permissions,capabilities/canEdit),nextPageToken",
+				r.URL.Query().Get("fields"))
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{{
+				Id:           "file1",
+				Name:         "File1",
+				Permissions:  []*drive.Permission{{Role: "reader", Type: "anyone"}},
+				Capabilities: &drive.FileCapabilities{CanEdit: true},
+			}}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	files, err := driver.ListRich("Dir1", "permissions", "capabilities/canEdit")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "anyone", files[0].Permissions[0].Type)
+	require.True(t, files[0].Capabilities.CanEdit)
+}
+
+func TestTrashChildren(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		trashed []string
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Dir1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder},
+			}})
+		case q == "'dir1' in parents and trashed = false":
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1"},
+				{Id: "sub1", Name: "Sub1"},
+				{Id: "locked", Name: "File1"},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/locked":
+			writeAPIError(w, http.StatusForbidden, "insufficientFilePermissions")
+		case r.Method == http.MethodPatch:
+			var patch drive.File
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+			require.True(t, patch.Trashed)
+
+			id := strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")
+
+			mu.Lock()
+			trashed = append(trashed, id)
+			mu.Unlock()
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: id, Parents: []string{"dir1"}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	results, err := driver.TrashChildren("Dir1")
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.NoError(t, results["file1"])
+	require.NoError(t, results["sub1"])
+	require.Error(t, results["locked"])
+	require.ElementsMatch(t, []string{"file1", "sub1"}, trashed)
+	require.Equal(t, 1, int(*driver.srvWrapper.calls["Batch"]))
+}
+
+// rootAliasHandler serves a folder named "Alias" that resolves to the root folder itself
+func rootAliasHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.Contains(r.URL.Query().Get("q"), "name='Alias'"):
+		writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+			{Id: fakeRootID, Name: "My Drive", MimeType: mimeTypeFolder, Parents: []string{}},
+		}})
+	case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+		writeJSON(w, http.StatusOK, &drive.FileList{})
+	default:
+		writeAPIError(w, http.StatusForbidden, "unexpected call")
+	}
+}
+
+func TestRootResolution(t *testing.T) {
+	driver := newFakeDriver(t, rootAliasHandler)
+
+	require.Equal(t, fakeRootID, driver.rootNode.ID())
+
+	driveRoot := driver.driveRoot
+	_, err := driver.SetRootDirectory("")
+	require.NoError(t, err)
+	require.Same(t, driveRoot, driver.driveRoot)
+
+	fi, err := driver.getFile("Alias")
+	require.NoError(t, err)
+	require.True(t, driver.isRoot(fi))
+
+	require.ErrorIs(t, driver.DeleteDirectory(""), ErrForbiddenOnRoot)
+	require.ErrorIs(t, driver.DeleteDirectory("Alias"), ErrForbiddenOnRoot)
+	require.ErrorIs(t, driver.Rename("Alias", "Renamed"), ErrForbiddenOnRoot)
+}
+
+func TestRootProtection(t *testing.T) {
+	driver := newFakeDriver(t, rootAliasHandler)
+
+	for _, p := range []string{"", "/", "Folder1/..", "Alias"} {
+		require.ErrorIs(t, driver.RemoveAll(p), ErrForbiddenOnRoot, "path: %q", p)
+		require.ErrorIs(t, driver.Remove(p), ErrForbiddenOnRoot, "path: %q", p)
+		require.ErrorIs(t, driver.DeleteDirectory(p), ErrForbiddenOnRoot, "path: %q", p)
+		require.ErrorIs(t, driver.Rename(p, "Renamed"), ErrForbiddenOnRoot, "path: %q", p)
+	}
+
+	_, err := driver.createFile("Alias")
+	require.ErrorIs(t, err, ErrForbiddenOnRoot)
+
+	results, err := driver.MoveMany([]string{"Alias"}, "")
+	require.NoError(t, err)
+	require.ErrorIs(t, results["Alias"], ErrForbiddenOnRoot)
+}
+
+func TestRootPaths(t *testing.T) {
+	driver := newFakeDriver(t, rootAliasHandler)
+
+	for _, p := range []string{"", "/", "//", "./"} {
+		stat, err := driver.Stat(p)
+		require.NoError(t, err, "path: %q", p)
+		require.True(t, stat.IsDir(), "path: %q", p)
+		require.Equal(t, fakeRootID, stat.(*FileInfo).ID(), "path: %q", p)
+
+		f, err := driver.Open(p)
+		require.NoError(t, err, "path: %q", p)
+		require.Equal(t, "", f.(*File).Path, "path: %q", p)
+		require.NoError(t, f.Close())
+
+		require.NoError(t, driver.Mkdir(p, os.FileMode(0)), "path: %q", p)
+		require.NoError(t, driver.MkdirAll(p, os.FileMode(0)), "path: %q", p)
+
+		require.ErrorIs(t, driver.Remove(p), ErrForbiddenOnRoot, "path: %q", p)
+		require.ErrorIs(t, driver.RemoveAll(p), ErrForbiddenOnRoot, "path: %q", p)
+		require.ErrorIs(t, driver.Rename(p, "Renamed"), ErrForbiddenOnRoot, "path: %q", p)
+		require.ErrorIs(t, driver.Rename("Alias", p), ErrEmptyPath, "path: %q", p)
+	}
+}
+
+func TestAddToFolder(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		parents = []string{fakeRootID}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Parents: append([]string{}, parents...)},
+			}})
+		case strings.Contains(q, "name='Folder1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			}})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/file1":
+			if add := r.URL.Query().Get("addParents"); add != "" {
+				parents = append(parents, add)
+			}
+
+			if remove := r.URL.Query().Get("removeParents"); remove != "" {
+				kept := parents[:0]
+
+				for _, p := range parents {
+					if p != remove {
+						kept = append(kept, p)
+					}
+				}
+
+				parents = kept
+			}
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Parents: parents})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	require.NoError(t, driver.AddToFolder("File1", "Folder1"))
+	require.Equal(t, []string{fakeRootID, "folder1"}, parents)
+
+	require.NoError(t, driver.RemoveFromFolder("File1", ""))
+	require.Equal(t, []string{"folder1"}, parents)
+
+	require.ErrorIs(t, driver.RemoveFromFolder("Folder1/File1", "Folder1"), ErrSingleParent)
+	require.EqualError(t, driver.RemoveFromFolder("Folder1/File1", "/"), "`File1' does not exist")
+
+	driver.srvWrapper.sharedDriveID = "shared-drive-id"
+	require.ErrorIs(t, driver.AddToFolder("Folder1/File1", ""), ErrSingleParent)
+}
+
+func TestDownloadProgress(t *testing.T) {
+	content := make([]byte, 10*1024)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+
+	var reports []int64
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: int64(len(content))},
+			}})
+		case r.URL.Path == "/drive/v3/files/file1":
+			_, _ = w.Write(content)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}, WithDownloadProgress(func(downloaded, total int64) {
+		require.EqualValues(t, len(content), total)
+		reports = append(reports, downloaded)
+	}))
+
+	f, err := driver.Open("File1")
+	require.NoError(t, err)
+
+	// Small reads, so that several reports are made
+	read, err := io.Copy(io.Discard, io.LimitReader(f, 1024))
+	require.NoError(t, err)
+	require.EqualValues(t, 1024, read)
+
+	_, err = io.Copy(io.Discard, f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.Greater(t, len(reports), 1)
+	require.True(t, sort.SliceIsSorted(reports, func(i, j int) bool { return reports[i] < reports[j] }))
+	require.EqualValues(t, len(content), reports[len(reports)-1])
+}
+
+func TestFileReopen(t *testing.T) {
+	const content = "Hello World"
+
+	var failing int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: int64(len(content))},
+			}})
+		case r.URL.Path == "/drive/v3/files/file1":
+			if atomic.LoadInt32(&failing) == 1 {
+				writeAPIError(w, http.StatusServiceUnavailable, "backendError")
+
+				return
+			}
+
+			var start int
+			if rng := r.Header.Get("Range"); rng != "" {
+				_, err := fmt.Sscanf(rng, "bytes=%d-", &start)
+				require.NoError(t, err)
+			}
+
+			_, _ = w.Write([]byte(content[start:]))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	f, err := driver.Open("File1")
+	require.NoError(t, err)
+
+	file := f.(*File)
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(file, buf)
+	require.NoError(t, err)
+	require.EqualValues(t, 6, file.Offset())
+
+	// A failed attempt doesn't lose the position
+	atomic.StoreInt32(&failing, 1)
+	require.Error(t, file.Reopen())
+	atomic.StoreInt32(&failing, 0)
+	require.NoError(t, file.Reopen())
+
+	rest, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, "World", string(rest))
+	require.EqualValues(t, len(content), file.Offset())
+
+	_, err = file.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, file.Offset())
+
+	require.NoError(t, file.Close())
+	require.ErrorIs(t, file.Reopen(), afero.ErrFileClosed)
+}
+
+func TestFileReadAt(t *testing.T) {
+	const content = "Hello World"
+
+	var downloads int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: int64(len(content))},
+			}})
+		case r.URL.Path == "/drive/v3/files/file1":
+			atomic.AddInt32(&downloads, 1)
+
+			start, end := 0, len(content)-1
+			if rng := r.Header.Get("Range"); rng != "" {
+				_, _ = fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			}
+
+			if end >= len(content) {
+				end = len(content) - 1
+			}
+
+			_, _ = w.Write([]byte(content[start : end+1]))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	f, err := driver.Open("File1")
+	require.NoError(t, err)
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.Equal(t, "Hel", string(buf))
+
+	buf = make([]byte, 5)
+	n, err := f.ReadAt(buf, 6)
+	require.NoError(t, err)
+	require.Equal(t, "World", string(buf[:n]))
+
+	// The sequential read continues where it was
+	buf = make([]byte, 3)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.Equal(t, "lo ", string(buf))
+	require.EqualValues(t, 6, f.(*File).Offset())
+
+	// Reading past the end returns what is available
+	buf = make([]byte, 5)
+	n, err = f.ReadAt(buf, 9)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, "ld", string(buf[:n]))
+
+	_, err = f.ReadAt(buf, int64(len(content)))
+	require.ErrorIs(t, err, io.EOF)
+
+	rest, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "World", string(rest))
+
+	// The main stream was opened once, each ReadAt needs its own download
+	require.EqualValues(t, 3, atomic.LoadInt32(&downloads))
+	require.NoError(t, f.Close())
+}
+
+func TestSeekForward(t *testing.T) {
+	const content = "Hello World"
+
+	var downloads int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: int64(len(content))},
+			}})
+		case r.URL.Path == "/drive/v3/files/file1":
+			atomic.AddInt32(&downloads, 1)
+
+			var start int
+			if rng := r.Header.Get("Range"); rng != "" {
+				_, err := fmt.Sscanf(rng, "bytes=%d-", &start)
+				require.NoError(t, err)
+			}
+
+			_, _ = w.Write([]byte(content[start:]))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}, SeekForward(4))
+
+	f, err := driver.Open("File1")
+	require.NoError(t, err)
+
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.Equal(t, "He", string(buf))
+
+	// A small forward seek discards the bytes from the current download
+	pos, err := f.Seek(3, io.SeekCurrent)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, pos)
+	require.EqualValues(t, 1, atomic.LoadInt32(&downloads))
+
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.Equal(t, " W", string(buf))
+
+	// Larger and backward seeks start a new download
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, atomic.LoadInt32(&downloads))
+
+	_, err = f.Seek(9, io.SeekStart)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, atomic.LoadInt32(&downloads))
+
+	rest, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "ld", string(rest))
+	require.NoError(t, f.Close())
+}
+
+func TestDownloadToFile(t *testing.T) {
+	const content = "Hello World"
+
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{{
+				Id:           "file1",
+				Name:         "File1",
+				MimeType:     mimeTypeFile,
+				Size:         int64(len(content)),
+				ModifiedTime: modTime.Format(time.RFC3339),
+			}}})
+		case r.URL.Path == "/drive/v3/files/file1":
+			require.Equal(t, "media", r.URL.Query().Get("alt"))
+			_, _ = w.Write([]byte(content))
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	dst := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(dst, "copy.txt", []byte("previous longer content"), 0o600))
+
+	written, err := driver.DownloadToFile("File1", dst, "copy.txt")
+	require.NoError(t, err)
+	require.EqualValues(t, len(content), written)
+
+	data, err := afero.ReadFile(dst, "copy.txt")
+	require.NoError(t, err)
+	require.Equal(t, content, string(data))
+
+	stat, err := dst.Stat("copy.txt")
+	require.NoError(t, err)
+	require.True(t, modTime.Equal(stat.ModTime()))
+
+	_, err = driver.DownloadToFile("Missing", dst, "missing.txt")
+	require.True(t, IsNotExist(err))
+
+	exists, err := afero.Exists(dst, "missing.txt")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestResolvePath(t *testing.T) {
+	var lookups int32
+
+	children := map[string]*drive.File{
+		fakeRootID + "/Folder1": {Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+		"folder1/Sub":           {Id: "sub", Name: "Sub", MimeType: mimeTypeFolder},
+		"sub/File1":             {Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: 12},
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/drive/v3/files" {
+			writeAPIError(w, http.StatusNotFound, "notFound")
+
+			return
+		}
+
+		atomic.AddInt32(&lookups, 1)
+
+		var parent, name string
+		_, err := fmt.Sscanf(r.URL.Query().Get("q"), "'%s in parents and name=%s and trashed = false", &parent, &name)
+		require.NoError(t, err)
+		require.Contains(t, r.URL.Query().Get("fields"), "name")
+
+		list := &drive.FileList{}
+		if f := children[strings.Trim(parent, "'")+"/"+strings.Trim(name, "'")]; f != nil {
+			list.Files = []*drive.File{f}
+		}
+
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	crumbs, err := driver.ResolvePath("/Folder1/Sub/File1")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, atomic.LoadInt32(&lookups))
+	require.Len(t, crumbs, 4)
+
+	require.Equal(t, fakeRootID, crumbs[0].ID())
+
+	for i, expected := range []struct{ id, path string }{
+		{"folder1", "Folder1"},
+		{"sub", "Folder1/Sub"},
+		{"file1", "Folder1/Sub/File1"},
+	} {
+		require.Equal(t, expected.id, crumbs[i+1].ID())
+		require.Equal(t, expected.path, crumbs[i+1].Path())
+	}
+
+	require.True(t, crumbs[2].IsDir())
+	require.False(t, crumbs[3].IsDir())
+
+	crumbs, err = driver.ResolvePath("")
+	require.NoError(t, err)
+	require.Len(t, crumbs, 1)
+
+	_, err = driver.ResolvePath("Folder1/Missing/File1")
+	require.True(t, IsNotExist(err))
+}
+
+func TestCreateNewFile(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		files    = map[string]*drive.File{}
+		contents = map[string][]byte{}
+	)
+
+	readMedia := func(r *http.Request) (*drive.File, []byte) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		metadata := &drive.File{}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(part).Decode(metadata))
+		media, err := mr.NextPart()
+		require.NoError(t, err)
+		content, err := io.ReadAll(media)
+		require.NoError(t, err)
+
+		return metadata, content
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+
+			for name, f := range files {
+				if strings.Contains(r.URL.Query().Get("q"), "name='"+name+"'") {
+					list.Files = append(list.Files, f)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			metadata, content := readMedia(r)
+			metadata.Id = "id-" + metadata.Name
+			files[metadata.Name] = metadata
+			contents[metadata.Id] = content
+			writeJSON(w, http.StatusOK, metadata)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files/"):
+			id := path.Base(r.URL.Path)
+			_, content := readMedia(r)
+			contents[id] = content
+			writeJSON(w, http.StatusOK, &drive.File{Id: id, Size: int64(len(content))})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+			_, _ = w.Write(contents[path.Base(r.URL.Path)])
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	file, err := driver.Create("New")
+	require.NoError(t, err)
+
+	_, err = file.Write([]byte("Hello World"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	file, err = driver.Open("New")
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, "Hello World", string(content))
+	require.NoError(t, file.Close())
+}
+
+func TestWriteBufferInstalled(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		uploaded []byte
+		uploads  int32
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/upload/drive/v3/files/file1":
+			atomic.AddInt32(&uploads, 1)
+
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			_, err = mr.NextPart() // metadata
+			require.NoError(t, err)
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			uploaded, err = io.ReadAll(media)
+			require.NoError(t, err)
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1", Size: int64(len(uploaded))})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	driver.WriteBufferType = WriteBufferAsync
+	driver.WriteBufferSize = 1024
+
+	f, err := driver.OpenFile("File1", os.O_WRONLY, os.FileMode(0))
+	require.NoError(t, err)
+	require.IsType(t, &iohelper.AsyncWriterBuffer{}, f.(*File).streamWrite)
+
+	_, err = f.Write([]byte("Hello World"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "Hello World", string(uploaded))
+
+	driver.WriteBufferType = WriteBufferType("unknown")
+
+	_, err = driver.OpenFile("File1", os.O_WRONLY, os.FileMode(0))
+	require.ErrorIs(t, err, ErrUnknownBufferType)
+
+	// The canceled upload must not replace the content
+	require.EqualValues(t, 1, atomic.LoadInt32(&uploads))
+}
+
+func TestTarFolder(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC).Format(time.RFC3339)
+	contents := map[string]string{"file1": "Hello World", "file2": "Bye"}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Folder1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			}})
+		case q == "'folder1' in parents and trashed = false":
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "sub", Name: "Sub", MimeType: mimeTypeFolder, ModifiedTime: modTime},
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: 11, ModifiedTime: modTime,
+					Properties: map[string]string{fileModeProperty: "384"}},
+				{Id: "doc", Name: "Doc", MimeType: mimeTypeNativePrefix + "document"},
+				{Id: "shortcut", Name: "Shortcut", MimeType: mimeTypeShortcut},
+			}})
+		case q == "'sub' in parents and trashed = false":
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file2", Name: "File2", MimeType: mimeTypeFile, Size: 3, ModifiedTime: modTime},
+			}})
+		case strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+			_, _ = w.Write([]byte(contents[path.Base(r.URL.Path)]))
+		case r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, driver.TarFolder("Folder1", &buf))
+
+	type entry struct {
+		name    string
+		mode    int64
+		content string
+	}
+
+	var entries []entry
+
+	tr := tar.NewReader(&buf)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, modTime, header.ModTime.UTC().Format(time.RFC3339))
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+
+		entries = append(entries, entry{header.Name, header.Mode, string(content)})
+	}
+
+	require.Equal(t, []entry{
+		{"File1", 0o600, "Hello World"},
+		{"Sub/", 0o755, ""},
+		{"Sub/File2", 0o644, "Bye"},
+	}, entries)
+
+	err := driver.TarFolder("Folder1/Missing", &buf)
+	require.True(t, IsNotExist(err))
+}
+
+func TestReaddirnames(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Folder1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			}})
+		case strings.HasPrefix(q, "'folder1' in parents"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+				{Id: "file2", Name: "File2", MimeType: mimeTypeFile},
+				{Id: "sub", Name: "Sub", MimeType: mimeTypeFolder},
+			}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	dir, err := driver.Open("Folder1")
+	require.NoError(t, err)
+
+	names, err := dir.Readdirnames(100)
+	require.NoError(t, err)
+	require.Equal(t, []string{"File1", "File2", "Sub"}, names)
+}
+
+func TestOpenReadWrite(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		files = map[string]*drive.File{
+			"File1": {Id: "id-File1", Name: "File1", MimeType: mimeTypeFile, Size: 11},
+		}
+		contents = map[string][]byte{"id-File1": []byte("Hello World")}
+	)
+
+	readMedia := func(r *http.Request) (*drive.File, []byte) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		metadata := &drive.File{}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(part).Decode(metadata))
+		media, err := mr.NextPart()
+		require.NoError(t, err)
+		content, err := io.ReadAll(media)
+		require.NoError(t, err)
+
+		return metadata, content
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+
+			for name, f := range files {
+				if strings.Contains(r.URL.Query().Get("q"), "name='"+name+"'") {
+					list.Files = append(list.Files, f)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			metadata, content := readMedia(r)
+			metadata.Id = "id-" + metadata.Name
+			files[metadata.Name] = metadata
+			contents[metadata.Id] = content
+			writeJSON(w, http.StatusOK, metadata)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files/"):
+			id := path.Base(r.URL.Path)
+			_, content := readMedia(r)
+			contents[id] = content
+			writeJSON(w, http.StatusOK, &drive.File{Id: id, Size: int64(len(content))})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+			_, _ = w.Write(contents[path.Base(r.URL.Path)])
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}, ReadWrite())
+
+	f, err := driver.OpenFile("File1", os.O_RDWR, os.FileMode(0))
+	require.NoError(t, err)
+
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.Equal(t, "Hello ", string(buf))
+
+	_, err = f.WriteString("Drive!")
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "Hello Drive!", string(content))
+	require.Equal(t, "Hello World", string(contents["id-File1"]), "nothing is uploaded before closing")
+	require.NoError(t, f.Close())
+	require.Equal(t, "Hello Drive!", string(contents["id-File1"]))
+	require.NoError(t, f.Close())
+
+	f, err = driver.OpenFile("File1", os.O_RDWR|os.O_APPEND, os.FileMode(0))
+	require.NoError(t, err)
+	_, err = f.WriteString(" Bye")
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(16))
+	require.NoError(t, f.Close())
+	require.Equal(t, "Hello Drive! Bye", string(contents["id-File1"]))
+
+	f, err = driver.OpenFile("New", os.O_RDWR|os.O_CREATE, os.FileMode(0))
+	require.NoError(t, err)
+	_, err = f.WriteString("Fresh")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "Fresh", string(contents["id-New"]))
+
+	driver.AllowReadWrite = false
+
+	_, err = driver.OpenFile("File1", os.O_RDWR, os.FileMode(0))
+	require.ErrorIs(t, err, ErrReadAndWriteNotSupported)
+}
+
+func TestRemoveIfExists(t *testing.T) {
+	var deletes int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case strings.Contains(q, "name='Gone'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "gone", Name: "Gone", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodDelete && r.URL.Path == "/drive/v3/files/file1":
+			atomic.AddInt32(&deletes, 1)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete && r.URL.Path == "/drive/v3/files/gone":
+			// Deleted by someone else after the lookup
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		default:
+			writeAPIError(w, http.StatusForbidden, "forbidden")
+		}
+	})
+
+	removed, err := driver.RemoveIfExists("File1")
+	require.NoError(t, err)
+	require.True(t, removed)
+	require.EqualValues(t, 1, atomic.LoadInt32(&deletes))
+
+	removed, err = driver.RemoveIfExists("Missing")
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	removed, err = driver.RemoveIfExists("Gone")
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	_, err = driver.RemoveIfExists("")
+	require.ErrorIs(t, err, ErrForbiddenOnRoot)
+
+	require.True(t, IsNotExist(driver.Remove("Missing")))
+}
+
+func TestExists(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Dir'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dir", Name: "Dir", MimeType: mimeTypeFolder},
+			}})
+		case strings.Contains(q, "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case strings.Contains(q, "name='Broken'"):
+			writeAPIError(w, http.StatusForbidden, "forbidden")
+		default:
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		}
+	})
+
+	for _, tc := range []struct {
+		path       string
+		dirExists  bool
+		fileExists bool
+	}{
+		{path: "Dir", dirExists: true},
+		{path: "File1", fileExists: true},
+		{path: "Missing"},
+	} {
+		exists, err := driver.DirExists(tc.path)
+		require.NoError(t, err)
+		require.Equal(t, tc.dirExists, exists, tc.path)
+
+		exists, err = driver.FileExists(tc.path)
+		require.NoError(t, err)
+		require.Equal(t, tc.fileExists, exists, tc.path)
+	}
+
+	_, err := driver.DirExists("Broken")
+	require.Error(t, err)
+	require.False(t, IsNotExist(err))
+
+	_, err = driver.FileExists("Broken")
+	require.Error(t, err)
+	require.False(t, IsNotExist(err))
+}
+
+func TestOpenAppend(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		files    = map[string]*drive.File{}
+		contents = map[string][]byte{}
+	)
+
+	readMedia := func(r *http.Request) (*drive.File, []byte) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		metadata := &drive.File{}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(part).Decode(metadata))
+		media, err := mr.NextPart()
+		require.NoError(t, err)
+		content, err := io.ReadAll(media)
+		require.NoError(t, err)
+
+		return metadata, content
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+
+			for name, f := range files {
+				if strings.Contains(r.URL.Query().Get("q"), "name='"+name+"'") {
+					list.Files = append(list.Files, f)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			metadata, content := readMedia(r)
+			metadata.Id = "id-" + metadata.Name
+			files[metadata.Name] = metadata
+			contents[metadata.Id] = content
+			writeJSON(w, http.StatusOK, metadata)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files/"):
+			id := path.Base(r.URL.Path)
+			_, content := readMedia(r)
+			contents[id] = content
+
+			for _, f := range files {
+				if f.Id == id {
+					f.Size = int64(len(content))
+				}
+			}
+			writeJSON(w, http.StatusOK, &drive.File{Id: id, Size: int64(len(content))})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+			_, _ = w.Write(contents[path.Base(r.URL.Path)])
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	writeAll := func(flag int, content string) {
+		f, err := driver.OpenFile("File1", flag, os.FileMode(0))
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// O_APPEND on a new file is a regular write
+	writeAll(os.O_WRONLY|os.O_CREATE|os.O_APPEND, "Hello")
+	require.Equal(t, "Hello", string(contents["id-File1"]))
+
+	writeAll(os.O_WRONLY|os.O_APPEND, " World")
+	require.Equal(t, "Hello World", string(contents["id-File1"]))
+
+	// Without O_APPEND, the content is replaced
+	writeAll(os.O_WRONLY, "Bye")
+	require.Equal(t, "Bye", string(contents["id-File1"]))
+}
+
+func TestOpenExclusive(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		files    = map[string]*drive.File{}
+		contents = map[string][]byte{}
+	)
+
+	readMedia := func(r *http.Request) (*drive.File, []byte) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		metadata := &drive.File{}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(part).Decode(metadata))
+		media, err := mr.NextPart()
+		require.NoError(t, err)
+		content, err := io.ReadAll(media)
+		require.NoError(t, err)
+
+		return metadata, content
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+
+			for name, f := range files {
+				if strings.Contains(r.URL.Query().Get("q"), "name='"+name+"'") {
+					list.Files = append(list.Files, f)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			metadata, content := readMedia(r)
+			metadata.Id = "id-" + metadata.Name
+			files[metadata.Name] = metadata
+			contents[metadata.Id] = content
+			writeJSON(w, http.StatusOK, metadata)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files/"):
+			id := path.Base(r.URL.Path)
+			_, content := readMedia(r)
+			contents[id] = content
+			writeJSON(w, http.StatusOK, &drive.File{Id: id, Size: int64(len(content))})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+			_, _ = w.Write(contents[path.Base(r.URL.Path)])
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+
+	file, err := driver.OpenFile("Lock", flag, os.FileMode(0))
+	require.NoError(t, err)
+
+	_, err = file.Write([]byte("pid"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.Equal(t, "pid", string(contents["id-Lock"]))
+
+	_, err = driver.OpenFile("Lock", flag, os.FileMode(0))
+	require.True(t, IsExist(err))
+	require.False(t, IsNotExist(err))
+	require.Equal(t, "pid", string(contents["id-Lock"]))
+
+	// Without O_CREATE, O_EXCL has no effect
+	file, err = driver.OpenFile("Lock", os.O_RDONLY|os.O_EXCL, os.FileMode(0))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
+
+func TestListSharedDrives(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/drive/v3/drives" {
+			writeAPIError(w, http.StatusNotFound, "notFound")
+
+			return
+		}
+
+		switch r.URL.Query().Get("pageToken") {
+		case "":
+			writeJSON(w, http.StatusOK, &drive.DriveList{
+				Drives: []*drive.Drive{
+					{Id: "drive1", Name: "Team", Capabilities: &drive.DriveCapabilities{CanAddChildren: true}},
+				},
+				NextPageToken: "page2",
+			})
+		case "page2":
+			writeJSON(w, http.StatusOK, &drive.DriveList{
+				Drives: []*drive.Drive{{Id: "drive2", Name: "Archive", Capabilities: &drive.DriveCapabilities{}}},
+			})
+		default:
+			writeAPIError(w, http.StatusBadRequest, "badRequest")
+		}
+	})
+
+	drives, err := driver.ListSharedDrives()
+	require.NoError(t, err)
+	require.Len(t, drives, 2)
+	require.Equal(t, "drive1", drives[0].ID)
+	require.Equal(t, "Team", drives[0].Name)
+	require.True(t, drives[0].Capabilities.CanAddChildren)
+	require.Equal(t, "drive2", drives[1].ID)
+	require.False(t, drives[1].Capabilities.CanAddChildren)
+}
+
+func TestFileTruncate(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		files = map[string]*drive.File{
+			"File1": {Id: "id-File1", Name: "File1", MimeType: mimeTypeFile, Size: 11},
+		}
+		contents = map[string][]byte{"id-File1": []byte("Hello World")}
+	)
+
+	readMedia := func(r *http.Request) (*drive.File, []byte) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		metadata := &drive.File{}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(part).Decode(metadata))
+		media, err := mr.NextPart()
+		require.NoError(t, err)
+		content, err := io.ReadAll(media)
+		require.NoError(t, err)
+
+		return metadata, content
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+
+			for name, f := range files {
+				if strings.Contains(r.URL.Query().Get("q"), "name='"+name+"'") {
+					list.Files = append(list.Files, f)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			metadata, content := readMedia(r)
+			metadata.Id = "id-" + metadata.Name
+			files[metadata.Name] = metadata
+			contents[metadata.Id] = content
+			writeJSON(w, http.StatusOK, metadata)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files/"):
+			id := path.Base(r.URL.Path)
+			_, content := readMedia(r)
+			contents[id] = content
+
+			for _, f := range files {
+				if f.Id == id {
+					f.Size = int64(len(content))
+				}
+			}
+			writeJSON(w, http.StatusOK, &drive.File{Id: id, Size: int64(len(content))})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+			_, _ = w.Write(contents[path.Base(r.URL.Path)])
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	truncate := func(size int64, content string) {
+		f, err := driver.OpenFile("File1", os.O_WRONLY, os.FileMode(0))
+		require.NoError(t, err)
+		require.NoError(t, f.Truncate(size))
+
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	truncate(5, "")
+	require.Equal(t, "Hello", string(contents["id-File1"]))
+
+	truncate(7, "!")
+	require.Equal(t, "Hello\x00\x00!", string(contents["id-File1"]))
+
+	truncate(5, " Drive")
+	require.Equal(t, "Hello Drive", string(contents["id-File1"]))
+
+	truncate(0, "")
+	require.Empty(t, contents["id-File1"])
+
+	f, err := driver.OpenFile("File1", os.O_WRONLY, os.FileMode(0))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("Hello"))
+	require.NoError(t, err)
+	require.ErrorIs(t, f.Truncate(2), ErrNotSupported)
+	require.NoError(t, f.Close())
+
+	f, err = driver.Open("File1")
+	require.NoError(t, err)
+	require.ErrorIs(t, f.Truncate(0), ErrNotSupported)
+	require.NoError(t, f.Close())
+}
+
+func TestGetFileHash(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		files = map[string]*drive.File{
+			"Folder1": {Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			"Doc":     {Id: "doc", Name: "Doc", MimeType: mimeTypeNativePrefix + "document"},
+		}
+		contents = map[string][]byte{}
+	)
+
+	readMedia := func(r *http.Request) (*drive.File, []byte) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		metadata := &drive.File{}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(part).Decode(metadata))
+		media, err := mr.NextPart()
+		require.NoError(t, err)
+		content, err := io.ReadAll(media)
+		require.NoError(t, err)
+
+		return metadata, content
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+
+			for name, f := range files {
+				if strings.Contains(r.URL.Query().Get("q"), "name='"+name+"'") {
+					f := *f
+					if !strings.Contains(r.URL.Query().Get("fields"), "md5Checksum") {
+						f.Md5Checksum = ""
+					}
+
+					list.Files = append(list.Files, &f)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			metadata, content := readMedia(r)
+			metadata.Id = "id-" + metadata.Name
+
+			if metadata.MimeType != mimeTypeFolder {
+				sum := md5.Sum(content) // nolint: gosec
+				metadata.Md5Checksum = hex.EncodeToString(sum[:])
+			}
+			files[metadata.Name] = metadata
+			contents[metadata.Id] = content
+			writeJSON(w, http.StatusOK, metadata)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files/"):
+			id := path.Base(r.URL.Path)
+			_, content := readMedia(r)
+			contents[id] = content
+			writeJSON(w, http.StatusOK, &drive.File{Id: id, Size: int64(len(content))})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+			_, _ = w.Write(contents[path.Base(r.URL.Path)])
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	content := []byte("Hello World")
+
+	_, err := driver.CreateWith("File1", &drive.File{}, bytes.NewReader(content))
+	require.NoError(t, err)
+
+	expected := md5.Sum(content) // nolint: gosec
+
+	hash, err := driver.GetFileHash("File1", HashMD5)
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(expected[:]), hash)
+
+	var unavailable *ChecksumUnavailableError
+
+	_, err = driver.GetFileHash("File1", HashSHA256)
+	require.ErrorAs(t, err, &unavailable)
+
+	_, err = driver.GetFileHash("Doc", HashMD5)
+	require.ErrorAs(t, err, &unavailable)
+
+	_, err = driver.GetFileHash("Folder1", HashMD5)
+	require.ErrorAs(t, err, &FileIsDirectoryError{})
+
+	_, err = driver.GetFileHash("File1", HashMethod(42))
+	require.ErrorIs(t, err, ErrUnknownHashMethod)
+}
+
+func TestFileInfoLinks(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		files = map[string]*drive.File{
+			"Folder1": {
+				Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder,
+				WebViewLink: "https://drive.google.com/drive/folders/folder1",
+			},
+		}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fields := r.URL.Query().Get("fields")
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+
+			for name, f := range files {
+				if strings.Contains(r.URL.Query().Get("q"), "name='"+name+"'") {
+					require.Contains(t, fields, "md5Checksum")
+					require.Contains(t, fields, "webViewLink")
+					require.Contains(t, fields, "webContentLink")
+					list.Files = append(list.Files, f)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			metadata := &drive.File{}
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			require.NoError(t, json.NewDecoder(part).Decode(metadata))
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			content, err := io.ReadAll(media)
+			require.NoError(t, err)
+
+			sum := md5.Sum(content) // nolint: gosec
+			metadata.Id = "id-" + metadata.Name
+			metadata.Size = int64(len(content))
+			metadata.Md5Checksum = hex.EncodeToString(sum[:])
+			metadata.WebViewLink = "https://drive.google.com/file/d/" + metadata.Id + "/view"
+			metadata.WebContentLink = "https://drive.google.com/uc?id=" + metadata.Id + "&export=download"
+			files[metadata.Name] = metadata
+
+			writeJSON(w, http.StatusOK, metadata)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	content := []byte("Hello World")
+	expected := md5.Sum(content) // nolint: gosec
+
+	created, err := driver.CreateWith("File1", &drive.File{}, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(expected[:]), created.MD5())
+
+	stat, err := driver.Stat("File1")
+	require.NoError(t, err)
+
+	fi, ok := stat.(*FileInfo)
+	require.True(t, ok)
+	require.Equal(t, hex.EncodeToString(expected[:]), fi.MD5())
+	require.Equal(t, "https://drive.google.com/file/d/id-File1/view", fi.WebViewLink())
+	require.Equal(t, "https://drive.google.com/uc?id=id-File1&export=download", fi.WebContentLink())
+
+	stat, err = driver.Stat("Folder1")
+	require.NoError(t, err)
+
+	fi, ok = stat.(*FileInfo)
+	require.True(t, ok)
+	require.Empty(t, fi.MD5())
+	require.Empty(t, fi.WebContentLink())
+	require.Equal(t, "https://drive.google.com/drive/folders/folder1", fi.WebViewLink())
+}
+
+func TestWriteLifecycle(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		uploaded []byte
+		uploads  int32
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/upload/drive/v3/files/file1":
+			atomic.AddInt32(&uploads, 1)
+
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			_, err = mr.NextPart() // metadata
+			require.NoError(t, err)
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			uploaded, err = io.ReadAll(media)
+			require.NoError(t, err)
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1", Size: int64(len(uploaded))})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files/file1":
+			_, _ = w.Write(uploaded)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	f, err := driver.OpenFile("File1", os.O_WRONLY, os.FileMode(0))
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("Hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "Hello", string(uploaded))
+
+	_, err = f.Write([]byte("World"))
+	require.ErrorIs(t, err, afero.ErrFileClosed)
+	require.NoError(t, f.Close())
+
+	// Once the upload ended, nothing can be written anymore but its result is still given by Close
+	f, err = driver.OpenFile("File1", os.O_WRONLY, os.FileMode(0))
+	require.NoError(t, err)
+
+	errUpload := errors.New("upload failed")
+	f.(*File).streamWriteEnd <- errUpload
+
+	_, err = f.Write([]byte("World"))
+	require.ErrorIs(t, err, afero.ErrFileClosed)
+	require.ErrorIs(t, f.Close(), errUpload)
+	require.ErrorIs(t, f.Close(), errUpload)
+	require.EqualValues(t, 1, atomic.LoadInt32(&uploads))
+
+	f, err = driver.Open("File1")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = f.Read(make([]byte, 1))
+	require.ErrorIs(t, err, afero.ErrFileClosed)
+	require.NoError(t, f.Close())
+}
+
+func TestReadFiles(t *testing.T) {
+	var inFlight, maxInFlight int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='File"):
+			var name string
+			_, err := fmt.Sscanf(q[strings.Index(q, "name='")+6:], "%5s", &name)
+			require.NoError(t, err)
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "id-" + name, Name: name, MimeType: mimeTypeFile, Size: 9},
+			}})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case strings.HasPrefix(r.URL.Path, "/drive/v3/files/id-"):
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+
+			for {
+				previous := atomic.LoadInt32(&maxInFlight)
+				if current <= previous || atomic.CompareAndSwapInt32(&maxInFlight, previous, current) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			_, _ = w.Write([]byte("content-" + strings.TrimPrefix(path.Base(r.URL.Path), "id-File")))
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	paths := []string{"File1", "File2", "File3", "File4", "File5", "Missing"}
+
+	contents, errs := driver.ReadFiles(paths, 2)
+	require.Len(t, contents, 5)
+	require.Len(t, errs, 1)
+	require.True(t, IsNotExist(errs["Missing"]))
+	require.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(2))
+
+	for i := 1; i <= 5; i++ {
+		require.Equal(t, fmt.Sprintf("content-%d", i), string(contents[fmt.Sprintf("File%d", i)]))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	contents, errs = driver.ReadFilesContext(ctx, paths, 2)
+	require.Empty(t, contents)
+	require.Len(t, errs, len(paths))
+
+	for _, p := range paths {
+		require.ErrorIs(t, errs[p], context.Canceled)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case strings.Contains(q, "name='Slow'"):
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+
+			writeAPIError(w, http.StatusServiceUnavailable, "backendError")
+		case strings.Contains(q, "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/upload/drive/v3/files/file1":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1"})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	t.Run("pending call", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		scoped, err := driver.WithContext(ctx)
+		require.NoError(t, err)
+
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err = scoped.Stat("Slow")
+		require.ErrorIs(t, err, context.Canceled)
+		require.Less(t, time.Since(start), time.Second)
+
+		_, err = scoped.Stat("File1")
+		require.ErrorIs(t, err, context.Canceled)
+
+		// The original driver isn't affected
+		_, err = driver.Stat("File1")
+		require.NoError(t, err)
+	})
+
+	t.Run("pending upload", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		scoped, err := driver.WithContext(ctx)
+		require.NoError(t, err)
+
+		f, err := scoped.OpenFile("File1", os.O_WRONLY, os.FileMode(0))
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("Hello"))
+		require.NoError(t, err)
+
+		cancel()
+
+		start := time.Now()
+		require.ErrorIs(t, f.Close(), context.Canceled)
+		require.Less(t, time.Since(start), time.Second)
+		require.NoError(t, scoped.Shutdown(context.Background()))
+	})
+}
+
+func TestRetry(t *testing.T) {
+	retryHandler := func(failures int32, status int, calls *int32) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !strings.Contains(r.URL.Query().Get("q"), "name='File1'") {
+				writeAPIError(w, http.StatusNotFound, "notFound")
+
+				return
+			}
+
+			if atomic.AddInt32(calls, 1) <= failures {
+				writeAPIError(w, status, "rateLimitExceeded")
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		}
+	}
+
+	t.Run("eventually succeeds", func(t *testing.T) {
+		var calls int32
+
+		driver := newFakeDriver(t, retryHandler(2, http.StatusTooManyRequests, &calls), Retry(3, time.Millisecond))
+
+		fi, err := driver.Stat("File1")
+		require.NoError(t, err)
+		require.Equal(t, "File1", fi.Name())
+		require.EqualValues(t, 3, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		var calls int32
+
+		driver := newFakeDriver(t, retryHandler(5, http.StatusServiceUnavailable, &calls), Retry(2, time.Millisecond))
+
+		_, err := driver.Stat("File1")
+
+		var apiErr *googleapi.Error
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusServiceUnavailable, apiErr.Code)
+		require.EqualValues(t, 3, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("forbidden rate-limit", func(t *testing.T) {
+		var calls int32
+
+		driver := newFakeDriver(t, retryHandler(1, http.StatusForbidden, &calls), Retry(1, time.Millisecond))
+
+		_, err := driver.Stat("File1")
+		require.NoError(t, err)
+		require.EqualValues(t, 2, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("forbidden without a rate-limit", func(t *testing.T) {
+		var calls int32
+
+		driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			writeAPIError(w, http.StatusForbidden, "insufficientFilePermissions")
+		}, Retry(2, time.Millisecond))
+
+		// The reason is still read by the caller
+		_, err := driver.Stat("File1")
+		require.ErrorIs(t, err, ErrPermissionDenied)
+		require.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		var calls int32
+
+		driver := newFakeDriver(t, retryHandler(1, http.StatusTooManyRequests, &calls))
+
+		_, err := driver.Stat("File1")
+		require.True(t, isRateLimitError(err))
+		require.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		delay := retryDelay(resp, time.Second, attempt)
+		require.GreaterOrEqual(t, delay, (time.Second<<attempt)/2)
+		require.LessOrEqual(t, delay, time.Second<<attempt)
+	}
+
+	// The delay is capped, even when doubling it overflows
+	delay := retryDelay(resp, time.Second, 62)
+	require.GreaterOrEqual(t, delay, retryBackoffMax/2)
+	require.LessOrEqual(t, delay, retryBackoffMax)
+
+	resp.Header.Set("Retry-After", "7")
+	require.Equal(t, 7*time.Second, retryDelay(resp, time.Second, 0))
+
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	require.Zero(t, retryDelay(resp, time.Second, 0))
+}
+
+func TestTreeHash(t *testing.T) {
+	const mimeTypeDoc = "application/vnd.google-apps.document"
+
+	trees := map[string][]*drive.File{
+		"a": {
+			{Id: "a-sub", Name: "Sub", MimeType: mimeTypeFolder},
+			{Id: "a-1", Name: "File1", Md5Checksum: "aa", Size: 2},
+		},
+		"a-sub": {
+			{Id: "a-2", Name: "File2", Md5Checksum: "bb", Size: 3},
+			{Id: "a-doc", Name: "Doc", MimeType: mimeTypeDoc},
+		},
+		// Same tree, listed in another order
+		"b": {
+			{Id: "b-1", Name: "File1", Md5Checksum: "aa", Size: 2},
+			{Id: "b-sub", Name: "Sub", MimeType: mimeTypeFolder},
+		},
+		"b-sub": {
+			{Id: "b-doc", Name: "Doc", MimeType: mimeTypeDoc},
+			{Id: "b-2", Name: "File2", Md5Checksum: "bb", Size: 3},
+		},
+		// Same layout, different content
+		"c": {
+			{Id: "c-sub", Name: "Sub", MimeType: mimeTypeFolder},
+			{Id: "c-1", Name: "File1", Md5Checksum: "aa", Size: 2},
+		},
+		"c-sub": {
+			{Id: "c-2", Name: "File2", Md5Checksum: "cc", Size: 3},
+			{Id: "c-doc", Name: "Doc", MimeType: mimeTypeDoc},
+		},
+		// File without checksum
+		"d": {{Id: "d-1", Name: "File1"}},
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		for id := range trees {
+			if strings.Contains(q, "name='"+strings.ToUpper(id)+"'") {
+				writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+					{Id: id, Name: strings.ToUpper(id), MimeType: mimeTypeFolder},
+				}})
+
+				return
+			}
+
+			if strings.HasPrefix(q, "'"+id+"' in parents") {
+				require.Contains(t, r.URL.Query().Get("fields"), "md5Checksum")
+				writeJSON(w, http.StatusOK, &drive.FileList{Files: trees[id]})
+
+				return
+			}
+		}
+
+		writeAPIError(w, http.StatusNotFound, "notFound")
+	})
+
+	hashA, err := driver.TreeHash("A")
+	require.NoError(t, err)
+	require.Len(t, hashA, 64)
+
+	hashB, err := driver.TreeHash("B")
+	require.NoError(t, err)
+	require.Equal(t, hashA, hashB)
+
+	hashC, err := driver.TreeHash("C")
+	require.NoError(t, err)
+	require.NotEqual(t, hashA, hashC)
+
+	_, err = driver.TreeHash("D")
+	require.EqualError(t, err, `checksum of "D/File1" is not available`)
+
+	// Skipping the documents changes the hash
+	driver.GoogleDocPolicy = GoogleDocSkipInListings
+
+	hashSkipped, err := driver.TreeHash("A")
+	require.NoError(t, err)
+	require.NotEqual(t, hashA, hashSkipped)
+}
+
+func TestWalk(t *testing.T) {
+	trees := map[string][]*drive.File{
+		"top": {
+			{Id: "top-c", Name: "C", MimeType: mimeTypeFolder},
+			{Id: "top-1", Name: "File1"},
+			{Id: "top-a", Name: "A", MimeType: mimeTypeFolder},
+		},
+		"top-a": {
+			{Id: "a-2", Name: "File2"},
+			{Id: "a-sub", Name: "Sub", MimeType: mimeTypeFolder},
+			{Id: "a-1", Name: "File1"},
+		},
+		"a-sub": {{Id: "sub-1", Name: "File1"}},
+		"top-c": {
+			{Id: "c-1", Name: "File1"},
+			{Id: "c-2", Name: "File2"},
+		},
+	}
+
+	var listings int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		if strings.Contains(q, "name='Top'") {
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "top", Name: "Top", MimeType: mimeTypeFolder},
+			}})
+
+			return
+		}
+
+		for id, children := range trees {
+			if strings.HasPrefix(q, "'"+id+"' in parents") {
+				atomic.AddInt32(&listings, 1)
+				writeJSON(w, http.StatusOK, &drive.FileList{Files: children})
+
+				return
+			}
+		}
+
+		writeJSON(w, http.StatusOK, &drive.FileList{})
+	})
+
+	walk := func(skip string, skipErr error) []string {
+		var visited []string
+
+		require.NoError(t, driver.Walk("Top", func(path string, info os.FileInfo, err error) error {
+			require.NoError(t, err)
+			visited = append(visited, path)
+
+			if path == skip {
+				return skipErr
+			}
+
+			return nil
+		}))
+
+		return visited
+	}
+
+	t.Run("all", func(t *testing.T) {
+		require.Equal(t, []string{
+			"Top", "Top/A", "Top/A/File1", "Top/A/File2", "Top/A/Sub", "Top/A/Sub/File1",
+			"Top/C", "Top/C/File1", "Top/C/File2", "Top/File1",
+		}, walk("", nil))
+		require.EqualValues(t, 4, atomic.LoadInt32(&listings))
+	})
+
+	t.Run("skip directory", func(t *testing.T) {
+		require.Equal(t, []string{
+			"Top", "Top/A", "Top/C", "Top/C/File1", "Top/C/File2", "Top/File1",
+		}, walk("Top/A", filepath.SkipDir))
+	})
+
+	t.Run("skip from a file", func(t *testing.T) {
+		require.Equal(t, []string{
+			"Top", "Top/A", "Top/A/File1", "Top/C", "Top/C/File1", "Top/C/File2", "Top/File1",
+		}, walk("Top/A/File1", filepath.SkipDir))
+	})
+
+	t.Run("skip all", func(t *testing.T) {
+		require.Equal(t, []string{"Top", "Top/A", "Top/A/File1"}, walk("Top/A/File1", filepath.SkipAll))
+	})
+
+	t.Run("missing root", func(t *testing.T) {
+		errWalk := errors.New("walk")
+
+		err := driver.Walk("Missing", func(path string, info os.FileInfo, err error) error {
+			require.Equal(t, "Missing", path)
+			require.Nil(t, info)
+			require.True(t, IsNotExist(err))
+
+			return errWalk
+		})
+		require.ErrorIs(t, err, errWalk)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	const (
+		qps   = 100
+		burst = 5
+		calls = 30
+	)
+
+	var served int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+		writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+			{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+		}})
+	}, WithRateLimit(qps, burst))
+
+	t.Run("throughput", func(t *testing.T) {
+		var wg sync.WaitGroup
+
+		errs := make(chan error, calls)
+		start := time.Now()
+
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+
+			// Distinct names so that the lookups aren't served by the cache
+			go func(i int) {
+				defer wg.Done()
+
+				_, err := driver.srvWrapper.getFileByFolderAndName(fakeRootID, fmt.Sprintf("File%d", i))
+				errs <- err
+			}(i)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		// The burst is served immediately, the other calls at the configured rate
+		elapsed := time.Since(start)
+		expected := time.Duration(calls-burst) * time.Second / qps
+		require.GreaterOrEqual(t, elapsed, expected*9/10)
+		require.Less(t, elapsed, expected*2)
+		require.EqualValues(t, calls, atomic.LoadInt32(&served))
+	})
+
+	t.Run("canceled wait", func(t *testing.T) {
+		driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		}, WithRateLimit(1, 1))
+
+		// Takes the only token
+		_, err := driver.Stat("File1")
+		require.True(t, IsNotExist(err))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		scoped, err := driver.WithContext(ctx)
+		require.NoError(t, err)
+
+		start := time.Now()
+		_, err = scoped.Stat("File2")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
+
+func TestSetRateLimit(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &drive.FileList{})
+	})
+
+	stat := func(name string) time.Duration {
+		start := time.Now()
+		_, err := driver.Stat(name)
+		require.True(t, IsNotExist(err))
+
+		return time.Since(start)
+	}
+
+	// Unlimited by default
+	for i := 0; i < 10; i++ {
+		require.Less(t, stat(fmt.Sprintf("File%d", i)), 50*time.Millisecond)
+	}
+
+	driver.SetRateLimit(20)
+	require.Less(t, stat("Burst"), 25*time.Millisecond)
+	require.GreaterOrEqual(t, stat("Limited"), 40*time.Millisecond)
+
+	// A waiting call follows the new limit
+	driver.SetRateLimit(0.1)
+
+	done := make(chan time.Duration)
+	go func() { done <- stat("Waiting") }()
+
+	time.Sleep(20 * time.Millisecond)
+	driver.SetRateLimit(0)
+
+	select {
+	case elapsed := <-done:
+		require.Less(t, elapsed, time.Second)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the call wasn't released")
+	}
+}
+
+func TestViewedByMe(t *testing.T) {
+	atime := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		fields := r.URL.Query().Get("fields")
+		file := &drive.File{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Parents: []string{fakeRootID}}
+
+		if strings.Contains(fields, "viewedByMeTime") {
+			file.ViewedByMe = true
+			file.ViewedByMeTime = atime.Format(time.RFC3339)
+		}
+
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "viewedByMeTime >"):
+			require.Equal(t, "viewedByMeTime desc", r.URL.Query().Get("orderBy"))
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{file}})
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{file}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	// Not fetched by default
+	fi, err := driver.Stat("File1")
+	require.NoError(t, err)
+	require.False(t, fi.(*FileInfo).ViewedByMe())
+	require.True(t, fi.(*FileInfo).ViewedByMeTime().IsZero())
+
+	require.NoError(t, WithViewedByMe()(driver))
+
+	fi, err = driver.Stat("File1")
+	require.NoError(t, err)
+	require.True(t, fi.(*FileInfo).ViewedByMe())
+	require.True(t, atime.Equal(fi.(*FileInfo).ViewedByMeTime()))
+
+	driver.FetchViewedByMe = false
+
+	recent, err := driver.ListRecentlyViewed(10)
+	require.NoError(t, err)
+	require.Len(t, recent, 1)
+	require.True(t, atime.Equal(recent[0].ViewedByMeTime()))
+}
+
+func TestChtimes(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		file    = &drive.File{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Parents: []string{fakeRootID}}
+		patches []map[string]interface{}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{file}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/file1":
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			var raw map[string]interface{}
+			require.NoError(t, json.Unmarshal(body, &raw))
+			patches = append(patches, raw)
+
+			var patch drive.File
+			require.NoError(t, json.Unmarshal(body, &patch))
+
+			updated := *file
+			if patch.ModifiedTime != "" {
+				updated.ModifiedTime = patch.ModifiedTime
+			}
+
+			updated.Properties = map[string]string{}
+			for k, v := range file.Properties {
+				updated.Properties[k] = v
+			}
+
+			for k, v := range patch.Properties {
+				updated.Properties[k] = v
+			}
+
+			file = &updated
+
+			writeJSON(w, http.StatusOK, file)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	atime := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	mTime := time.Date(2023, 1, 2, 3, 4, 5, 600, time.UTC)
+
+	require.NoError(t, driver.Chtimes("File1", atime, mTime))
+	require.NotContains(t, patches[0], "viewedByMeTime")
+
+	stat, err := driver.Stat("File1")
+	require.NoError(t, err)
+	require.WithinDuration(t, mTime, stat.ModTime(), time.Second)
+	require.True(t, atime.Equal(stat.(*FileInfo).AccessTime()))
+
+	// A zero access time keeps the stored one
+	mTime = mTime.Add(time.Hour)
+	require.NoError(t, driver.Chtimes("File1", time.Time{}, mTime))
+	require.NotContains(t, patches[1], "properties")
+
+	stat, err = driver.Stat("File1")
+	require.NoError(t, err)
+	require.WithinDuration(t, mTime, stat.ModTime(), time.Second)
+	require.True(t, atime.Equal(stat.(*FileInfo).AccessTime()))
+}
+
+func TestRenameInvalidatesCache(t *testing.T) {
+	var mu sync.Mutex
+
+	file := &drive.File{Id: "src", Name: "Src", MimeType: mimeTypeFile, Parents: []string{fakeRootID}}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+			if strings.Contains(r.URL.Query().Get("q"), fmt.Sprintf("name='%s'", file.Name)) {
+				list.Files = []*drive.File{file}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/src":
+			var patch drive.File
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+
+			file.Name = patch.Name
+			writeJSON(w, http.StatusOK, file)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	_, err := driver.Stat("Src")
+	require.NoError(t, err)
+
+	_, err = driver.Stat("Dst")
+	require.True(t, IsNotExist(err))
+
+	require.NoError(t, driver.Rename("Src", "Dst"))
+
+	fi, err := driver.Stat("Dst")
+	require.NoError(t, err)
+	require.Equal(t, "src", fi.(*FileInfo).ID())
+
+	_, err = driver.Stat("Src")
+	require.True(t, IsNotExist(err))
+}
+
+func TestNotFoundCache(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		created *drive.File
+		lookups = map[string]int{}
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			var parent, name string
+			_, err := fmt.Sscanf(r.URL.Query().Get("q"), "'%s in parents and name=%s and trashed = false", &parent, &name)
+			require.NoError(t, err)
+
+			name = strings.Trim(name, "'")
+			lookups[name]++
+
+			list := &drive.FileList{}
+			if name == "Later" && created != nil {
+				list.Files = []*drive.File{created}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files":
+			created = &drive.File{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(created))
+			created.Id = "later"
+			writeJSON(w, http.StatusOK, created)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	countLookups := func(name string) int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return lookups[name]
+	}
+
+	_, err := driver.Stat("Later")
+	require.True(t, IsNotExist(err))
+
+	_, err = driver.Stat("Later")
+	require.True(t, IsNotExist(err))
+	require.Equal(t, 1, countLookups("Later"))
+
+	// Creating the file in the directory drops the cached lookup
+	require.NoError(t, driver.Mkdir("Later", 0))
+
+	stat, err := driver.Stat("Later")
+	require.NoError(t, err)
+	require.True(t, stat.IsDir())
+
+	t.Run("expiration", func(t *testing.T) {
+		require.NoError(t, NotFoundCache(10*time.Millisecond)(driver))
+
+		_, err := driver.Stat("Expiring")
+		require.True(t, IsNotExist(err))
+
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = driver.Stat("Expiring")
+		require.True(t, IsNotExist(err))
+		require.Equal(t, 2, countLookups("Expiring"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		require.NoError(t, NotFoundCache(0)(driver))
+
+		for i := 0; i < 3; i++ {
+			_, err := driver.Stat("Uncached")
+			require.True(t, IsNotExist(err))
+		}
+
+		require.Equal(t, 3, countLookups("Uncached"))
+	})
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	var lookups int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+			{Id: "file", Name: "File", MimeType: mimeTypeFile},
+		}})
+	}, CacheMaxEntries(2))
+
+	for _, name := range []string{"A", "B", "A", "C", "A"} {
+		_, err := driver.Stat(name)
+		require.NoError(t, err)
+	}
+
+	// A stays cached as it is used, B is evicted by C
+	require.EqualValues(t, 3, atomic.LoadInt32(&lookups))
+
+	_, err := driver.Stat("B")
+	require.NoError(t, err)
+	require.EqualValues(t, 4, atomic.LoadInt32(&lookups))
+}
+
+func TestCacheStats(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+			{Id: "file", Name: "File", MimeType: mimeTypeFile},
+		}})
+	}, CacheMaxEntries(2))
+
+	for _, name := range []string{"A", "A", "B", "C", "A"} {
+		_, err := driver.Stat(name)
+		require.NoError(t, err)
+	}
+
+	// Only the second lookup of A is found in the cache. The third one is performed again as A was evicted by
+	// C, and each lookup performed also misses the cached children of the root directory.
+	require.Equal(t, cache.Stats{Hits: 1, Misses: 8, Evictions: 2}, driver.CacheStats())
+}
+
+func TestAPICallCounts(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "created", Name: "Created", MimeType: mimeTypeFile})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	before := driver.APICallCounts()
+
+	for i := 0; i < 3; i++ {
+		_, err := driver.CreateWith(fmt.Sprintf("File%d", i), &drive.File{}, nil)
+		require.NoError(t, err)
+	}
+
+	after := driver.APICallCounts()
+	require.Equal(t, before["Files.Create"]+3, after["Files.Create"])
+	require.Equal(t, before["Files.Delete"], after["Files.Delete"])
+	require.Greater(t, after["Files.List"], before["Files.List"])
+
+	// The counts are a snapshot
+	after["Files.Create"] = 0
+	require.Equal(t, before["Files.Create"]+3, driver.APICallCounts()["Files.Create"])
+}
+
+// fakeObserver records the calls reported to a MetricsObserver
+type fakeObserver struct {
+	mu    sync.Mutex
+	calls []string
+	errs  []error
+}
+
+func (o *fakeObserver) ObserveCall(api string, duration time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.calls = append(o.calls, api)
+	o.errs = append(o.errs, err)
+}
+
+func (o *fakeObserver) reset() ([]string, []error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	calls, errs := o.calls, o.errs
+	o.calls, o.errs = nil, nil
+
+	return calls, errs
+}
+
+func TestMetricsObserver(t *testing.T) {
+	var failures int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			// The first attempt fails, it is retried
+			if atomic.AddInt32(&failures, 1) == 1 {
+				writeAPIError(w, http.StatusServiceUnavailable, "backendError")
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, &drive.FileList{})
+		case r.Method == http.MethodPost && r.URL.Path == "/drive/v3/files":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "dir", Name: "Dir", MimeType: mimeTypeFolder})
+		case r.Method == http.MethodDelete && r.URL.Path == "/drive/v3/files/file1":
+			writeAPIError(w, http.StatusForbidden, "insufficientFilePermissions")
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}, Retry(1, time.Millisecond))
+
+	observer := &fakeObserver{}
+	require.NoError(t, WithMetricsObserver(observer)(driver))
+
+	// The retry of the lookup isn't reported as a call of its own
+	require.NoError(t, driver.Mkdir("Dir", 0))
+	require.EqualValues(t, 2, atomic.LoadInt32(&failures))
+
+	calls, errs := observer.reset()
+	require.Equal(t, []string{"Files.List", "Files.Create"}, calls)
+	require.Equal(t, []error{nil, nil}, errs)
+
+	require.Error(t, driver.Remove("File1"))
+
+	calls, errs = observer.reset()
+	require.Equal(t, []string{"Files.List", "Files.Delete"}, calls)
+	require.NoError(t, errs[0])
+
+	var apiErr *googleapi.Error
+	require.ErrorAs(t, errs[1], &apiErr)
+	require.Equal(t, http.StatusForbidden, apiErr.Code)
+}
+
+func TestMetricsObserverResumableUpload(t *testing.T) {
+	var chunks int32
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("uploadType") == "resumable" && r.URL.Query().Get("upload_id") == "":
+			w.Header().Set("Location", "https://www.googleapis.com"+r.URL.Path+"?uploadType=resumable&upload_id="+r.Method)
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Query().Get("upload_id") != "":
+			atomic.AddInt32(&chunks, 1)
+			_, _ = io.Copy(io.Discard, r.Body)
+
+			// The total size is only given with the last chunk
+			var from, to int
+			if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/*", &from, &to); err == nil {
+				w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", to))
+				w.Header().Set("X-Http-Status-Code-Override", "308")
+				w.WriteHeader(http.StatusOK)
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1"})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	observer := &fakeObserver{}
+	require.NoError(t, WithMetricsObserver(observer)(driver))
+
+	content := make([]byte, 2*googleapi.MinUploadChunkSize+1)
+
+	_, err := driver.srv.Files.Create(&drive.File{Name: "File1"}).
+		Media(bytes.NewReader(content), googleapi.ChunkSize(googleapi.MinUploadChunkSize)).
+		Do()
+	require.NoError(t, err)
+
+	_, err = driver.srv.Files.Update("file1", nil).
+		Media(bytes.NewReader(content), googleapi.ChunkSize(googleapi.MinUploadChunkSize)).
+		Do()
+	require.NoError(t, err)
+
+	// Each upload is reported once, whatever its number of chunks
+	require.EqualValues(t, 6, atomic.LoadInt32(&chunks))
+
+	calls, errs := observer.reset()
+	require.Equal(t, []string{"Files.Create", "Files.Update"}, calls)
+	require.Equal(t, []error{nil, nil}, errs)
+}
+
+func TestAPIMethod(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		url    string
+		api    string
+	}{
+		{http.MethodGet, "/drive/v3/files?q=x", "Files.List"},
+		{http.MethodGet, "/drive/v3/files/id", "Files.Get"},
+		{http.MethodGet, "/drive/v3/files/id?alt=media", "Files.Download"},
+		{http.MethodPost, "/upload/drive/v3/files?uploadType=multipart", "Files.Create"},
+		{http.MethodPatch, "/upload/drive/v3/files/id", "Files.Update"},
+		{http.MethodDelete, "/drive/v3/files/id", "Files.Delete"},
+		{http.MethodDelete, "/drive/v3/files/trash", "Files.EmptyTrash"},
+		{http.MethodPost, "/drive/v3/files/id/copy", "Files.Copy"},
+		{http.MethodGet, "/drive/v3/files/id/export", "Files.Export"},
+		{http.MethodPost, "/drive/v3/files/id/permissions", "Permissions.Create"},
+		{http.MethodDelete, "/drive/v3/files/id/permissions/perm", "Permissions.Delete"},
+		{http.MethodGet, "/drive/v3/files/id/revisions", "Revisions.List"},
+		{http.MethodGet, "/drive/v3/about", "About.Get"},
+		{http.MethodGet, "/drive/v3/drives", "Drives.List"},
+		{http.MethodPost, "/batch/drive/v3", "Batch"},
+	} {
+		req, err := http.NewRequest(tc.method, "https://www.googleapis.com"+tc.url, nil)
+		require.NoError(t, err)
+		require.Equal(t, tc.api, apiMethod(req), tc.url)
+	}
+}
+
+func TestLookupCoalescing(t *testing.T) {
+	children := []*drive.File{
+		{Id: "a", Name: "A", MimeType: mimeTypeFile},
+		{Id: "b", Name: "B", MimeType: mimeTypeFile},
+		{Id: "c", Name: "C", MimeType: mimeTypeFile},
+		{Id: "d", Name: "D", MimeType: mimeTypeFile},
+		{Id: "e", Name: "E", MimeType: mimeTypeFile},
+	}
+
+	// external is set once a file was created in the folder by someone else
+	external := &drive.File{Id: "f", Name: "F", MimeType: mimeTypeFile}
+
+	var lookups, listings, externalCreated int32
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		children := children
+		if atomic.LoadInt32(&externalCreated) != 0 {
+			children = append(children[:len(children):len(children)], external)
+		}
+
+		switch {
+		case strings.Contains(q, "name='Folder1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "folder1", Name: "Folder1", MimeType: mimeTypeFolder},
+			}})
+		case strings.HasPrefix(q, "'folder1' in parents and name="):
+			atomic.AddInt32(&lookups, 1)
+
+			list := &drive.FileList{}
+			for _, child := range children {
+				if strings.Contains(q, "name='"+child.Name+"'") {
+					list.Files = append(list.Files, child)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case q == "'folder1' in parents and trashed = false":
+			atomic.AddInt32(&listings, 1)
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: children})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}
+
+	reset := func() {
+		atomic.StoreInt32(&lookups, 0)
+		atomic.StoreInt32(&listings, 0)
+	}
+
+	t.Run("after readdir", func(t *testing.T) {
+		driver := newFakeDriver(t, handler)
+		reset()
+
+		dir, err := driver.Open("Folder1")
+		require.NoError(t, err)
+
+		names, err := dir.Readdirnames(-1)
+		require.NoError(t, err)
+		require.Len(t, names, len(children))
+
+		for _, name := range names {
+			_, err := driver.Stat("Folder1/" + name)
+			require.NoError(t, err)
+		}
+
+		_, err = driver.Stat("Folder1/Missing")
+		require.True(t, IsNotExist(err))
+
+		require.Zero(t, atomic.LoadInt32(&lookups))
+		require.EqualValues(t, 1, atomic.LoadInt32(&listings))
+
+		// Modifying the folder drops the listing
+		driver.srvWrapper.invalidateFile(&drive.File{Id: "a", Parents: []string{"folder1"}})
+
+		_, err = driver.Stat("Folder1/A")
+		require.NoError(t, err)
+		require.EqualValues(t, 1, atomic.LoadInt32(&lookups))
+	})
+
+	t.Run("many lookups", func(t *testing.T) {
+		driver := newFakeDriver(t, handler, CoalesceLookups())
+		reset()
+
+		for _, child := range children {
+			fi, err := driver.Stat("Folder1/" + child.Name)
+			require.NoError(t, err)
+			require.Equal(t, child.Id, fi.(*FileInfo).ID())
+		}
+
+		_, err := driver.Stat("Folder1/Missing")
+		require.True(t, IsNotExist(err))
+
+		require.EqualValues(t, lookupCoalesceThreshold, atomic.LoadInt32(&lookups))
+		require.EqualValues(t, 1, atomic.LoadInt32(&listings))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		driver := newFakeDriver(t, handler)
+		reset()
+
+		for _, child := range children {
+			_, err := driver.Stat("Folder1/" + child.Name)
+			require.NoError(t, err)
+		}
+
+		require.EqualValues(t, len(children), atomic.LoadInt32(&lookups))
+		require.Zero(t, atomic.LoadInt32(&listings))
+	})
+
+	t.Run("missing names expire", func(t *testing.T) {
+		driver := newFakeDriver(t, handler, NotFoundCache(10*time.Millisecond))
+		reset()
+
+		dir, err := driver.Open("Folder1")
+		require.NoError(t, err)
+
+		_, err = dir.Readdirnames(-1)
+		require.NoError(t, err)
+
+		atomic.StoreInt32(&externalCreated, 1)
+		defer atomic.StoreInt32(&externalCreated, 0)
+
+		_, err = driver.Stat("Folder1/F")
+		require.True(t, IsNotExist(err))
+		require.Zero(t, atomic.LoadInt32(&lookups))
+
+		time.Sleep(20 * time.Millisecond)
+
+		fi, err := driver.Stat("Folder1/F")
+		require.NoError(t, err)
+		require.Equal(t, "f", fi.(*FileInfo).ID())
+		require.EqualValues(t, 1, atomic.LoadInt32(&lookups))
+
+		// The names present in the listing are still served from it
+		_, err = driver.Stat("Folder1/A")
+		require.NoError(t, err)
+		require.EqualValues(t, 1, atomic.LoadInt32(&lookups))
+	})
+}
+
+func TestEmptyTrash(t *testing.T) {
+	newTrashDriver := func(t *testing.T, opts ...Option) (*GDriver, map[string]*drive.File) {
+		var mu sync.Mutex
+
+		files := map[string]*drive.File{
+			fakeRootID: {Id: fakeRootID, Name: "My Drive", MimeType: mimeTypeFolder},
+			"sub":      {Id: "sub", Name: "Sub", MimeType: mimeTypeFolder, Parents: []string{fakeRootID}},
+			"inner":    {Id: "inner", Name: "Inner", MimeType: mimeTypeFile, Parents: []string{"sub"}},
+			"outer":    {Id: "outer", Name: "Outer", MimeType: mimeTypeFile, Parents: []string{fakeRootID}, Trashed: true},
+		}
+
+		driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			q := r.URL.Query().Get("q")
+			id := strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")
+
+			switch {
+			case r.Method == http.MethodGet && q == "trashed = true":
+				list := &drive.FileList{}
+
+				for _, f := range files {
+					if f.Trashed {
+						list.Files = append(list.Files, f)
+					}
+				}
+
+				writeJSON(w, http.StatusOK, list)
+			case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+				list := &drive.FileList{}
+
+				for _, f := range files {
+					if !f.Trashed && strings.Contains(q, fmt.Sprintf("name='%s'", f.Name)) {
+						list.Files = append(list.Files, f)
+					}
+				}
+
+				writeJSON(w, http.StatusOK, list)
+			case r.Method == http.MethodDelete && id == "trash":
+				for fileID, f := range files {
+					if f.Trashed {
+						delete(files, fileID)
+					}
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+			case files[id] == nil:
+				writeAPIError(w, http.StatusNotFound, "notFound")
+			case r.Method == http.MethodGet:
+				writeJSON(w, http.StatusOK, files[id])
+			case r.Method == http.MethodPatch:
+				var patch drive.File
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+
+				files[id].Trashed = patch.Trashed
+				writeJSON(w, http.StatusOK, files[id])
+			case r.Method == http.MethodDelete:
+				delete(files, id)
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				writeAPIError(w, http.StatusNotFound, "notFound")
+			}
+		}, opts...)
+
+		return driver, files
+	}
+
+	t.Run("drive root", func(t *testing.T) {
+		driver, files := newTrashDriver(t)
+
+		require.NoError(t, driver.trashPath("Sub/Inner"))
+
+		trashed, err := driver.ListTrash("", 0)
+		require.NoError(t, err)
+		require.Len(t, trashed, 2)
+
+		require.NoError(t, driver.EmptyTrash())
+		require.Equal(t, 1, int(*driver.srvWrapper.calls["Files.EmptyTrash"]))
+
+		trashed, err = driver.ListTrash("", 0)
+		require.NoError(t, err)
+		require.Empty(t, trashed)
+		require.NotContains(t, files, "outer")
+	})
+
+	t.Run("sub directory", func(t *testing.T) {
+		driver, files := newTrashDriver(t, RootDirectory("Sub"))
+
+		require.NoError(t, driver.trashPath("Inner"))
+
+		trashed, err := driver.ListTrash("", 0)
+		require.NoError(t, err)
+		require.Len(t, trashed, 1)
+
+		require.NoError(t, driver.EmptyTrash())
+		require.Zero(t, int(*driver.srvWrapper.calls["Files.EmptyTrash"]))
+
+		trashed, err = driver.ListTrash("", 0)
+		require.NoError(t, err)
+		require.Empty(t, trashed)
+
+		// The trashed files outside the root directory are kept
+		require.NotContains(t, files, "inner")
+		require.Contains(t, files, "outer")
+	})
+}
+
+func TestOrphans(t *testing.T) {
+	var addedParent string
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		switch {
+		case r.Method == http.MethodGet && q == orphansQuery:
+			require.Contains(t, r.URL.Query().Get("fields"), "parents")
+
+			if r.URL.Query().Get("pageToken") == "" {
+				writeJSON(w, http.StatusOK, &drive.FileList{NextPageToken: "next", Files: []*drive.File{
+					{Id: fakeRootID, Name: "My Drive", MimeType: mimeTypeFolder},
+					{Id: "orphan1", Name: "Orphan1", MimeType: mimeTypeFile},
+					{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Parents: []string{fakeRootID}},
+				}})
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "orphan2", Name: "Orphan2", MimeType: mimeTypeFile},
+				{Id: "orphan3", Name: "Orphan3", MimeType: mimeTypeFile},
+			}})
+		case strings.Contains(q, "name='Recovered'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "recovered", Name: "Recovered", MimeType: mimeTypeFolder},
+			}})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files/orphan1":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "orphan1"})
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files/file1":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Parents: []string{fakeRootID}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/drive/v3/files/orphan1":
+			addedParent = r.URL.Query().Get("addParents")
+			writeJSON(w, http.StatusOK, &drive.File{Id: "orphan1", Name: "Orphan1", Parents: []string{addedParent}})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	orphans, err := driver.ListOrphans(10)
+	require.NoError(t, err)
+	require.Len(t, orphans, 3)
+	require.Equal(t, "Orphan1", orphans[0].Path())
+	require.Equal(t, "Orphan3", orphans[2].Path())
+
+	orphans, err = driver.ListOrphans(1)
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+
+	fi, err := driver.ReparentOrphan("orphan1", "Recovered")
+	require.NoError(t, err)
+	require.Equal(t, "recovered", addedParent)
+	require.Equal(t, "Recovered/Orphan1", fi.Path())
+
+	_, err = driver.ReparentOrphan("file1", "Recovered")
+	require.ErrorIs(t, err, ErrNotOrphan)
+}
+
+func TestListTrashCount(t *testing.T) {
+	trashed := []*drive.File{
+		{Id: "file1", Name: "File1", Parents: []string{fakeRootID}, Trashed: true},
+		{Id: "file2", Name: "File2", Parents: []string{fakeRootID}, Trashed: true},
+		{Id: "file3", Name: "File3", Parents: []string{fakeRootID}, Trashed: true},
+	}
+
+	var pageSizes []string
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "trashed = true", r.URL.Query().Get("q"))
+
+		pageSizes = append(pageSizes, r.URL.Query().Get("pageSize"))
+
+		// The fake serves two files per page, the page token being the offset of the page
+		offset, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		end := offset + 2
+
+		list := &drive.FileList{}
+
+		if end < len(trashed) {
+			list.NextPageToken = strconv.Itoa(end)
+		} else {
+			end = len(trashed)
+		}
+
+		list.Files = trashed[offset:end]
+
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	files, err := driver.ListTrash("", 1)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, []string{"1"}, pageSizes)
+
+	pageSizes = nil
+
+	files, err = driver.ListTrash("", -1)
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	require.Len(t, pageSizes, 2)
+}
+
+func TestRestore(t *testing.T) {
+	var mu sync.Mutex
+
+	files := map[string]*drive.File{
+		fakeRootID:  {Id: fakeRootID, Name: "My Drive", MimeType: mimeTypeFolder},
+		"dir1":      {Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder, Parents: []string{fakeRootID}},
+		"file1":     {Id: "file1", Name: "File1", MimeType: mimeTypeFile, Parents: []string{"dir1"}},
+		"other":     {Id: "other", Name: "File1", MimeType: mimeTypeFile, Parents: []string{fakeRootID}, Trashed: true},
+		"elsewhere": {Id: "elsewhere", Name: "Elsewhere", MimeType: mimeTypeFolder},
+		"decoy": {
+			Id: "decoy", Name: "File1", MimeType: mimeTypeFile, Parents: []string{"elsewhere"}, Trashed: true,
+		},
+	}
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		q := r.URL.Query().Get("q")
+		id := strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+			trashed := strings.HasPrefix(q, "trashed = true")
+
+			for _, f := range files {
+				if f.Trashed != trashed {
+					continue
+				}
+
+				if !trashed && (!strings.Contains(q, fmt.Sprintf("name='%s'", f.Name)) ||
+					!strings.Contains(q, fmt.Sprintf("'%s' in parents", f.Parents[0]))) {
+					continue
+				}
+
+				if strings.Contains(q, "name = ") && !strings.Contains(q, fmt.Sprintf("name = '%s'", f.Name)) {
+					continue
+				}
+
+				list.Files = append(list.Files, f)
+			}
+
+			// The trashed files are served one per page, to check that all the pages are read
+			if trashed {
+				sort.Slice(list.Files, func(i, j int) bool { return list.Files[i].Id < list.Files[j].Id })
+
+				page, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+				if page < len(list.Files)-1 {
+					list.NextPageToken = strconv.Itoa(page + 1)
+				}
+
+				list.Files = list.Files[page:min(page+1, len(list.Files))]
+			}
+
+			writeJSON(w, http.StatusOK, list)
+		case files[id] == nil:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		case r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, files[id])
+		case r.Method == http.MethodPatch:
+			var patch map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+			require.Contains(t, patch, "trashed")
+
+			files[id].Trashed = patch["trashed"].(bool)
+			writeJSON(w, http.StatusOK, files[id])
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	driver.TrashForDelete = true
+
+	require.NoError(t, driver.Remove("Dir1/File1"))
+	require.True(t, IsNotExist(getError(driver.Stat("Dir1/File1"))))
+
+	trashed, err := driver.ListTrash("Dir1", 0)
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	require.Equal(t, "file1", trashed[0].ID())
+
+	require.NoError(t, driver.Restore("Dir1/File1"))
+
+	fi, err := driver.Stat("Dir1/File1")
+	require.NoError(t, err)
+	require.Equal(t, "file1", fi.(*FileInfo).ID())
+
+	require.True(t, IsExist(driver.Restore("Dir1/File1")))
+	require.True(t, IsNotExist(driver.Restore("Dir1/Missing")))
+
+	require.NoError(t, driver.RestoreByID("other"))
+
+	_, err = driver.Stat("File1")
+	require.NoError(t, err)
+
+	// The files outside of the root directory can't be restored
+	require.True(t, IsNotExist(driver.RestoreByID("decoy")))
+	require.True(t, files["decoy"].Trashed)
+}
+
+func TestUploadSpill(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		uploaded []byte
+		failure  = http.StatusServiceUnavailable
+	)
+
+	spillFs := afero.NewMemMapFs()
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/upload/drive/v3/files/file1":
+			attempts++
+
+			// The upload is aborted once the spill is exceeded
+			if r.Context().Err() != nil {
+				return
+			}
+
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			require.NoError(t, err)
+
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			_, err = mr.NextPart() // metadata
+			require.NoError(t, err)
+			media, err := mr.NextPart()
+			require.NoError(t, err)
+			content, err := io.ReadAll(media)
+			require.NoError(t, err)
+
+			// The first attempt fails after the content was sent
+			if attempts == 1 {
+				writeAPIError(w, failure, "rateLimitExceeded")
+
+				return
+			}
+
+			uploaded = content
+			writeJSON(w, http.StatusOK, &drive.File{Id: "file1", Name: "File1", Size: int64(len(content))})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	}, UploadSpill(spillFs, 1024))
+
+	t.Run("retried", func(t *testing.T) {
+		f, err := driver.OpenFile("File1", os.O_WRONLY|os.O_TRUNC, 0)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("Hello World"))
 		require.NoError(t, err)
-		received, err := ioutil.ReadAll(r)
+		require.NoError(t, f.Close())
+
+		require.Equal(t, 2, attempts)
+		require.Equal(t, "Hello World", string(uploaded))
+
+		// The temporary file is removed
+		spilled, err := afero.ReadDir(spillFs, os.TempDir())
 		require.NoError(t, err)
-		require.Equal(t, "Hello World", string(received))
+		require.Empty(t, spilled)
 	})
 
-	t.Run("in non existing folder", func(t *testing.T) {
-		driver := setup(t).AsAfero()
-
-		// create File
-		mustWriteFileContent(t, driver, "Folder1/File1", "Hello World")
+	t.Run("retried after a forbidden rate-limit", func(t *testing.T) {
+		attempts = 0
+		failure = http.StatusForbidden
 
-		// Folder created?
-		require.NoError(t, getError(driver.Stat("Folder1")))
+		defer func() { failure = http.StatusServiceUnavailable }()
 
-		// File created?
-		fi, err := driver.Stat("Folder1/File1")
+		f, err := driver.OpenFile("File1", os.O_WRONLY|os.O_TRUNC, 0)
 		require.NoError(t, err)
-		require.Equal(t, "File1", fi.Name())
 
-		// Compare File contents
-		r, err := driver.Open("Folder1/File1")
-		require.NoError(t, err)
-		received, err := ioutil.ReadAll(r)
+		_, err = f.Write([]byte("Hello Drive"))
 		require.NoError(t, err)
-		require.Equal(t, "Hello World", string(received))
+		require.NoError(t, f.Close())
+
+		require.Equal(t, 2, attempts)
+		require.Equal(t, "Hello Drive", string(uploaded))
 	})
 
-	t.Run("as descendant of File", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+	t.Run("too large", func(t *testing.T) {
+		attempts = 0
 
-		// create File
-		require.NoError(t, writeFile(driver, "Folder1/File1", bytes.NewBufferString("Hello World")))
+		f, err := driver.OpenFile("File1", os.O_WRONLY|os.O_TRUNC, 0)
+		require.NoError(t, err)
 
-		err := writeFile(driver, "Folder1/File1/File2", bytes.NewBufferString("Hello World"))
-		require.EqualError(t, err, "couldn't open file: file Folder1/File1 is not a directory")
+		_, _ = f.Write(make([]byte, 2048))
+		require.ErrorIs(t, f.Close(), ErrUploadSpillExceeded)
 	})
 
-	t.Run("empty target", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+	t.Run("replay", func(t *testing.T) {
+		// The first attempt fails before the spill is exceeded, the second one reads the rest of the content
+		var replayed []byte
 
-		// create File
-		require.EqualError(
-			t,
-			writeFile(driver, "", bytes.NewBufferString("Hello World")),
-			"couldn't open file: path cannot be empty",
-		)
-	})
+		upload := func(_ context.Context, media io.Reader) (*drive.File, error) {
+			if replayed == nil {
+				replayed = []byte{}
 
-	t.Run("overwrite File", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+				_, err := io.ReadFull(media, make([]byte, 10))
+				require.NoError(t, err)
 
-		// create File
-		mustWriteFileContent(t, driver, "File1", "Hello World")
+				return nil, &googleapi.Error{Code: http.StatusServiceUnavailable}
+			}
 
-		// File created?
-		fi1, err := driver.Stat("File1")
-		require.NoError(t, err)
-		require.Equal(t, "File1", fi1.Name())
+			var err error
+			if replayed, err = io.ReadAll(media); err != nil {
+				return nil, err
+			}
 
-		// Compare File contents
-		r, err := driver.Open("File1")
-		require.NoError(t, err)
-		defer func() { require.NoError(t, r.Close()) }()
-		received, err := ioutil.ReadAll(r)
-		require.NoError(t, err)
-		require.Equal(t, "Hello World", string(received))
+			return &drive.File{Id: "file1"}, nil
+		}
 
-		// create File
-		mustWriteFileContent(t, driver, "File1", "Hello Universe")
+		for _, size := range []int{512, 2048} {
+			replayed = nil
+			content := bytes.Repeat([]byte("a"), size)
 
-		// File created?
-		fi2, err := driver.Stat("File1")
-		require.NoError(t, err)
-		require.Equal(t, "File1", fi2.Name())
+			spill, err := driver.newUploadSpill()
+			require.NoError(t, err)
 
-		// Compare File contents
-		r, err = driver.Open("File1")
-		defer func() { require.NoError(t, r.Close()) }()
-		require.NoError(t, err)
-		received, err = ioutil.ReadAll(r)
-		require.NoError(t, err)
-		require.Equal(t, "Hello Universe", string(received))
+			_, err = driver.runUpload(context.Background(), upload, bytes.NewReader(content), spill)
+
+			if size > 1024 {
+				require.ErrorIs(t, err, ErrUploadSpillExceeded)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, content, replayed)
+			}
+		}
 	})
 }
 
-func TestGetFile(t *testing.T) {
-	driver := setup(t).AsAfero()
-
-	mustWriteFile(t, driver, "Folder1/File1")
+func TestAbout(t *testing.T) {
+	var limit int64 = 15 << 30
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/drive/v3/about":
+			require.Equal(t, "user,storageQuota", r.URL.Query().Get("fields"))
+
+			writeJSON(w, http.StatusOK, &drive.About{
+				User: &drive.User{EmailAddress: "user@example.com"},
+				StorageQuota: &drive.AboutStorageQuota{
+					Limit:             limit,
+					Usage:             3 << 30,
+					UsageInDrive:      2 << 30,
+					UsageInDriveTrash: 1 << 30,
+				},
+			})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
 
-	// Compare File content
-	fi, err := driver.Open("Folder1/File1")
-	require.NoError(t, err)
-	received, err := ioutil.ReadAll(fi)
+	quota, err := driver.About()
 	require.NoError(t, err)
-	require.Equal(t, "Hello World", string(received))
-	require.Equal(t, "File1", fi.Name())
+	require.GreaterOrEqual(t, quota.Usage, int64(0))
+	require.EqualValues(t, 15<<30, quota.Limit)
+	require.EqualValues(t, 3<<30, quota.Usage)
+	require.EqualValues(t, 2<<30, quota.UsageInDrive)
+	require.EqualValues(t, 1<<30, quota.UsageInDriveTrash)
+	require.Equal(t, "user@example.com", quota.User.EmailAddress)
 
-	// Get File contents of an Folder
-	file, err := driver.Open("Folder1")
-	require.NoError(t, err)
-	fileInfo, err := file.Stat()
-	require.NoError(t, err)
-	require.True(t, fileInfo.IsDir())
+	limit = 0
+
+	_, err = driver.About()
+
+	var unlimited *UnlimitedQuotaError
+	require.ErrorAs(t, err, &unlimited)
+	require.EqualValues(t, 3<<30, unlimited.Quota.Usage)
 }
 
-func TestDelete(t *testing.T) {
-	t.Run("delete file", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+func TestOpenByID(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='Dir1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder},
+			}})
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: 5},
+			}})
+		case r.URL.Path == "/drive/v3/files/file1" && r.URL.Query().Get("alt") == "media":
+			_, _ = w.Write([]byte("Hello"))
+		case r.URL.Path == "/drive/v3/files/file1":
+			require.Contains(t, r.URL.Query().Get("fields"), "parents")
+			writeJSON(w, http.StatusOK, &drive.File{
+				Id: "file1", Name: "File1", MimeType: mimeTypeFile, Size: 5, Parents: []string{"dir1"},
+			})
+		case r.URL.Path == "/drive/v3/files/dir1":
+			writeJSON(w, http.StatusOK, &drive.File{
+				Id: "dir1", Name: "Dir1", MimeType: mimeTypeFolder, Parents: []string{fakeRootID},
+			})
+		case r.URL.Path == "/drive/v3/files/trashed":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "trashed", Name: "Trashed", Parents: []string{"dir1"}, Trashed: true})
+		case r.URL.Path == "/drive/v3/files/outside":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "outside", Name: "Outside", Parents: []string{"elsewhere"}})
+		case r.URL.Path == "/drive/v3/files/elsewhere":
+			writeJSON(w, http.StatusOK, &drive.File{Id: "elsewhere", Name: "Elsewhere"})
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
 
-		mustWriteFile(t, driver, "File1")
+	stat, err := driver.Stat("Dir1/File1")
+	require.NoError(t, err)
 
-		// delete File
-		require.NoError(t, driver.Remove("File1"))
+	id := stat.(*FileInfo).DriveFile().Id
 
-		// File1 deleted?
-		require.EqualError(t, getError(driver.Stat("File1")), "`File1' does not exist")
-	})
+	fi, err := driver.StatByID(id)
+	require.NoError(t, err)
+	require.Equal(t, "Dir1/File1", fi.Path())
+	require.EqualValues(t, 5, fi.Size())
 
-	t.Run("delete directory", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+	f, err := driver.OpenByID(id)
+	require.NoError(t, err)
 
-		mustCreateDir(t, driver, "Folder1")
+	content, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "Hello", string(content))
+	require.NoError(t, f.Close())
 
-		// delete folder
-		require.NoError(t, driver.Remove("Folder1"))
+	dir, err := driver.OpenByID("dir1")
+	require.NoError(t, err)
+	require.Equal(t, "Dir1", dir.Name())
 
-		// Folder1 deleted?
-		require.EqualError(t, getError(driver.Stat("Folder1")), "`Folder1' does not exist")
-	})
+	_, err = driver.StatByID("missing")
+	require.True(t, IsNotExist(err))
+
+	_, err = driver.StatByID("trashed")
+	require.True(t, IsTrashed(err))
+
+	var outside *PathOutsideRootError
+	_, err = driver.StatByID("outside")
+	require.ErrorAs(t, err, &outside)
 }
 
-func TestDeleteDirectory(t *testing.T) {
-	t.Run("delete directory", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+func TestRevisions(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		file      *drive.File
+		revisions []*drive.Revision
+		contents  = map[string][]byte{}
+	)
 
-		mustCreateDir(t, driver, "Folder1")
+	readMedia := func(r *http.Request) []byte {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
 
-		// delete folder
-		require.NoError(t, driver.Remove("Folder1"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		_, err = mr.NextPart()
+		require.NoError(t, err)
+		media, err := mr.NextPart()
+		require.NoError(t, err)
+		content, err := io.ReadAll(media)
+		require.NoError(t, err)
 
-		// Folder1 deleted?
-		require.EqualError(t, getError(driver.Stat("Folder1")), "`Folder1' does not exist")
-	})
-}
+		return content
+	}
 
-func TestListDirectory(t *testing.T) {
-	t.Run("standard", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+	addRevision := func(content []byte) {
+		sum := md5.Sum(content) // nolint: gosec
+		id := fmt.Sprintf("rev%d", len(revisions)+1)
+		revisions = append(revisions, &drive.Revision{
+			Id:           id,
+			ModifiedTime: time.Date(2024, 1, len(revisions)+1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			Size:         int64(len(content)),
+			Md5Checksum:  hex.EncodeToString(sum[:]),
+		})
+		contents[id] = content
+		file.Size = int64(len(content))
+	}
 
-		mustWriteFile(t, driver, "Folder1/File1")
-		mustWriteFile(t, driver, "Folder1/File2")
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
 
-		t.Run("listing 1000", func(t *testing.T) {
-			dir, err := driver.Open("Folder1")
-			require.NoError(t, err)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+			list := &drive.FileList{}
+			if file != nil && strings.Contains(r.URL.Query().Get("q"), "name='File1'") {
+				list.Files = []*drive.File{file}
+			}
 
-			files, err := dir.Readdir(1000)
-			require.NoError(t, err)
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+			file = &drive.File{Id: "file1", Name: "File1", MimeType: mimeTypeFile}
+			addRevision(readMedia(r))
+			writeJSON(w, http.StatusOK, file)
+		case r.Method == http.MethodPatch && r.URL.Path == "/upload/drive/v3/files/file1":
+			addRevision(readMedia(r))
+			writeJSON(w, http.StatusOK, file)
+		case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files/file1/revisions":
+			// One revision per page
+			page, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+			list := &drive.RevisionList{Revisions: revisions[page : page+1]}
+
+			if page+1 < len(revisions) {
+				list.NextPageToken = strconv.Itoa(page + 1)
+			}
 
-			require.Len(t, files, 2)
+			writeJSON(w, http.StatusOK, list)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/drive/v3/files/file1/revisions/"):
+			require.Equal(t, "media", r.URL.Query().Get("alt"))
 
-			// sort so we can be sure the test works with random order
-			sort.Slice(files, func(i, j int) bool {
-				return strings.Compare(files[i].Name(), files[j].Name()) == -1
-			})
+			content, ok := contents[path.Base(r.URL.Path)]
+			if !ok {
+				writeAPIError(w, http.StatusNotFound, "notFound")
 
-			require.Equal(t, "File1", files[0].Name())
-			require.Equal(t, "File2", files[1].Name())
-		})
+				return
+			}
 
-		t.Run("listing no limit", func(t *testing.T) {
-			dir, err := driver.Open("Folder1")
-			require.NoError(t, err)
+			_, _ = w.Write(content)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/drive/v3/files/file1/revisions/"):
+			patch := &drive.Revision{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(patch))
 
-			files, err := dir.Readdir(-1)
-			require.NoError(t, err)
+			for _, rev := range revisions {
+				if rev.Id == path.Base(r.URL.Path) {
+					rev.KeepForever = patch.KeepForever
+				}
+			}
 
-			require.Len(t, files, 2)
-		})
+			writeJSON(w, http.StatusOK, patch)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/drive/v3/files/file1/revisions/"):
+			id := path.Base(r.URL.Path)
+			for i, rev := range revisions {
+				if rev.Id == id {
+					revisions = append(revisions[:i], revisions[i+1:]...)
+					delete(contents, id)
+					w.WriteHeader(http.StatusNoContent)
+
+					return
+				}
+			}
 
-		// Partial listing
-		t.Run("partial", func(t *testing.T) {
-			mustWriteFile(t, driver, "Folder1/File3")
-			defer func() { require.NoError(t, driver.Remove("Folder1/File3")) }()
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
 
-			dir, err := driver.Open("Folder1")
-			require.NoError(t, err)
-			defer func() { _ = dir.Close() }()
+	first := []byte("first version")
 
-			files, err := dir.Readdir(2)
-			require.NoError(t, err)
-			require.Len(t, files, 2)
-			require.Equal(t, "File1", files[0].Name())
-			require.Equal(t, "File2", files[1].Name())
+	_, err := driver.CreateWith("File1", &drive.File{}, bytes.NewReader(first))
+	require.NoError(t, err)
 
-			files, err = dir.Readdir(2)
-			require.NoError(t, err)
-			require.Len(t, files, 1)
-			require.Equal(t, "File3", files[0].Name())
-		})
+	_, err = driver.UploadSized("File1", strings.NewReader("second version, longer"), -1)
+	require.NoError(t, err)
 
-		// Remove contents
-		require.NoError(t, driver.Remove("Folder1/File1"))
-		require.NoError(t, driver.Remove("Folder1/File2"))
+	list, err := driver.ListRevisions("File1")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "rev1", list[0].ID)
+	require.Equal(t, int64(len(first)), list[0].Size)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), list[0].ModifiedTime)
+	require.Equal(t, "rev2", list[1].ID)
+	require.False(t, list[0].KeepForever)
 
-		{ // Test if folder is empty
-			dir, err := driver.Open("Folder1")
-			require.NoError(t, err)
+	sum := md5.Sum(first) // nolint: gosec
+	require.Equal(t, hex.EncodeToString(sum[:]), list[0].MD5)
 
-			files, err := dir.Readdir(2000)
-			require.NoError(t, err)
+	reader, err := driver.DownloadRevision("File1", list[0].ID)
+	require.NoError(t, err)
 
-			require.Len(t, files, 0)
-		}
-	})
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, first, content)
 
-	t.Run("directory does not exist", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+	require.NoError(t, driver.KeepRevision("File1", "rev1", true))
 
-		_, err := driver.Open("Folder5")
-		require.EqualError(t, err, "`Folder5' does not exist")
-	})
+	list, err = driver.ListRevisions("File1")
+	require.NoError(t, err)
+	require.True(t, list[0].KeepForever)
 
-	t.Run("list File", func(t *testing.T) {
-		driver := setup(t).AsAfero()
+	require.NoError(t, driver.DeleteRevision("File1", "rev1"))
 
-		mustWriteFile(t, driver, "File1")
+	list, err = driver.ListRevisions("File1")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "rev2", list[0].ID)
 
-		dir, err := driver.Open("File1")
-		require.NoError(t, err)
+	_, err = driver.DownloadRevision("File1", "rev1")
+	require.True(t, IsNotExist(err))
 
-		_, err = dir.Readdir(1000)
-		require.EqualError(t, err, "file File1 is not a directory")
-	})
+	_, err = driver.ListRevisions("")
+	require.ErrorAs(t, err, &FileIsDirectoryError{})
 }
 
 func TestMove(t *testing.T) {
@@ -521,7 +6067,7 @@ func TestMove(t *testing.T) {
 		require.NoError(t, getError(driver.Stat("Folder2/File2")))
 
 		// Old File gone?
-		require.EqualError(t, getError(driver.Stat("Folder1/File1")), "`Folder1/File1' does not exist")
+		requireNotExist(t, getError(driver.Stat("Folder1/File1")), "Folder1/File1")
 
 		// Old Folder still exists?
 		require.NoError(t, getError(driver.Stat("Folder1")))
@@ -540,7 +6086,7 @@ func TestMove(t *testing.T) {
 		require.NoError(t, getError(driver.Stat("Folder2/File1")))
 
 		// Old File gone?
-		require.EqualError(t, getError(driver.Stat("Folder1/File1")), "`Folder1/File1' does not exist")
+		requireNotExist(t, getError(driver.Stat("Folder1/File1")), "Folder1/File1")
 
 		// Old Folder still exists?
 		require.NoError(t, getError(driver.Stat("Folder1")))
@@ -559,19 +6105,19 @@ func TestMove(t *testing.T) {
 		require.NoError(t, getError(driver.Stat("Folder1/File2")))
 
 		// Old File gone?
-		require.EqualError(t, getError(driver.Stat("Folder1/File1")), "`Folder1/File1' does not exist")
+		requireNotExist(t, getError(driver.Stat("Folder1/File1")), "Folder1/File1")
 	})
 
 	t.Run("move root", func(t *testing.T) {
 		driver := setup(t).AsAfero()
 
-		require.EqualError(t, driver.Rename("", "Folder1"), "forbidden for root directory")
+		require.ErrorIs(t, driver.Rename("", "Folder1"), ErrForbiddenOnRoot)
 	})
 
 	t.Run("invalid target", func(t *testing.T) {
 		driver := setup(t).AsAfero()
 
-		require.EqualError(t, driver.Rename("Folder1", ""), "path cannot be empty")
+		require.ErrorIs(t, driver.Rename("Folder1", ""), ErrEmptyPath)
 	})
 }
 
@@ -590,7 +6136,7 @@ func TestTrash(t *testing.T) {
 		require.NoError(t, driver.Remove("Folder1/File1"))
 
 		// File1 gone?
-		require.EqualError(t, getError(driver.Stat("Folder1/File1")), "`Folder1/File1' does not exist")
+		requireNotExist(t, getError(driver.Stat("Folder1/File1")), "Folder1/File1")
 
 		// Old Folder still exists?
 		require.NoError(t, getError(driver.Stat("Folder1")))
@@ -610,10 +6156,10 @@ func TestTrash(t *testing.T) {
 		require.NoError(t, driver.Remove("Folder1"))
 
 		// Folder1 gone?
-		require.EqualError(t, getError(driver.Stat("Folder1")), "`Folder1' does not exist")
+		requireNotExist(t, getError(driver.Stat("Folder1")), "Folder1")
 
 		// File1 gone?
-		require.EqualError(t, getError(driver.Stat("Folder1/File1")), "`Folder1' does not exist")
+		requireNotExist(t, getError(driver.Stat("Folder1/File1")), "Folder1")
 	})
 
 	t.Run("trash root", func(t *testing.T) {
@@ -624,7 +6170,7 @@ func TestTrash(t *testing.T) {
 			driver = src.AsAfero()
 		}
 
-		require.EqualError(t, driver.Remove(""), "forbidden for root directory")
+		require.ErrorIs(t, driver.Remove(""), ErrForbiddenOnRoot)
 	})
 }
 
@@ -684,6 +6230,128 @@ func TestListTrash(t *testing.T) {
 	})
 }
 
+func TestPathErrors(t *testing.T) {
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("q"), "name='File1'") {
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &drive.FileList{})
+	})
+
+	notExist := map[string]func() error{
+		"stat": func() error {
+			_, err := driver.Stat("Missing")
+
+			return err
+		},
+		"open": func() error {
+			_, err := driver.Open("Missing")
+
+			return err
+		},
+		"remove":  func() error { return driver.Remove("Missing") },
+		"rename":  func() error { return driver.Rename("Missing", "Other") },
+		"chmod":   func() error { return driver.Chmod("Missing", 0o600) },
+		"chtimes": func() error { return driver.Chtimes("Missing", time.Now(), time.Now()) },
+	}
+
+	for op, call := range notExist {
+		err := call()
+
+		var pathErr *os.PathError
+		require.ErrorAs(t, err, &pathErr, op)
+		require.Equal(t, op, pathErr.Op)
+		require.Equal(t, "Missing", pathErr.Path)
+
+		var fileNotExist *FileNotExistError
+		require.ErrorAs(t, err, &fileNotExist, op)
+		require.ErrorIs(t, err, os.ErrNotExist, op)
+		require.True(t, IsNotExist(err), op)
+	}
+
+	_, err := driver.OpenFile("File1", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	require.EqualError(t, err, `open File1: "File1" already exists`)
+	require.ErrorIs(t, err, os.ErrExist)
+	require.True(t, IsExist(err))
+
+	// Calls between methods don't wrap the error twice
+	_, err = driver.Create("File1/Sub")
+
+	var notDir *FileIsNotDirectoryError
+	require.ErrorAs(t, err, &notDir)
+
+	var pathErr *os.PathError
+	require.ErrorAs(t, err, &pathErr)
+	require.Equal(t, "open", pathErr.Op)
+	require.NotErrorAs(t, pathErr.Err, new(*os.PathError))
+
+	require.ErrorIs(t, driver.Chown("File1", 0, 0), ErrNotSupported)
+}
+
+func TestAPIErrorClassification(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		code   int
+		reason string
+	)
+
+	driver := newFakeDriver(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case strings.Contains(r.URL.Query().Get("q"), "name='File1'"):
+			writeJSON(w, http.StatusOK, &drive.FileList{Files: []*drive.File{
+				{Id: "file1", Name: "File1", MimeType: mimeTypeFile},
+			}})
+		case r.Method == http.MethodDelete && r.URL.Path == "/drive/v3/files/file1":
+			writeAPIError(w, code, reason)
+		default:
+			writeAPIError(w, http.StatusNotFound, "notFound")
+		}
+	})
+
+	for _, tc := range []struct {
+		code             int
+		reason           string
+		rateLimited      bool
+		quotaExceeded    bool
+		permissionDenied bool
+		notExist         bool
+	}{
+		{code: http.StatusTooManyRequests, reason: "rateLimitExceeded", rateLimited: true},
+		{code: http.StatusForbidden, reason: "userRateLimitExceeded", rateLimited: true},
+		{code: http.StatusForbidden, reason: "storageQuotaExceeded", quotaExceeded: true},
+		{code: http.StatusForbidden, reason: "insufficientFilePermissions", permissionDenied: true},
+		{code: http.StatusNotFound, reason: "notFound", notExist: true},
+		{code: http.StatusInternalServerError, reason: "backendError"},
+	} {
+		mu.Lock()
+		code, reason = tc.code, tc.reason
+		mu.Unlock()
+
+		err := driver.Remove("File1")
+		require.Error(t, err, tc.reason)
+
+		var apiErr *DriveAPICallError
+		require.ErrorAs(t, err, &apiErr, tc.reason)
+
+		require.Equal(t, tc.rateLimited, IsRateLimited(err), tc.reason)
+		require.Equal(t, tc.quotaExceeded, IsQuotaExceeded(err), tc.reason)
+		require.Equal(t, tc.permissionDenied, errors.Is(err, ErrPermissionDenied), tc.reason)
+		require.Equal(t, tc.notExist, IsNotExist(err), tc.reason)
+		require.Equal(t, tc.notExist, errors.Is(err, os.ErrNotExist), tc.reason)
+
+		var notExist *FileNotExistError
+		require.Equal(t, tc.notExist, errors.As(err, &notExist), tc.reason)
+	}
+}
+
 func TestIsInRoot(t *testing.T) {
 	t.Run("in folder", func(t *testing.T) {
 		driver := setup(t)
@@ -729,6 +6397,28 @@ func TestAferoSpecifics(t *testing.T) {
 	})
 }
 
+func TestShareLink(t *testing.T) {
+	driver := setup(t)
+
+	mustWriteFileContent(t, driver, "Shared", "Shared test")
+
+	perm, err := driver.Grant("Shared", SharePermission{Type: "anyone", Role: "reader"})
+	require.NoError(t, err)
+	require.NotEmpty(t, perm.ID)
+	require.NotEmpty(t, perm.WebViewLink)
+
+	permissions, err := driver.ListPermissions("Shared")
+	require.NoError(t, err)
+
+	ids := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		ids = append(ids, p.ID)
+	}
+
+	require.Contains(t, ids, perm.ID)
+	require.NoError(t, driver.RemovePermission("Shared", perm.ID))
+}
+
 func TestOpen(t *testing.T) {
 	t.Run("read", func(t *testing.T) {
 		t.Run("existing File", func(t *testing.T) {
@@ -797,14 +6487,14 @@ func TestOpen(t *testing.T) {
 			driver := setup(t).AsAfero()
 
 			f, err := driver.OpenFile("Folder1/File1", os.O_RDONLY, os.FileMode(0))
-			require.EqualError(t, err, FileNotExistError{Path: "Folder1/File1"}.Error())
+			requireNotExist(t, err, "Folder1/File1")
 			require.Nil(t, f)
 		})
 		t.Run("non-existing File with create", func(t *testing.T) {
 			driver := setup(t).AsAfero()
 
 			f, err := driver.OpenFile("Folder1/File1", os.O_RDONLY|os.O_CREATE, os.FileMode(0))
-			require.EqualError(t, err, FileNotExistError{Path: "Folder1/File1"}.Error())
+			requireNotExist(t, err, "Folder1/File1")
 			require.Nil(t, f)
 		})
 	})
@@ -833,7 +6523,7 @@ func TestOpen(t *testing.T) {
 			driver := setup(t).AsAfero()
 
 			f, err := driver.OpenFile("Folder1/File1", os.O_WRONLY, os.FileMode(0))
-			require.EqualError(t, err, FileNotExistError{Path: "Folder1/File1"}.Error())
+			requireNotExist(t, err, "Folder1/File1")
 			require.Nil(t, f)
 		})
 		t.Run("non-existing File with create", func(t *testing.T) {
@@ -861,7 +6551,7 @@ func TestErrNotSupported(t *testing.T) {
 
 	t.Run("Chown", func(t *testing.T) {
 		mustWriteFile(t, driver, "Chown")
-		require.EqualError(t, driver.Chown("Chown", 2000, 2000), ErrNotSupported.Error())
+		require.ErrorIs(t, driver.Chown("Chown", 2000, 2000), ErrNotSupported)
 	})
 
 	t.Run("Truncate", func(t *testing.T) {
@@ -903,6 +6593,15 @@ func mustCreateDir(t *testing.T, driver afero.Fs, path string) {
 	require.NoError(t, driver.Mkdir(path, os.FileMode(0)))
 }
 
+// requireNotExist checks that an error is a FileNotExistError for a given path
+func requireNotExist(t *testing.T, err error, path string) {
+	t.Helper()
+
+	var notExist *FileNotExistError
+	require.ErrorAs(t, err, &notExist)
+	require.Equal(t, path, notExist.Path)
+}
+
 func getError(_ os.FileInfo, err error) error {
 	return err
 }
@@ -0,0 +1,97 @@
+package gdrive // nolint: golint
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// GoogleDocPolicy defines how the Google Workspace documents (Docs, Sheets, Slides...) are handled when they
+// are read or listed. These documents don't have any content of their own that could be downloaded, and Drive
+// reports a size of 0 for them. Writing them is controlled separately by OverwriteNativeDocs.
+type GoogleDocPolicy int
+
+const (
+	// GoogleDocError lists the documents like the other files, but opening one for reading fails with a
+	// NativeDocReadError, as do OpenBuffered, ReadFiles and DownloadToFile. This is the default.
+	GoogleDocError GoogleDocPolicy = iota
+	// GoogleDocExport lists the documents like the other files, and reading one streams its export instead,
+	// in the GoogleDocExportMime format if it is set. Otherwise, Word is used for documents, Excel for
+	// spreadsheets, PowerPoint for presentations, PNG for drawings and PDF for the others. The size of the
+	// export isn't known beforehand, so Stat still reports 0 and seeking relatively to the end of the file
+	// doesn't work.
+	GoogleDocExport
+	// GoogleDocSkipInListings hides the documents from Readdir, Readdirnames, OpenDir, ListMatching and ListRich,
+	// and thus from the walks based on them like afero.Walk. Opening a document by its path fails like with
+	// GoogleDocError. Searches like ListRecent or FindByProperty and the trash listing still return them.
+	GoogleDocSkipInListings
+)
+
+const (
+	// googleDocExportMimeDefault is the export format of the documents that aren't in googleDocExportMimes
+	googleDocExportMimeDefault = "application/pdf"
+
+	// mimeTypeOfficePrefix is the prefix of the mime types of the Office formats
+	mimeTypeOfficePrefix = "application/vnd.openxmlformats-officedocument."
+)
+
+// googleDocExportMimes are the formats the documents are exported to with GoogleDocExport
+var googleDocExportMimes = map[string]string{
+	"application/vnd.google-apps.document":     mimeTypeOfficePrefix + "wordprocessingml.document",
+	"application/vnd.google-apps.spreadsheet":  mimeTypeOfficePrefix + "spreadsheetml.sheet",
+	"application/vnd.google-apps.presentation": mimeTypeOfficePrefix + "presentationml.presentation",
+	"application/vnd.google-apps.drawing":      "image/png",
+}
+
+// googleDocExportMime returns the format a document is exported to
+func googleDocExportMime(mimeType string) string {
+	if exportMime, ok := googleDocExportMimes[mimeType]; ok {
+		return exportMime
+	}
+
+	return googleDocExportMimeDefault
+}
+
+// isGoogleDoc returns true if a file is a Google Workspace document. Unlike FileInfo.IsNative, shortcuts
+// aren't considered as documents.
+func isGoogleDoc(file *drive.File) bool {
+	return strings.HasPrefix(file.MimeType, mimeTypeNativePrefix) &&
+		file.MimeType != mimeTypeFolder &&
+		file.MimeType != mimeTypeShortcut
+}
+
+// hiddenInListings returns true if a file must be dropped from the listings because of the GoogleDocPolicy
+func (d *GDriver) hiddenInListings(file *drive.File) bool {
+	return d.GoogleDocPolicy == GoogleDocSkipInListings && isGoogleDoc(file)
+}
+
+// getGoogleDocReader returns a stream of the export of a document, starting at offset. Exports can't be
+// requested from a given position, so the content before offset is downloaded and discarded.
+func (d *GDriver) getGoogleDocReader(ctx context.Context, fi *FileInfo, offset int64) (io.ReadCloser, error) {
+	if d.GoogleDocPolicy != GoogleDocExport {
+		return nil, &NativeDocReadError{Path: fi.Path(), MimeType: fi.file.MimeType}
+	}
+
+	exportMime := d.GoogleDocExportMime
+	if exportMime == "" {
+		exportMime = googleDocExportMime(fi.file.MimeType)
+	}
+
+	reader, err := d.exportFile(ctx, fi, exportMime)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, reader, offset); err != nil && !errors.Is(err, io.EOF) {
+			_ = reader.Close()
+
+			return nil, err
+		}
+	}
+
+	return reader, nil
+}
@@ -0,0 +1,61 @@
+package gdrive // nolint: golint
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// DownloadLink downloads a file from its download link (the drive.File WebContentLink).
+// For files too large to be scanned for viruses, Drive replies to these links with an HTML warning page
+// instead of the content. In that case, the content is downloaded through the authenticated media
+// endpoint of the API, which doesn't have this interstitial.
+func (d *GDriver) DownloadLink(link string) (io.ReadCloser, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.srvWrapper.httpClient.Do(req)
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	if err = googleapi.CheckResponse(resp); err != nil {
+		_ = resp.Body.Close()
+
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	if !isVirusScanWarning(resp) {
+		return resp.Body, nil
+	}
+
+	_ = resp.Body.Close()
+
+	fileID := u.Query().Get("id")
+	if fileID == "" {
+		return nil, ErrVirusScanWarning
+	}
+
+	d.Logger.Debug("Download link returned a virus scan warning, using the API instead", "fileId", fileID)
+
+	return d.getFileReader(&FileInfo{file: &drive.File{Id: fileID}}, 0)
+}
+
+// isVirusScanWarning checks if a download link response is the HTML warning page rather than the file.
+// Drive never serves the content of a file as HTML on these links.
+func isVirusScanWarning(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	return err == nil && mediaType == "text/html"
+}
@@ -0,0 +1,190 @@
+package gdrive // nolint: golint
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// MetricsObserver receives the outcome of the calls to the Google Drive API, so that they can be reported
+// to any metrics system, like Prometheus
+type MetricsObserver interface {
+	// ObserveCall is called once each call is answered, with the API method like "Files.List", the duration
+	// of the call including its retries, and its error. A call rejected by the API has a *googleapi.Error
+	// holding the status code of the response. A resumable upload, whose content is sent in chunks, is a single
+	// call lasting from the start of the upload to the answer to its last chunk. It is called concurrently by
+	// the calls performed in parallel.
+	ObserveCall(api string, duration time.Duration, err error)
+}
+
+// resumeIncomplete returns true if a response answers a chunk of a resumable upload that isn't the last one. The
+// upload machinery asks for a 200 status with a header overriding it, instead of a 308 status.
+func resumeIncomplete(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusPermanentRedirect || resp.Header.Get("X-Http-Status-Code-Override") == "308"
+}
+
+// metricsTransport reports each call to the MetricsObserver of the driver
+type metricsTransport struct {
+	base    http.RoundTripper         // base performs the calls
+	driver  *GDriver                  // driver provides the MetricsObserver
+	mu      sync.Mutex                // mu protects uploads
+	uploads map[string]resumableStart // uploads are the resumable uploads in progress, by upload ID
+}
+
+// resumableStart is the call that started a resumable upload
+type resumableStart struct {
+	api   string    // api is the API method of the upload, like "Files.Create"
+	start time.Time // start is when the upload was started
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	observer := t.driver.MetricsObserver
+	if observer == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+
+	api, start, done := t.resumable(req, resp, err, start)
+	if !done {
+		return resp, err
+	}
+
+	observed := err
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		// The body is left to the caller, which builds the detailed error from it
+		observed = &googleapi.Error{Code: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+
+	observer.ObserveCall(api, time.Since(start), observed)
+
+	return resp, err
+}
+
+// resumable returns the API method of a call and when it started. The calls of a resumable upload are grouped:
+// the call starting it and the chunks but the last one aren't done, and the last one gives the API method and
+// start of the whole upload.
+func (t *metricsTransport) resumable(
+	req *http.Request,
+	resp *http.Response,
+	err error,
+	start time.Time,
+) (string, time.Time, bool) {
+	query := req.URL.Query()
+
+	if uploadID := query.Get("upload_id"); uploadID != "" {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if err == nil && resumeIncomplete(resp) {
+			return "", start, false
+		}
+
+		upload, ok := t.uploads[uploadID]
+		if !ok {
+			return apiMethod(req), start, true
+		}
+
+		delete(t.uploads, uploadID)
+
+		return upload.api, upload.start, true
+	}
+
+	if query.Get("uploadType") != "resumable" || err != nil || resp.StatusCode >= http.StatusBadRequest {
+		return apiMethod(req), start, true
+	}
+
+	location, errLocation := resp.Location()
+	if errLocation != nil || location.Query().Get("upload_id") == "" {
+		return apiMethod(req), start, true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.uploads[location.Query().Get("upload_id")] = resumableStart{api: apiMethod(req), start: start}
+
+	return "", start, false
+}
+
+// apiActions are the names of the API methods acting on a single resource, like "files/<id>/copy"
+var apiActions = map[string]string{
+	"copy":   "Copy",
+	"export": "Export",
+	"watch":  "Watch",
+}
+
+// apiMethod returns the name of the API method called by a request, like "Files.List" or "Permissions.Create"
+func apiMethod(req *http.Request) string {
+	urlPath := strings.TrimPrefix(req.URL.Path, "/upload")
+
+	if strings.HasPrefix(urlPath, "/batch/") {
+		return "Batch"
+	}
+
+	urlPath = strings.Trim(strings.TrimPrefix(urlPath, "/drive/v3"), "/")
+
+	switch urlPath {
+	case "about":
+		return "About.Get"
+	case "files/trash":
+		return "Files.EmptyTrash"
+	case "files/generateIds":
+		return "Files.GenerateIds"
+	}
+
+	parts := strings.Split(urlPath, "/")
+
+	// The parts alternate between a resource and the ID of one of its items, an action can come last
+	if len(parts) > 2 && len(parts)%2 == 1 {
+		if action, ok := apiActions[parts[len(parts)-1]]; ok {
+			return resourceName(parts[len(parts)-3]) + "." + action
+		}
+	}
+
+	resource := resourceName(parts[(len(parts)-1)/2*2])
+	withID := len(parts)%2 == 0
+
+	switch {
+	case req.Method == http.MethodGet && withID && req.URL.Query().Get("alt") == "media":
+		return resource + ".Download"
+	case req.Method == http.MethodGet && withID:
+		return resource + ".Get"
+	case req.Method == http.MethodGet:
+		return resource + ".List"
+	case req.Method == http.MethodPost:
+		return resource + ".Create"
+	case req.Method == http.MethodPatch || req.Method == http.MethodPut:
+		return resource + ".Update"
+	case req.Method == http.MethodDelete:
+		return resource + ".Delete"
+	default:
+		return req.Method + " " + req.URL.Path
+	}
+}
+
+// resourceName capitalizes the name of a resource as it appears in a path, like "permissions"
+func resourceName(name string) string {
+	if name == "" {
+		return name
+	}
+
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// withMetrics returns a copy of the client whose calls are reported to the MetricsObserver of the driver
+func withMetrics(client *http.Client, driver *GDriver) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	wrapped := *client
+	wrapped.Transport = &metricsTransport{base: base, driver: driver, uploads: make(map[string]resumableStart)}
+
+	return &wrapped
+}
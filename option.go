@@ -1,5 +1,13 @@
 package gdrive // nolint: golint
 
+import (
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/fclairamb/afero-gdrive/cache"
+)
+
 // Option can be used to pass optional Options to GDriver
 type Option func(driver *GDriver) error
 
@@ -11,3 +19,211 @@ func RootDirectory(path string) Option {
 		return err
 	}
 }
+
+// ListRetry defines how many times and with which initial backoff a listing page failing with a rate-limit or a
+// transient server error is retried, 3 times from 1 second by default. The backoff doubles after each attempt.
+// It only applies if it allows more retries than Retry.
+func ListRetry(maxRetries int, backoff time.Duration) Option {
+	return func(driver *GDriver) error {
+		driver.ListRetryMax = maxRetries
+		driver.ListRetryBackoff = backoff
+
+		return nil
+	}
+}
+
+// CreateRetry defines how many times and with which initial backoff the lookup of a file that was
+// recently created through the driver is retried when it can't be found. The backoff doubles after each
+// attempt. Google Drive doesn't always list newly created files immediately.
+func CreateRetry(maxRetries int, backoff time.Duration) Option {
+	return func(driver *GDriver) error {
+		driver.CreateRetryMax = maxRetries
+		driver.CreateRetryBackoff = backoff
+
+		return nil
+	}
+}
+
+// Retry makes the calls failing because of a rate-limit (429, or 403 with a rate-limit reason) or a transient
+// server error (500, 502, 503) be retried up to maxRetries times. The delay between two attempts starts around baseDelay and doubles after
+// each attempt, unless the API asks for a specific delay with a Retry-After header. Uploads streaming their
+// content can't be replayed and aren't retried, see UploadSpill for them.
+func Retry(maxRetries int, baseDelay time.Duration) Option {
+	return func(driver *GDriver) error {
+		driver.RetryMax = maxRetries
+		driver.RetryBaseDelay = baseDelay
+
+		return nil
+	}
+}
+
+// SeekForward defines the largest forward seek, in bytes, performed by discarding the content in between
+// from the current download of a file instead of starting a new download. 0 disables it.
+func SeekForward(threshold int64) Option {
+	return func(driver *GDriver) error {
+		driver.SeekForwardThreshold = threshold
+
+		return nil
+	}
+}
+
+// WritePipeBuffer defines the size of the buffer coalescing small writes before they reach the upload
+// pipe. 0 disables it.
+func WritePipeBuffer(size int) Option {
+	return func(driver *GDriver) error {
+		driver.WritePipeBufferSize = size
+
+		return nil
+	}
+}
+
+// SharedDrive makes the driver work on a shared drive instead of the user's own drive. The root
+// directory is reset to the root of the shared drive, so this option must be passed before RootDirectory.
+func SharedDrive(driveID string) Option {
+	return func(driver *GDriver) error {
+		driver.srvWrapper.sharedDriveID = driveID
+		_, err := driver.SetRootDirectory("")
+
+		return err
+	}
+}
+
+// WithoutDescription prevents the driver from setting its default description on the files and directories
+// it creates. Descriptions explicitly set in a CreateWith template are still sent.
+func WithoutDescription() Option {
+	return func(driver *GDriver) error {
+		driver.srvWrapper.noDescription = true
+
+		return nil
+	}
+}
+
+// ReadWrite allows to open files with os.O_RDWR. The whole content of such files is downloaded in memory when
+// they are opened, and uploaded back when they are closed if they were modified. Like for OpenBuffered, the
+// files can't be bigger than BufferedReadMaxSize.
+func ReadWrite() Option {
+	return func(driver *GDriver) error {
+		driver.AllowReadWrite = true
+
+		return nil
+	}
+}
+
+// BufferedSpill makes OpenBuffered download the files bigger than threshold to a temporary file of fs instead
+// of keeping them in memory.
+func BufferedSpill(fs afero.Fs, threshold int64) Option {
+	return func(driver *GDriver) error {
+		driver.BufferedSpillFs = fs
+		driver.BufferedSpillThreshold = threshold
+
+		return nil
+	}
+}
+
+// UploadSpill makes the content written to the files be copied to a temporary file of fs while it is uploaded,
+// so that the upload is performed once more if it fails with a transient error. Writing more than maxSize
+// bytes to a file makes its upload fail.
+func UploadSpill(fs afero.Fs, maxSize int64) Option {
+	return func(driver *GDriver) error {
+		driver.UploadSpillFs = fs
+		driver.UploadSpillMaxSize = maxSize
+
+		return nil
+	}
+}
+
+// GoogleDocs defines how the Google Workspace documents are handled when they are read or listed, see
+// GoogleDocPolicy. By default, reading them fails.
+func GoogleDocs(policy GoogleDocPolicy) Option {
+	return func(driver *GDriver) error {
+		driver.GoogleDocPolicy = policy
+
+		return nil
+	}
+}
+
+// WithRateLimit throttles the calls to the API to qps calls per second, with bursts of up to burst calls, so that
+// the quota isn't exceeded in the first place. The limit is shared by all the calls of the driver, including the
+// retried ones, and waiting for it is aborted when the context of the call is done.
+func WithRateLimit(qps float64, burst int) Option {
+	return func(driver *GDriver) error {
+		driver.srvWrapper.limiter.setLimit(qps, burst)
+
+		return nil
+	}
+}
+
+// WithViewedByMe makes the driver fetch when the account last viewed the files, see FetchViewedByMe
+func WithViewedByMe() Option {
+	return func(driver *GDriver) error {
+		driver.FetchViewedByMe = true
+
+		return nil
+	}
+}
+
+// WithDownloadProgress makes File.Read report the progress of the files being read to progress, see
+// DownloadProgress
+func WithDownloadProgress(progress func(downloaded, total int64)) Option {
+	return func(driver *GDriver) error {
+		driver.DownloadProgress = progress
+
+		return nil
+	}
+}
+
+// NotFoundCache sets how long the lookups of the paths that don't exist are cached, 5 seconds by default. It
+// also applies to the names missing from a cached listing of a directory. The cached entries of a directory are
+// dropped when a file is created in it through the driver, so this only delays the discovery of the files
+// created by someone else. A ttl of 0 disables the caching of these lookups.
+func NotFoundCache(ttl time.Duration) Option {
+	return func(driver *GDriver) error {
+		driver.srvWrapper.notFoundTTL = ttl
+
+		return nil
+	}
+}
+
+// CacheMaxEntries bounds the number of entries of the cache, the least recently used ones being evicted once
+// it is full. The cache is unbounded by default, which can use a lot of memory when browsing huge trees.
+func CacheMaxEntries(maxEntries int) Option {
+	return func(driver *GDriver) error {
+		driver.srvWrapper.cache = cache.NewCacheWithMaxEntries(maxEntries)
+
+		return nil
+	}
+}
+
+// WithMetricsObserver reports each call to the API to an observer, to expose their count, latency and errors
+// in a metrics system
+func WithMetricsObserver(observer MetricsObserver) Option {
+	return func(driver *GDriver) error {
+		driver.MetricsObserver = observer
+
+		return nil
+	}
+}
+
+// CoalesceLookups makes the driver list all the children of a folder at once when it looks up many of them by
+// name in a short time, like when statting the files of a folder one after the other. The following lookups in
+// the folder are then served from the cache, including the ones of files that don't exist, until the folder
+// is modified through the driver. This requires the cache. Lookups following a complete Readdir of the folder
+// are always served from its result.
+func CoalesceLookups() Option {
+	return func(driver *GDriver) error {
+		driver.srvWrapper.coalesceLookups = true
+
+		return nil
+	}
+}
+
+// ExportGoogleDocsAs makes the Google Workspace documents readable by exporting them to the exportMime format,
+// like "application/pdf". All the documents must support the format, as listed by Google Drive's About.Get call.
+func ExportGoogleDocsAs(exportMime string) Option {
+	return func(driver *GDriver) error {
+		driver.GoogleDocPolicy = GoogleDocExport
+		driver.GoogleDocExportMime = exportMime
+
+		return nil
+	}
+}
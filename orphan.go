@@ -0,0 +1,97 @@
+package gdrive // nolint: golint
+
+import (
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// orphansQuery matches the files that could be orphans. Drive can't search for the files without parents, so
+// the files owned by the account are listed and the ones with parents are dropped.
+const orphansQuery = "'me' in owners and trashed = false"
+
+// ListOrphans lists up to limit files owned by the account that don't have any parent anymore, which happens
+// when the folder containing a file is deleted by another user. These files aren't within the root directory,
+// so their parent path is empty. The query used is "'me' in owners and trashed = false": all the files of the
+// account are listed to find them, which can take a while. There are no orphans on shared drives.
+func (d *GDriver) ListOrphans(limit int) ([]*FileInfo, error) {
+	driveRoot, err := d.getDriveRootNode()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*FileInfo
+
+	pageToken := ""
+
+	for len(list) < limit {
+		call := d.srvWrapper.filesList().
+			Q(orphansQuery).
+			Fields(d.fileFields("parents"), "nextPageToken").
+			PageSize(filesListPageSizeMax)
+
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		files, err := d.listPage(call)
+		if err != nil {
+			return list, &DriveAPICallError{Err: err}
+		}
+
+		for _, file := range files.Files {
+			// The root folder of the drive doesn't have a parent either
+			if len(file.Parents) == 0 && file.Id != driveRoot.file.Id && len(list) < limit {
+				list = append(list, &FileInfo{file: file})
+			}
+		}
+
+		pageToken = files.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return list, nil
+}
+
+// ReparentOrphan moves an orphan, as returned by ListOrphans, to a directory of the root directory so that it
+// can be found again. The directory is created if needed. ErrNotOrphan is returned if the file has a parent.
+func (d *GDriver) ReparentOrphan(id, destPath string) (*FileInfo, error) {
+	file, err := d.srv.Files.Get(id).Fields("id,parents").SupportsAllDrives(true).Do()
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	if len(file.Parents) > 0 {
+		return nil, ErrNotOrphan
+	}
+
+	pathParts, err := splitPath(destPath)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := d.makeDirectoryByParts(pathParts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !folder.IsDir() {
+		return nil, &FileIsNotDirectoryError{Fi: folder, Path: destPath}
+	}
+
+	d.srvWrapper.calling("Files.Update")
+
+	file, err = d.srv.Files.Update(id, &drive.File{}).
+		AddParents(folder.folderID()).
+		Fields(googleapi.Field(googleapi.CombineFields(fileInfoFields)), "parents").
+		SupportsAllDrives(true).
+		Do()
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	d.srvWrapper.cache.CleanupByPrefix(folder.folderID() + "-")
+
+	return &FileInfo{file: file, parentPath: folder.Path()}, nil
+}
@@ -0,0 +1,40 @@
+package gdrive // nolint: golint
+
+import (
+	"google.golang.org/api/drive/v3"
+)
+
+// Quota describes the storage used by the account, in bytes
+type Quota struct {
+	User              *drive.User // User is the account the quota applies to
+	Limit             int64       // Limit is the storage available to the account
+	Usage             int64       // Usage is the storage used across all the Google services
+	UsageInDrive      int64       // UsageInDrive is the storage used by the files of Google Drive
+	UsageInDriveTrash int64       // UsageInDriveTrash is the storage used by the trashed files of Google Drive
+}
+
+// About returns the storage quota of the account. It doesn't depend on the drive the driver works on, the
+// shared drives don't count in it. An UnlimitedQuotaError, containing the usage, is returned if the account
+// doesn't have any limit.
+func (d *GDriver) About() (*Quota, error) {
+	about, err := d.srv.About.Get().Fields("user", "storageQuota").Do()
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	quota := &Quota{User: about.User}
+
+	if about.StorageQuota != nil {
+		quota.Limit = about.StorageQuota.Limit
+		quota.Usage = about.StorageQuota.Usage
+		quota.UsageInDrive = about.StorageQuota.UsageInDrive
+		quota.UsageInDriveTrash = about.StorageQuota.UsageInDriveTrash
+	}
+
+	// Drive doesn't report the limit of the unlimited accounts
+	if quota.Limit == 0 {
+		return nil, &UnlimitedQuotaError{Quota: quota}
+	}
+
+	return quota, nil
+}
@@ -0,0 +1,151 @@
+package gdrive // nolint: golint
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the rate of the calls. Tokens are added at rate per second, up to
+// burst, and each call takes one. When the bucket is empty, the calls wait until a token is available. The
+// limit can be changed at any time, the waiting calls then wait according to the new limit.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64       // rate is the number of tokens added per second, calls aren't limited if it's <= 0
+	burst   int           // burst is the capacity of the bucket
+	tokens  float64       // tokens is the number of available tokens
+	last    time.Time     // last is when tokens was last updated
+	changed chan struct{} // changed is closed when the limit changes, to wake up the waiting calls
+}
+
+// newRateLimiter creates an unlimited rate limiter
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{last: time.Now(), burst: 1, changed: make(chan struct{})}
+}
+
+// setLimit changes the rate and the burst of the limiter, a burst lower than 1 is considered as 1
+func (l *rateLimiter) setLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.update(rate, burst)
+}
+
+// setRate changes the rate of the limiter and keeps its burst
+func (l *rateLimiter) setRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.update(rate, l.burst)
+}
+
+// update changes the limit, l.mu must be held
+func (l *rateLimiter) update(rate float64, burst int) {
+	l.refill(time.Now())
+
+	if l.rate <= 0 {
+		// The bucket starts full, it was never consumed while calls weren't limited
+		l.tokens = float64(burst)
+	}
+
+	l.rate = rate
+	l.burst = burst
+
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// refill adds the tokens accumulated since the last update
+func (l *rateLimiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+
+	l.last = now
+}
+
+// wait takes a token, waiting for it if needed, or returns the error of ctx if it's done before
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+
+		if l.rate <= 0 {
+			l.mu.Unlock()
+
+			return nil
+		}
+
+		l.refill(time.Now())
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+
+			return nil
+		}
+
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		changed := l.changed
+
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err()
+		}
+	}
+}
+
+// SetRateLimit changes the maximum number of calls per second the driver performs to the API, while it is in
+// use. The calls already waiting for the limit follow the new one. Zero or a negative rate removes the limit.
+// The burst set by WithRateLimit is kept, it is 1 otherwise.
+func (d *GDriver) SetRateLimit(perSecond float64) {
+	d.srvWrapper.limiter.setRate(perSecond)
+}
+
+// rateLimitTransport makes the calls wait for the rate limiter of the driver before hitting the network
+type rateLimitTransport struct {
+	base    http.RoundTripper // base performs the calls
+	limiter *rateLimiter      // limiter is shared by all the calls of the driver
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// withRateLimit returns a copy of the client whose calls are limited by limiter
+func withRateLimit(client *http.Client, limiter *rateLimiter) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	wrapped := *client
+	wrapped.Transport = &rateLimitTransport{base: base, limiter: limiter}
+
+	return &wrapped
+}
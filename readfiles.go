@@ -0,0 +1,85 @@
+package gdrive // nolint: golint
+
+import (
+	"context"
+	"sync"
+)
+
+// ReadFiles downloads the content of several files in memory, with at most concurrency downloads at the same
+// time. The contents and the errors are returned by path, each path is present in one of the two maps. Like
+// for OpenBuffered, the files can't be bigger than BufferedReadMaxSize.
+func (d *GDriver) ReadFiles(paths []string, concurrency int) (map[string][]byte, map[string]error) {
+	return d.ReadFilesContext(context.Background(), paths, concurrency)
+}
+
+// ReadFilesContext behaves like ReadFiles, except that the downloads are aborted when ctx is done, in which
+// case the paths that weren't read get the error of the context.
+func (d *GDriver) ReadFilesContext(
+	ctx context.Context,
+	paths []string,
+	concurrency int,
+) (map[string][]byte, map[string]error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		contents  = make(map[string][]byte, len(paths))
+		errs      = make(map[string]error)
+		semaphore = make(chan struct{}, concurrency)
+	)
+
+	for _, p := range paths {
+		wg.Add(1)
+
+		go func(p string) {
+			defer wg.Done()
+
+			var (
+				content []byte
+				err     error
+			)
+
+			select {
+			case semaphore <- struct{}{}:
+				content, err = d.readFile(ctx, p)
+				<-semaphore
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs[p] = err
+			} else {
+				contents[p] = content
+			}
+		}(p)
+	}
+
+	wg.Wait()
+
+	return contents, errs
+}
+
+// readFile downloads the whole content of a file
+func (d *GDriver) readFile(ctx context.Context, filePath string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fi, err := d.getFile(filePath, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, FileIsDirectoryError{Path: fi.Path()}
+	}
+
+	return d.downloadContent(ctx, fi, fi.Path())
+}
@@ -0,0 +1,161 @@
+package gdrive // nolint: golint
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// retryBackoffMax caps the delay between two attempts, Retry-After excepted
+const retryBackoffMax = time.Minute
+
+// listRetryKey marks the context of the calls fetching a listing page, which are retried according to the
+// ListRetryMax and ListRetryBackoff of the driver
+type listRetryKey struct{}
+
+// retryTransport retries the calls failing with a rate-limit or a transient server error, with an
+// exponential backoff configured by the RetryMax and RetryBaseDelay of the driver
+type retryTransport struct {
+	base   http.RoundTripper // base performs the calls
+	driver *GDriver          // driver provides the retry configuration
+}
+
+// policy returns how many times and with which base delay a call is retried
+func (t *retryTransport) policy(req *http.Request) (int, time.Duration) {
+	if req.Context().Value(listRetryKey{}) != nil && t.driver.ListRetryMax > t.driver.RetryMax {
+		return t.driver.ListRetryMax, t.driver.ListRetryBackoff
+	}
+
+	return t.driver.RetryMax, t.driver.RetryBaseDelay
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	maxRetries, baseDelay := t.policy(req)
+
+	for attempt := 0; ; attempt++ {
+		retry := req
+
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			retry = req.Clone(req.Context())
+			retry.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(retry)
+		if err != nil || attempt >= maxRetries || !isRetryableResponse(resp) {
+			return resp, err
+		}
+
+		// Calls streaming their content can't be replayed
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		delay := retryDelay(resp, baseDelay, attempt)
+		_ = resp.Body.Close()
+
+		t.driver.Logger.Warn("Call failed, retrying",
+			"status", resp.StatusCode,
+			"attempt", attempt+1,
+			"delay", delay,
+		)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// isRetryableStatus returns true if a call failing with a status code might succeed later
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableResponse returns true if a call failing with a response might succeed later. A 403 is a rate-limit
+// or not depending on the reason given in its body, which is read and kept for the caller.
+func isRetryableResponse(resp *http.Response) bool {
+	if isRetryableStatus(resp.StatusCode) {
+		return true
+	}
+
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err != nil {
+		return false
+	}
+
+	checked := *resp
+	checked.Body = io.NopCloser(bytes.NewReader(body))
+
+	return isRateLimitError(googleapi.CheckResponse(&checked))
+}
+
+// retryDelay returns how long to wait before the next attempt: the delay requested by the Retry-After
+// header if there is one, and otherwise baseDelay doubled after each attempt, with jitter
+func retryDelay(resp *http.Response, baseDelay time.Duration, attempt int) time.Duration {
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		if seconds, err := strconv.Atoi(after); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+
+		if date, err := http.ParseTime(after); err == nil {
+			if delay := time.Until(date); delay > 0 {
+				return delay
+			}
+
+			return 0
+		}
+	}
+
+	delay := baseDelay << attempt
+	if delay > retryBackoffMax || delay < baseDelay {
+		delay = retryBackoffMax
+	}
+
+	// The jitter spreads the attempts of the calls that failed at the same time
+	half := int64(delay / 2) // nolint: gomnd
+
+	return time.Duration(half + rand.Int63n(half+1)) // nolint: gosec
+}
+
+// withRetry returns a copy of the client whose calls are retried according to the configuration of the driver
+func withRetry(client *http.Client, driver *GDriver) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	wrapped := *client
+	wrapped.Transport = &retryTransport{base: base, driver: driver}
+
+	return &wrapped
+}
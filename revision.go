@@ -0,0 +1,125 @@
+package gdrive // nolint: golint
+
+import (
+	"io"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Revision is a version of the content of a file, as kept by Google Drive
+type Revision struct {
+	ID           string    // ID identifies the revision, to download or delete it
+	ModifiedTime time.Time // ModifiedTime is when the revision was uploaded
+	Size         int64     // Size is the size of the content, it is 0 for the Google Workspace documents
+	MD5          string    // MD5 is the hex-encoded MD5 checksum of the content, if it has a binary content
+	KeepForever  bool      // KeepForever is set if the revision isn't automatically purged
+}
+
+// revisionFields are the fields of the revisions returned by ListRevisions
+const revisionFields = "revisions(id,modifiedTime,size,md5Checksum,keepForever),nextPageToken"
+
+// getRevisedFile returns the file whose revisions are accessed, revisions only exist for files
+func (d *GDriver) getRevisedFile(path string) (*FileInfo, error) {
+	fi, err := d.getFile(path, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, FileIsDirectoryError{Path: fi.Path()}
+	}
+
+	return fi, nil
+}
+
+// ListRevisions lists the revisions of a file, from the oldest to the current one. Google Drive only keeps
+// the revisions of the last 30 days or the last 100 ones, unless they are marked to be kept forever.
+func (d *GDriver) ListRevisions(path string) ([]*Revision, error) {
+	fi, err := d.getRevisedFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []*Revision
+
+	call := d.srv.Revisions.List(fi.file.Id).Fields(revisionFields)
+
+	for {
+		list, err := call.Do()
+		if err != nil {
+			return nil, &DriveAPICallError{Err: err}
+		}
+
+		for _, rev := range list.Revisions {
+			modifiedTime, _ := time.Parse(time.RFC3339, rev.ModifiedTime)
+
+			revisions = append(revisions, &Revision{
+				ID:           rev.Id,
+				ModifiedTime: modifiedTime,
+				Size:         rev.Size,
+				MD5:          rev.Md5Checksum,
+				KeepForever:  rev.KeepForever,
+			})
+		}
+
+		if list.NextPageToken == "" {
+			return revisions, nil
+		}
+
+		call.PageToken(list.NextPageToken)
+	}
+}
+
+// DownloadRevision opens the content of a revision of a file, as listed by ListRevisions. The revisions of
+// the Google Workspace documents can't be downloaded.
+func (d *GDriver) DownloadRevision(path, revisionID string) (io.ReadCloser, error) {
+	fi, err := d.getRevisedFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isGoogleDoc(fi.file) {
+		return nil, &NativeDocReadError{Path: fi.Path(), MimeType: fi.file.MimeType}
+	}
+
+	// The resulting stream will be closed by the caller
+	response, err := d.srv.Revisions.Get(fi.file.Id, revisionID).Context(d.ctx).Download()
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	return response.Body, nil
+}
+
+// DeleteRevision permanently deletes a revision of a file. The current revision of a file can't be deleted.
+func (d *GDriver) DeleteRevision(path, revisionID string) error {
+	fi, err := d.getRevisedFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := d.srv.Revisions.Delete(fi.file.Id, revisionID).Do(); err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	return nil
+}
+
+// KeepRevision sets whether a revision of a file is kept forever instead of being purged after some time
+func (d *GDriver) KeepRevision(path, revisionID string, keepForever bool) error {
+	fi, err := d.getRevisedFile(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.srv.Revisions.Update(fi.file.Id, revisionID, &drive.Revision{
+		KeepForever:     keepForever,
+		ForceSendFields: []string{"KeepForever"},
+	}).Fields("id").Do()
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	return nil
+}
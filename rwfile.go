@@ -0,0 +1,246 @@
+package gdrive // nolint: golint
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// ReadWriteFile is a file opened with os.O_RDWR. Its whole content is downloaded in memory when it is
+// opened, it can then be read, written and seeked freely, and it is uploaded back when it is synced or
+// closed, if it was modified.
+type ReadWriteFile struct {
+	driver     *GDriver  // driver is the driver the file was opened with
+	fileInfo   *FileInfo // fileInfo is the info of the file, updated after each upload
+	path       string    // path is the path used to open the file
+	content    []byte    // content is the current content of the file
+	offset     int64     // offset is the current position in the file
+	appendMode bool      // appendMode is set when the file was opened with os.O_APPEND
+	dirty      bool      // dirty is set when the content was modified since the last upload
+	closed     bool      // closed is set once the file has been closed
+	closeErr   error     // closeErr is the result of the first Close
+}
+
+// openFileReadWrite opens a file in read-write mode, creating it if flag contains os.O_CREATE
+func (d *GDriver) openFileReadWrite(path string, flag int) (afero.File, error) {
+	fi, err := d.getFile(path, listFields...)
+
+	created := false
+
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, &FileExistError{Path: path}
+		}
+
+		if err := d.checkWritable(fi, fi.Path()); err != nil {
+			return nil, err
+		}
+	case IsNotExist(err) && flag&os.O_CREATE != 0:
+		if fi, err = d.createFile(path); err != nil {
+			return nil, err
+		}
+
+		created = true
+	default:
+		return nil, err
+	}
+
+	f := &ReadWriteFile{
+		driver:     d,
+		fileInfo:   fi,
+		path:       path,
+		appendMode: flag&os.O_APPEND != 0,
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		// The truncation is only applied on Drive when the file is uploaded, like any other change
+		f.dirty = !created
+	} else if !created {
+		if f.content, err = d.downloadContent(context.Background(), fi, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// Close uploads the content if it was modified and releases it. Closing the file again has no effect and
+// returns the result of the first call.
+func (f *ReadWriteFile) Close() error {
+	if f.closed {
+		return f.closeErr
+	}
+
+	f.closeErr = f.Sync()
+	f.closed = true
+	f.content = nil
+
+	return f.closeErr
+}
+
+// Sync uploads the content if it was modified since the last upload
+func (f *ReadWriteFile) Sync() error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+
+	if !f.dirty {
+		return nil
+	}
+
+	d := f.driver
+
+	file, err := d.srv.Files.Update(f.fileInfo.file.Id, nil).
+		Fields(fileInfoFields...).
+		SupportsAllDrives(true).
+		Media(bytes.NewReader(f.content)).
+		Do()
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	f.fileInfo = d.newFileInfo(file, f.fileInfo.parentPath)
+	f.dirty = false
+
+	return nil
+}
+
+// Name returns the path used to open the file
+func (f *ReadWriteFile) Name() string {
+	return f.path
+}
+
+// Read reads from the current position
+func (f *ReadWriteFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+// ReadAt reads from a given position
+func (f *ReadWriteFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+
+	if off < 0 {
+		return 0, ErrInvalidSeek
+	}
+
+	if off >= int64(len(f.content)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.content[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Seek changes the current position
+func (f *ReadWriteFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.content))
+	}
+
+	if offset < 0 {
+		return 0, ErrInvalidSeek
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Write writes at the current position, or at the end of the file if it was opened with os.O_APPEND
+func (f *ReadWriteFile) Write(p []byte) (int, error) {
+	if f.appendMode {
+		f.offset = int64(len(f.content))
+	}
+
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+// WriteAt writes at a given position, the file is extended with zeros if the position is beyond its end
+func (f *ReadWriteFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+
+	if off < 0 {
+		return 0, ErrInvalidSeek
+	}
+
+	if end := off + int64(len(p)); end > int64(len(f.content)) {
+		f.resize(end)
+	}
+
+	copy(f.content[off:], p)
+	f.dirty = true
+
+	return len(p), nil
+}
+
+// WriteString writes a string at the current position
+func (f *ReadWriteFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Truncate changes the size of the file
+func (f *ReadWriteFile) Truncate(size int64) error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+
+	if size < 0 {
+		return ErrInvalidSeek
+	}
+
+	f.resize(size)
+	f.dirty = true
+
+	return nil
+}
+
+// resize changes the size of the content, padding it with zeros
+func (f *ReadWriteFile) resize(size int64) {
+	if size <= int64(len(f.content)) {
+		f.content = f.content[:size]
+
+		return
+	}
+
+	f.content = append(f.content, make([]byte, size-int64(len(f.content)))...)
+}
+
+// Readdir isn't possible on a file
+func (f *ReadWriteFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, FileIsNotDirectoryError{Fi: f.fileInfo}
+}
+
+// Readdirnames isn't possible on a file
+func (f *ReadWriteFile) Readdirnames(int) ([]string, error) {
+	return nil, FileIsNotDirectoryError{Fi: f.fileInfo}
+}
+
+// Stat provides the file information, as of the last upload
+func (f *ReadWriteFile) Stat() (os.FileInfo, error) {
+	return f.fileInfo, nil
+}
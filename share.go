@@ -0,0 +1,163 @@
+package gdrive // nolint: golint
+
+import (
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// SharePermission describes a permission granted on a file
+type SharePermission struct {
+	Type               string // Type is "user", "group", "domain" or "anyone"
+	Role               string // Role is "reader", "commenter", "writer", "fileOrganizer", "organizer" or "owner"
+	EmailAddress       string // EmailAddress is the address of the user or group, for these types
+	Domain             string // Domain is the domain the permission applies to, for the "domain" type
+	AllowFileDiscovery bool   // AllowFileDiscovery makes the file discoverable through search, for "domain" and "anyone"
+}
+
+// Permission is a permission granted on a file
+type Permission struct {
+	SharePermission
+	ID          string // ID identifies the permission, to remove it with RemovePermission
+	WebViewLink string // WebViewLink is the link to the file, which anyone can open once shared with "anyone"
+}
+
+// permissionFields are the fields of the permissions returned by Grant and ListPermissions
+var permissionFields = []googleapi.Field{"id", "type", "role", "emailAddress", "domain", "allowFileDiscovery"}
+
+func newPermission(perm *drive.Permission, webViewLink string) *Permission {
+	return &Permission{
+		SharePermission: SharePermission{
+			Type:               perm.Type,
+			Role:               perm.Role,
+			EmailAddress:       perm.EmailAddress,
+			Domain:             perm.Domain,
+			AllowFileDiscovery: perm.AllowFileDiscovery,
+		},
+		ID:          perm.Id,
+		WebViewLink: webViewLink,
+	}
+}
+
+// DefaultPermissionError is returned when the default permissions couldn't be applied to a file that
+// was created
+type DefaultPermissionError struct {
+	Path string
+	Err  error
+}
+
+func (e *DefaultPermissionError) Error() string {
+	return fmt.Sprintf("couldn't apply default permissions to `%s': %v", e.Path, e.Err)
+}
+
+// Unwrap exposes the underlying error
+func (e *DefaultPermissionError) Unwrap() error {
+	return e.Err
+}
+
+func (d *GDriver) share(fileID string, perm SharePermission) (*drive.Permission, error) {
+	created, err := d.srv.Permissions.Create(fileID, &drive.Permission{
+		Type:               perm.Type,
+		Role:               perm.Role,
+		EmailAddress:       perm.EmailAddress,
+		Domain:             perm.Domain,
+		AllowFileDiscovery: perm.AllowFileDiscovery,
+	}).Fields(permissionFields...).SupportsAllDrives(true).Do()
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	return created, nil
+}
+
+// Share grants a permission on a file or directory
+func (d *GDriver) Share(path string, perm SharePermission) error {
+	_, err := d.Grant(path, perm)
+
+	return err
+}
+
+// Grant grants a permission on a file or directory like Share, and returns the created permission along with
+// the link to the file. Sharing with the "anyone" type and the "reader" role creates a shareable link.
+func (d *GDriver) Grant(path string, perm SharePermission) (*Permission, error) {
+	fi, err := d.getFile(path, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := d.share(fi.file.Id, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPermission(created, fi.WebViewLink()), nil
+}
+
+// ListPermissions lists the permissions granted on a file or directory, including the ones of its owners
+func (d *GDriver) ListPermissions(path string) ([]*Permission, error) {
+	fi, err := d.getFile(path, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []*Permission
+
+	call := d.srv.Permissions.List(fi.file.Id).
+		Fields(googleapi.Field(fmt.Sprintf("permissions(%s),nextPageToken", googleapi.CombineFields(permissionFields)))).
+		SupportsAllDrives(true)
+
+	for {
+		list, err := call.Do()
+		if err != nil {
+			return nil, &DriveAPICallError{Err: err}
+		}
+
+		for _, perm := range list.Permissions {
+			permissions = append(permissions, newPermission(perm, fi.WebViewLink()))
+		}
+
+		if list.NextPageToken == "" {
+			return permissions, nil
+		}
+
+		call.PageToken(list.NextPageToken)
+	}
+}
+
+// RemovePermission revokes a permission of a file or directory, as identified by the ID of the Permission
+func (d *GDriver) RemovePermission(path string, permissionID string) error {
+	fi, err := d.getFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := d.srv.Permissions.Delete(fi.file.Id, permissionID).SupportsAllDrives(true).Do(); err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	return nil
+}
+
+// applyDefaultPermissions grants the DefaultPermissions on a file that was just created. Failures are only
+// logged when IgnoreDefaultPermissionErrors is set.
+func (d *GDriver) applyDefaultPermissions(fi *FileInfo) error {
+	for _, perm := range d.DefaultPermissions {
+		if _, err := d.share(fi.file.Id, perm); err != nil {
+			if d.IgnoreDefaultPermissionErrors {
+				d.Logger.Warn("Couldn't apply default permission",
+					"path", fi.Path(),
+					"type", perm.Type,
+					"role", perm.Role,
+					"err", err,
+				)
+
+				continue
+			}
+
+			return &DefaultPermissionError{Path: fi.Path(), Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package gdrive // nolint: golint
+
+import (
+	"os"
+)
+
+// ReaddirResolved lists a directory like Readdir, except that the shortcuts are replaced by the files or
+// directories they point to, as the user sees them in Drive. The targets are fetched together in batches. Each
+// resolved entry keeps the name of its shortcut, so that its path stays within the listed directory, and gets
+// all the other information of its target. The shortcuts whose target can't be fetched are dropped, unless
+// KeepUnresolvedShortcuts is set.
+func (d *GDriver) ReaddirResolved(dirPath string) ([]os.FileInfo, error) {
+	dirPath, err := normalizePath(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := d.getFile(dirPath, listFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := d.listDirectory(&File{driver: d, Path: dirPath, FileInfo: dir}, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		shortcuts []int
+		targetIDs []string
+	)
+
+	for i, f := range files {
+		file := f.(*FileInfo).file
+		if file.MimeType == mimeTypeShortcut && file.ShortcutDetails != nil {
+			shortcuts = append(shortcuts, i)
+			targetIDs = append(targetIDs, file.ShortcutDetails.TargetId)
+		}
+	}
+
+	if len(shortcuts) == 0 {
+		return files, nil
+	}
+
+	targets, errs, err := d.srvWrapper.getFiles(targetIDs, fileInfoFields...)
+	if err != nil {
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	dropped := make(map[int]bool)
+
+	for j, i := range shortcuts {
+		if errs[j] != nil {
+			d.Logger.Debug("Couldn't resolve shortcut", "path", files[i].(*FileInfo).Path(), "err", errs[j])
+
+			if !d.KeepUnresolvedShortcuts {
+				dropped[i] = true
+			}
+
+			continue
+		}
+
+		target := *targets[j]
+		target.Name = files[i].Name()
+		files[i] = d.newFileInfo(&target, dir.Path())
+	}
+
+	resolved := files[:0]
+
+	for i, f := range files {
+		if !dropped[i] {
+			resolved = append(resolved, f)
+		}
+	}
+
+	return resolved, nil
+}
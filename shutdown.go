@@ -0,0 +1,68 @@
+package gdrive // nolint: golint
+
+import (
+	"context"
+)
+
+// pendingWrite tracks a file opened for writing until it is closed
+type pendingWrite struct {
+	done chan struct{} // done is closed once the file is closed
+	err  error         // err is the error returned when closing the file
+}
+
+func (d *GDriver) registerWriter(f *File) {
+	d.writersMu.Lock()
+	defer d.writersMu.Unlock()
+
+	d.writers[f] = &pendingWrite{done: make(chan struct{})}
+}
+
+func (d *GDriver) unregisterWriter(f *File, err error) {
+	d.writersMu.Lock()
+	defer d.writersMu.Unlock()
+
+	if pending, ok := d.writers[f]; ok {
+		pending.err = err
+		close(pending.done)
+		delete(d.writers, f)
+	}
+}
+
+// Shutdown waits for the files currently opened for writing to be closed, which is when their content
+// is flushed and uploaded. It returns once they are all closed or when the context is done. If some of
+// them couldn't be written, or weren't closed in time, a ShutdownError listing them is returned.
+func (d *GDriver) Shutdown(ctx context.Context) error {
+	d.writersMu.Lock()
+	pending := make(map[string]*pendingWrite, len(d.writers))
+
+	for f, p := range d.writers {
+		pending[f.Path] = p
+	}
+
+	d.writersMu.Unlock()
+
+	errs := make(map[string]error)
+
+	for path, p := range pending {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+		}
+
+		// Once the context is done, the files that were closed in time are still checked
+		select {
+		case <-p.done:
+			if p.err != nil {
+				errs[path] = p.err
+			}
+		default:
+			errs[path] = ctx.Err()
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ShutdownError{Errors: errs}
+	}
+
+	return nil
+}
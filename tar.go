@@ -0,0 +1,117 @@
+package gdrive // nolint: golint
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"sort"
+)
+
+const (
+	// tarDirModeDefault is the mode of the archived directories that don't have one
+	tarDirModeDefault = os.FileMode(0o755)
+
+	// tarFileModeDefault is the mode of the archived files that don't have one
+	tarFileModeDefault = os.FileMode(0o644)
+)
+
+// TarFolder writes a tar archive of the content of a directory to w. Entries are named relatively to the
+// directory and carry the mode, modification time and size of the files. The archive is streamed, the
+// files are downloaded one after the other while being written. Google Workspace documents and shortcuts are
+// skipped as they don't have any content of their own, ExportCopy can be used to export documents.
+func (d *GDriver) TarFolder(dirPath string, w io.Writer) error {
+	dir, err := d.getFile(dirPath, listFields...)
+	if err != nil {
+		return err
+	}
+
+	if !dir.IsDir() {
+		return FileIsNotDirectoryError{Fi: dir}
+	}
+
+	tw := tar.NewWriter(w)
+
+	if err := d.tarDirectory(tw, dir, ""); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// tarDirectory adds the content of a directory to a tar archive, under the prefix name
+func (d *GDriver) tarDirectory(tw *tar.Writer, dir *FileInfo, prefix string) error {
+	children, err := d.srvWrapper.listAllChildren(dir.folderID(), listFields...)
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+
+	for _, child := range children {
+		if child.MimeType == mimeTypeShortcut {
+			continue
+		}
+
+		fi := d.newFileInfo(child, dir.Path())
+		name := path.Join(prefix, fi.Name())
+
+		switch {
+		case fi.IsDir():
+			if err := tw.WriteHeader(tarHeader(fi, name+"/", tar.TypeDir, tarDirModeDefault)); err != nil {
+				return err
+			}
+
+			if err := d.tarDirectory(tw, fi, name); err != nil {
+				return err
+			}
+		case fi.IsNative():
+			continue
+		default:
+			if err := d.tarFile(tw, fi, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// tarFile adds a regular file to a tar archive
+func (d *GDriver) tarFile(tw *tar.Writer, fi *FileInfo, name string) error {
+	reader, err := d.getFileReader(fi, 0)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	if err := tw.WriteHeader(tarHeader(fi, name, tar.TypeReg, tarFileModeDefault)); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, reader)
+
+	return err
+}
+
+// tarHeader creates the header of an entry, defaultMode is used if the file doesn't have a mode
+func tarHeader(fi *FileInfo, name string, typeFlag byte, defaultMode os.FileMode) *tar.Header {
+	mode := fi.Mode().Perm()
+	if mode == 0 {
+		mode = defaultMode
+	}
+
+	header := &tar.Header{
+		Typeflag: typeFlag,
+		Name:     name,
+		Mode:     int64(mode),
+		ModTime:  fi.ModTime(),
+	}
+
+	if typeFlag == tar.TypeReg {
+		header.Size = fi.Size()
+	}
+
+	return header
+}
@@ -0,0 +1,67 @@
+package gdrive // nolint: golint
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// unauthorizedTransport gives a chance to the OnUnauthorized handler of the driver to restore the
+// authorization when the API rejects a call, and then retries the call once
+type unauthorizedTransport struct {
+	base   http.RoundTripper // base performs the calls
+	driver *GDriver          // driver provides the OnUnauthorized handler
+}
+
+func (t *unauthorizedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	_ = resp.Body.Close()
+
+	handler := t.driver.OnUnauthorized
+	if handler == nil {
+		return nil, ErrReauthRequired
+	}
+
+	if err := handler(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReauthRequired, err)
+	}
+
+	// Calls streaming their content can't be replayed
+	if req.Body != nil && req.GetBody == nil {
+		return nil, ErrReauthRequired
+	}
+
+	retry := req.Clone(req.Context())
+
+	if req.Body != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err = t.base.RoundTrip(retry)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		_ = resp.Body.Close()
+
+		return nil, ErrReauthRequired
+	}
+
+	return resp, err
+}
+
+// withUnauthorizedHandler returns a copy of the client whose calls go through the OnUnauthorized handler
+// of the driver
+func withUnauthorizedHandler(client *http.Client, driver *GDriver) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	wrapped := *client
+	wrapped.Transport = &unauthorizedTransport{base: base, driver: driver}
+
+	return &wrapped
+}
@@ -0,0 +1,212 @@
+package gdrive // nolint: golint
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// sizedReader reads exactly a given amount of bytes from a reader, io.ErrUnexpectedEOF is returned if
+// the reader ends before.
+type sizedReader struct {
+	reader    io.Reader // reader is the source
+	remaining int64     // remaining is the number of bytes left to read
+	truncated bool      // truncated is set if the source ended too early
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+
+	if errors.Is(err, io.EOF) && r.remaining > 0 {
+		r.truncated = true
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// UploadSized uploads the content of a reader to a file, creating it if it doesn't exist.
+// When size isn't -1, exactly size bytes are uploaded: any extra content is ignored and the upload fails
+// with io.ErrUnexpectedEOF if the reader provides less, so that a truncated source doesn't silently
+// replace the file content. Large contents are sent as a resumable upload. Like with OpenFile, a native
+// document is only replaced if OverwriteNativeDocs is set.
+func (d *GDriver) UploadSized(path string, r io.Reader, size int64) (*FileInfo, error) {
+	path, err := normalizePath(d.creationPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := d.getFileInfoFromPath(path)
+	if IsNotExist(err) {
+		fi, err = d.createFile(path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.checkWritable(fi, path); err != nil {
+		return nil, err
+	}
+
+	var (
+		options []googleapi.MediaOption
+		sized   *sizedReader
+	)
+
+	if size >= 0 {
+		sized = &sizedReader{reader: r, remaining: size}
+		r = sized
+
+		// Small files are streamed in a single request instead of being buffered first
+		if size < googleapi.DefaultUploadChunkSize {
+			options = append(options, googleapi.ChunkSize(0))
+		}
+	}
+
+	file, err := d.srv.Files.Update(fi.file.Id, nil).
+		Fields(fileInfoFields...).
+		SupportsAllDrives(true).
+		Media(r, options...).
+		Do()
+	if err != nil {
+		// The upload machinery doesn't keep the error chain of the media reader
+		if sized != nil && sized.truncated {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, &DriveAPICallError{Err: err}
+	}
+
+	d.srvWrapper.invalidateFile(fi.file)
+
+	// Documents converted on upload don't have a size of their own
+	if size >= 0 && file.Size != size && !isGoogleDoc(file) {
+		return nil, &SizeMismatchError{Path: fi.Path(), Expected: size, Actual: file.Size}
+	}
+
+	return d.newFileInfo(file, fi.parentPath), nil
+}
+
+// CreateWith creates a file from a template, allowing to set all its metadata along with its content in
+// a single call. The name and parents of the template are replaced by the ones of the path and the parent
+// directories are created if needed. The content is read from r, which can be nil to create an empty file.
+// A FileExistError is returned if the file already exists.
+func (d *GDriver) CreateWith(filePath string, template *drive.File, r io.Reader) (*FileInfo, error) {
+	file, parentPath, err := d.prepareCreation(filePath, template)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := d.srvWrapper.createFileFrom(file, r, fileInfoFields...)
+	if err != nil {
+		return nil, err
+	}
+
+	fi := &FileInfo{
+		file:       created,
+		parentPath: parentPath,
+	}
+
+	if err := d.applyDefaultPermissions(fi); err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+// CreateAs creates a file whose content is converted by Google Drive into a Google Workspace document of the
+// given mime type, like "application/vnd.google-apps.spreadsheet" for a CSV or an Excel file. The content
+// written to the returned file is sent in its original format, which is deduced from the extension of the
+// path when possible, and the document is only created when the file is closed. Its FileInfo then describes
+// the converted document. A FileExistError is returned if the file already exists.
+func (d *GDriver) CreateAs(filePath string, mimeType string) (*File, error) {
+	file, parentPath, err := d.prepareCreation(filePath, &drive.File{MimeType: mimeType})
+	if err != nil {
+		return nil, err
+	}
+
+	var options []googleapi.MediaOption
+	if sourceMime := mime.TypeByExtension(path.Ext(file.Name)); sourceMime != "" {
+		options = append(options, googleapi.ContentType(sourceMime))
+	}
+
+	fi := &FileInfo{
+		file:       file,
+		parentPath: parentPath,
+	}
+
+	return d.openUpload(fi, fi.Path(), func(ctx context.Context, media io.Reader) (*drive.File, error) {
+		created, err := d.srvWrapper.createFileContext(ctx, file, media, options, fileInfoFields...)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := d.applyDefaultPermissions(&FileInfo{file: created, parentPath: parentPath}); err != nil {
+			return nil, err
+		}
+
+		return created, nil
+	})
+}
+
+// prepareCreation checks that a file can be created at a path, creates its parent directories and returns
+// a copy of template named and placed accordingly, along with the path of its parent
+func (d *GDriver) prepareCreation(filePath string, template *drive.File) (*drive.File, string, error) {
+	pathParts, err := splitPath(d.creationPath(filePath))
+	if err != nil {
+		return nil, "", err
+	}
+
+	amountOfParts := len(pathParts)
+
+	if amountOfParts == 0 {
+		return nil, "", ErrEmptyPath
+	}
+
+	if _, err = d.getFileByParts(d.rootNode, pathParts, listFields...); err == nil {
+		return nil, "", &FileExistError{Path: path.Join(pathParts...)}
+	} else if !IsNotExist(err) {
+		return nil, "", err
+	}
+
+	parentNode, err := d.makeDirectoryByParts(pathParts[:amountOfParts-1])
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !parentNode.IsDir() {
+		return nil, "", &FileIsNotDirectoryError{
+			Fi:   parentNode,
+			Path: path.Join(pathParts[:amountOfParts-1]...),
+		}
+	}
+
+	file := &drive.File{}
+	if template != nil {
+		*file = *template
+	}
+
+	file.Name = sanitizeName(pathParts[amountOfParts-1])
+	file.Parents = []string{parentNode.folderID()}
+
+	if file.Description == "" && !d.srvWrapper.noDescription {
+		file.Description = createdDescription
+	}
+
+	return file, path.Join(pathParts[:amountOfParts-1]...), nil
+}
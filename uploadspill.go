@@ -0,0 +1,157 @@
+package gdrive // nolint: golint
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/spf13/afero"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// uploadSpill keeps a copy of the content streamed to an upload in a temporary file, so that the upload can
+// be replayed if it fails
+type uploadSpill struct {
+	file       afero.File // file is the temporary file
+	fs         afero.Fs   // fs is the filesystem of the temporary file
+	maxSize    int64      // maxSize is the maximum size of the content
+	size       int64      // size is the size of the content written so far
+	exceeded   bool       // exceeded is set once more than maxSize bytes were written
+	onExceeded func()     // onExceeded is called when more than maxSize bytes are written
+}
+
+// newUploadSpill creates the spill of an upload if UploadSpillFs is set, it returns nil otherwise
+func (d *GDriver) newUploadSpill() (*uploadSpill, error) {
+	if d.UploadSpillFs == nil {
+		return nil, nil
+	}
+
+	tmp, err := afero.TempFile(d.UploadSpillFs, "", "gdrive-upload-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadSpill{file: tmp, fs: d.UploadSpillFs, maxSize: d.UploadSpillMaxSize}, nil
+}
+
+func (s *uploadSpill) Write(p []byte) (int, error) {
+	if err := s.checkSize(len(p)); err != nil {
+		return 0, err
+	}
+
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+
+	return n, err
+}
+
+// checkSize returns ErrUploadSpillExceeded if n more bytes of content would exceed maxSize
+func (s *uploadSpill) checkSize(n int) error {
+	if s.size+int64(n) <= s.maxSize {
+		return nil
+	}
+
+	s.exceeded = true
+
+	if s.onExceeded != nil {
+		s.onExceeded()
+	}
+
+	return ErrUploadSpillExceeded
+}
+
+// spillRemainder reads the content that wasn't read by the failed upload when it is replayed. It isn't
+// written to the spill anymore, but its size is still checked against the maximum size of the spill.
+type spillRemainder struct {
+	media io.Reader    // media is the rest of the content
+	spill *uploadSpill // spill accounts for the size of the content
+}
+
+func (r *spillRemainder) Read(p []byte) (int, error) {
+	n, err := r.media.Read(p)
+
+	if errSize := r.spill.checkSize(n); errSize != nil {
+		return 0, errSize
+	}
+
+	r.spill.size += int64(n)
+
+	return n, err
+}
+
+// replay returns a reader of the content written so far
+func (s *uploadSpill) replay() (io.Reader, error) {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return io.LimitReader(s.file, s.size), nil
+}
+
+// close closes and removes the temporary file
+func (s *uploadSpill) close() {
+	_ = s.file.Close()
+	_ = s.fs.Remove(s.file.Name())
+}
+
+// runUpload performs an upload whose content is read from media. With a spill, the content is copied to it
+// while it is uploaded and if the upload fails with a transient error, it is performed a second time with
+// the content of the spill followed by the rest of media. The content can't exceed the maximum size of the
+// spill in both cases.
+func (d *GDriver) runUpload(
+	ctx context.Context,
+	upload uploadFunc,
+	media io.Reader,
+	spill *uploadSpill,
+) (*drive.File, error) {
+	if spill == nil {
+		return upload(ctx, media)
+	}
+
+	defer spill.close()
+
+	file, err := uploadWithinSpill(ctx, upload, io.TeeReader(media, spill), spill)
+	if err == nil || !isTransientUploadError(err) || ctx.Err() != nil {
+		return file, err
+	}
+
+	d.Logger.Warn("Upload failed, retrying it from the spill", "size", spill.size, "err", err)
+
+	replay, errReplay := spill.replay()
+	if errReplay != nil {
+		return nil, err
+	}
+
+	return uploadWithinSpill(ctx, upload, io.MultiReader(replay, &spillRemainder{media: media, spill: spill}), spill)
+}
+
+// uploadWithinSpill performs an upload that is aborted as soon as the maximum size of the spill is exceeded,
+// the upload machinery would otherwise still perform the call without content
+func uploadWithinSpill(
+	ctx context.Context,
+	upload uploadFunc,
+	content io.Reader,
+	spill *uploadSpill,
+) (*drive.File, error) {
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	spill.onExceeded = cancel
+
+	file, err := upload(uploadCtx, content)
+
+	// The upload machinery doesn't keep the error chain of the media reader
+	if spill.exceeded {
+		return nil, ErrUploadSpillExceeded
+	}
+
+	return file, err
+}
+
+// isTransientUploadError returns true if an upload failed because of a rate-limit or a transient server error
+func isTransientUploadError(err error) bool {
+	var apiErr *googleapi.Error
+
+	return errors.As(err, &apiErr) && (isRetryableStatus(apiErr.Code) || isRateLimitError(err))
+}
@@ -0,0 +1,177 @@
+package gdrive // nolint: golint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"google.golang.org/api/googleapi"
+)
+
+// FileSig is the expected signature of a file
+type FileSig struct {
+	Size int64  // Size is the expected size in bytes
+	MD5  string // MD5 is the expected hex-encoded MD5 checksum of the content
+}
+
+// Verify checks that the files described by a manifest exist with the expected size and MD5. The checksums
+// are taken from the files metadata, so no content is downloaded. Paths are returned sorted: missing lists the
+// paths that don't exist, mismatched the ones whose size or checksum differ or that aren't regular files.
+func (d *GDriver) Verify(manifest map[string]FileSig) (missing, mismatched []string, err error) {
+	paths := make([]string, 0, len(manifest))
+	for p := range manifest {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		fi, err := d.getFile(p, listFields...)
+		if err != nil {
+			if IsNotExist(err) {
+				missing = append(missing, p)
+
+				continue
+			}
+
+			return missing, mismatched, err
+		}
+
+		sig := manifest[p]
+		if fi.IsDir() || fi.file.Size != sig.Size || fi.file.Md5Checksum != sig.MD5 {
+			mismatched = append(mismatched, p)
+		}
+	}
+
+	return missing, mismatched, nil
+}
+
+// GetFileHash returns the hex-encoded checksum of a file, as computed by Google Drive. No content is downloaded.
+// Only the files with a binary content have a checksum, the SHA-1 and SHA-256 ones can also be missing for
+// some of them.
+func (d *GDriver) GetFileHash(path string, method HashMethod) (string, error) {
+	var field googleapi.Field
+
+	switch method {
+	case HashMD5:
+		field = "md5Checksum"
+	case HashSHA1:
+		field = "sha1Checksum"
+	case HashSHA256:
+		field = "sha256Checksum"
+	default:
+		return "", ErrUnknownHashMethod
+	}
+
+	fi, err := d.getFile(path, d.fileFields(field))
+	if err != nil {
+		return "", err
+	}
+
+	if fi.IsDir() {
+		return "", FileIsDirectoryError{Path: fi.Path()}
+	}
+
+	var hash string
+
+	switch method {
+	case HashMD5:
+		hash = fi.file.Md5Checksum
+	case HashSHA1:
+		hash = fi.file.Sha1Checksum
+	case HashSHA256:
+		hash = fi.file.Sha256Checksum
+	}
+
+	if hash == "" {
+		return "", &ChecksumUnavailableError{Path: fi.Path()}
+	}
+
+	return hash, nil
+}
+
+// TreeHash returns a hex-encoded SHA-256 hash of the content of a directory, computed from the metadata of
+// its descendants, so no content is downloaded. Two trees with the same layout and the same file contents get
+// the same hash, whatever their IDs, names of their roots and modification times are.
+//
+// Each descendant contributes one line, and the lines are sorted by byte order before being hashed:
+//   - directories: `d "<path>"`, so that empty directories are accounted for
+//   - regular files: `f "<path>" <size> <md5Checksum>`
+//   - Google Workspace documents: `g "<path>" <mimeType>`, as they don't have a checksum, changing their
+//     content doesn't change the hash. They are skipped altogether with GoogleDocSkipInListings.
+//   - shortcuts: `s "<path>" <target ID>`, the target isn't hashed
+//
+// Paths are relative to the directory, separated by "/" and quoted with the Go syntax. Trashed files are
+// ignored. A ChecksumUnavailableError is returned if a regular file doesn't have a checksum yet.
+func (d *GDriver) TreeHash(dirPath string) (string, error) {
+	dir, err := d.getFile(dirPath, listFields...)
+	if err != nil {
+		return "", err
+	}
+
+	if !dir.IsDir() {
+		return "", FileIsNotDirectoryError{Fi: dir}
+	}
+
+	var lines []string
+
+	if err := d.treeHashLines(dir, "", &lines); err != nil {
+		return "", err
+	}
+
+	sort.Strings(lines)
+
+	hash := sha256.New()
+	for _, line := range lines {
+		_, _ = io.WriteString(hash, line)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// treeHashLines adds the lines of the descendants of a directory, whose relative path is prefix
+func (d *GDriver) treeHashLines(dir *FileInfo, prefix string, lines *[]string) error {
+	fields := googleapi.Field(fmt.Sprintf("files(%s)", googleapi.CombineFields(fileInfoFields)))
+
+	children, err := d.srvWrapper.listAllChildren(dir.folderID(), fields)
+	if err != nil {
+		return &DriveAPICallError{Err: err}
+	}
+
+	for _, child := range children {
+		if d.hiddenInListings(child) {
+			continue
+		}
+
+		name := path.Join(prefix, child.Name)
+
+		switch {
+		case child.MimeType == mimeTypeFolder:
+			*lines = append(*lines, fmt.Sprintf("d %q\n", name))
+
+			if err := d.treeHashLines(d.newFileInfo(child, dir.Path()), name, lines); err != nil {
+				return err
+			}
+		case child.MimeType == mimeTypeShortcut:
+			target := ""
+			if child.ShortcutDetails != nil {
+				target = child.ShortcutDetails.TargetId
+			}
+
+			*lines = append(*lines, fmt.Sprintf("s %q %s\n", name, target))
+		case isGoogleDoc(child):
+			*lines = append(*lines, fmt.Sprintf("g %q %s\n", name, child.MimeType))
+		default:
+			if child.Md5Checksum == "" {
+				return &ChecksumUnavailableError{Path: path.Join(dir.Path(), child.Name)}
+			}
+
+			*lines = append(*lines, fmt.Sprintf("f %q %d %s\n", name, child.Size, child.Md5Checksum))
+		}
+	}
+
+	return nil
+}
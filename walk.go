@@ -0,0 +1,153 @@
+package gdrive // nolint: golint
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"google.golang.org/api/googleapi"
+)
+
+// walkConcurrency is the maximum number of directories listed at the same time by Walk
+const walkConcurrency = 4
+
+// walkListing is the listing of a directory, performed in the background
+type walkListing struct {
+	done     chan struct{} // done is closed once the listing is complete
+	children []*FileInfo   // children are the entries of the directory, sorted by name
+	err      error         // err is the error of the listing
+}
+
+// walker lists the directories of a walk ahead of the calls to the walk function
+type walker struct {
+	driver    *GDriver          // driver performs the listings
+	fn        filepath.WalkFunc // fn is called for each file and directory
+	fields    googleapi.Field   // fields are the fields of the listed files
+	semaphore chan struct{}     // semaphore limits the number of listings in progress
+	stop      chan struct{}     // stop is closed when the walk ends, the pending listings are then dropped
+}
+
+// Walk walks the file tree rooted at root like filepath.Walk, calling fn for each file and directory in
+// lexical order, root included. The subdirectories of a directory are listed concurrently, with at most
+// walkConcurrency listings at the same time, while fn is called. fn can return filepath.SkipDir to skip a
+// directory, or the remaining entries of the directory of a file, and filepath.SkipAll to stop the walk.
+func (d *GDriver) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := d.getFile(root, listFields...)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		fields := d.fileFields()
+		if d.ListFilterFunc != nil {
+			fields = d.fileFields("capabilities")
+		}
+
+		w := &walker{
+			driver:    d,
+			fn:        fn,
+			fields:    fields,
+			semaphore: make(chan struct{}, walkConcurrency),
+			stop:      make(chan struct{}),
+		}
+
+		defer close(w.stop)
+
+		err = w.walk(root, info, nil)
+	}
+
+	if errors.Is(err, filepath.SkipDir) || errors.Is(err, filepath.SkipAll) {
+		return nil
+	}
+
+	return err
+}
+
+// list starts the listing of a directory in the background
+func (w *walker) list(dir *FileInfo) *walkListing {
+	listing := &walkListing{done: make(chan struct{})}
+
+	go func() {
+		defer close(listing.done)
+
+		select {
+		case w.semaphore <- struct{}{}:
+		case <-w.stop:
+			return
+		}
+
+		defer func() { <-w.semaphore }()
+
+		files, err := w.driver.srvWrapper.listAllChildren(dir.folderID(), w.fields)
+		if err != nil {
+			listing.err = &DriveAPICallError{Err: err}
+
+			return
+		}
+
+		for _, file := range files {
+			if w.driver.ListFilterFunc != nil && !w.driver.ListFilterFunc(file) {
+				continue
+			}
+
+			if w.driver.hiddenInListings(file) {
+				continue
+			}
+
+			listing.children = append(listing.children, w.driver.newFileInfo(file, dir.Path()))
+		}
+
+		sort.Slice(listing.children, func(i, j int) bool {
+			return listing.children[i].Name() < listing.children[j].Name()
+		})
+	}()
+
+	return listing
+}
+
+// walk calls fn for a file or directory and, for a directory, walks its entries from their listing
+func (w *walker) walk(filePath string, info *FileInfo, listing *walkListing) error {
+	if !info.IsDir() {
+		return w.fn(filePath, info, nil)
+	}
+
+	if listing == nil {
+		listing = w.list(info)
+	}
+
+	if err := w.fn(filePath, info, nil); err != nil {
+		return err
+	}
+
+	<-listing.done
+
+	if listing.err != nil {
+		// Like filepath.Walk, fn is called a second time for a directory that can't be listed
+		return w.fn(filePath, info, listing.err)
+	}
+
+	// The subdirectories are listed while fn is called on the entries that come before them
+	listings := make([]*walkListing, len(listing.children))
+
+	for i, child := range listing.children {
+		if child.IsDir() {
+			listings[i] = w.list(child)
+		}
+	}
+
+	for i, child := range listing.children {
+		err := w.walk(path.Join(filePath, child.Name()), child, listings[i])
+		if err == nil {
+			continue
+		}
+
+		if !child.IsDir() && errors.Is(err, filepath.SkipDir) {
+			return nil
+		}
+
+		if !errors.Is(err, filepath.SkipDir) {
+			return err
+		}
+	}
+
+	return nil
+}